@@ -1,14 +1,36 @@
 package hackernews
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/telemetry"
 )
 
+func get(ctx context.Context, url string) (*http.Response, error) {
+	ctx, span := telemetry.StartHTTP(ctx, "hackernews", "GET", url)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		span.End(0, err)
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		span.End(0, err)
+		return nil, err
+	}
+
+	span.End(resp.StatusCode, nil)
+	return resp, nil
+}
+
 // GetLatestItemId returns the current largest item id from the Hacker News API.
-func GetLatestItemId() (int, error) {
-	resp, err := http.Get("https://hacker-news.firebaseio.com/v0/maxitem.json")
+func GetLatestItemId(ctx context.Context) (int, error) {
+	resp, err := get(ctx, "https://hacker-news.firebaseio.com/v0/maxitem.json")
 	if err != nil {
 		return 0, err
 	}
@@ -29,8 +51,8 @@ func GetLatestItemId() (int, error) {
 }
 
 // GetStoryIds retrieves story ids from the given url.
-func GetStoryIds(url string) ([]int, error) {
-	resp, err := http.Get(url)
+func GetStoryIds(ctx context.Context, url string) ([]int, error) {
+	resp, err := get(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -51,31 +73,31 @@ func GetStoryIds(url string) ([]int, error) {
 }
 
 // GetNewStories retrieves new story ids.
-func GetNewStories() ([]int, error) {
-	return GetStoryIds("https://hacker-news.firebaseio.com/v0/newstories.json")
+func GetNewStories(ctx context.Context) ([]int, error) {
+	return GetStoryIds(ctx, "https://hacker-news.firebaseio.com/v0/newstories.json")
 }
 
 // GetTopStories retrieves top story ids.
-func GetTopStories() ([]int, error) {
-	return GetStoryIds("https://hacker-news.firebaseio.com/v0/topstories.json")
+func GetTopStories(ctx context.Context) ([]int, error) {
+	return GetStoryIds(ctx, "https://hacker-news.firebaseio.com/v0/topstories.json")
 }
 
 // GetBestStories retrieves best story ids.
-func GetBestStories() ([]int, error) {
-	return GetStoryIds("https://hacker-news.firebaseio.com/v0/beststories.json")
+func GetBestStories(ctx context.Context) ([]int, error) {
+	return GetStoryIds(ctx, "https://hacker-news.firebaseio.com/v0/beststories.json")
 }
 
 // GetJobStories retrieves job story ids.
-func GetJobStories() ([]int, error) {
-	return GetStoryIds("https://hacker-news.firebaseio.com/v0/jobstories.json")
+func GetJobStories(ctx context.Context) ([]int, error) {
+	return GetStoryIds(ctx, "https://hacker-news.firebaseio.com/v0/jobstories.json")
 }
 
 // GetAskStories retrieves ask story ids.
-func GetAskStories() ([]int, error) {
-	return GetStoryIds("https://hacker-news.firebaseio.com/v0/askstories.json")
+func GetAskStories(ctx context.Context) ([]int, error) {
+	return GetStoryIds(ctx, "https://hacker-news.firebaseio.com/v0/askstories.json")
 }
 
 // GetShowStories retrieves show story ids.
-func GetShowStories() ([]int, error) {
-	return GetStoryIds("https://hacker-news.firebaseio.com/v0/showstories.json")
+func GetShowStories(ctx context.Context) ([]int, error) {
+	return GetStoryIds(ctx, "https://hacker-news.firebaseio.com/v0/showstories.json")
 }
@@ -1,13 +1,15 @@
 package hackernews
 
+import "context"
+
 type FullStory struct {
 	Story    *Item   `json:"story"`
 	Comments []*Item `json:"comments"`
 }
 
-func FetchFullStory(story *Item) (*FullStory, error) {
+func FetchFullStory(ctx context.Context, story *Item) (*FullStory, error) {
 	processedComments := make(map[int]bool)
-	comments, err := fetchComments(story.Kids, processedComments)
+	comments, err := fetchComments(ctx, story.Kids, processedComments)
 	if err != nil {
 		return nil, err
 	}
@@ -20,20 +22,20 @@ func FetchFullStory(story *Item) (*FullStory, error) {
 	return swc, nil
 }
 
-func fetchComments(ids []int, processedComments map[int]bool) ([]*Item, error) {
+func fetchComments(ctx context.Context, ids []int, processedComments map[int]bool) ([]*Item, error) {
 	comments := make([]*Item, 0, len(ids))
 	for _, id := range ids {
 		if _, ok := processedComments[id]; ok {
 			continue
 		}
-		comment, err := GetItem(id)
+		comment, err := GetItem(ctx, id)
 		if err != nil {
 			return nil, err
 		}
 		processedComments[id] = true
 		comments = append(comments, comment)
 		if len(comment.Kids) > 0 {
-			childComments, err := fetchComments(comment.Kids, processedComments)
+			childComments, err := fetchComments(ctx, comment.Kids, processedComments)
 			if err != nil {
 				return nil, err
 			}
@@ -1,9 +1,9 @@
 package hackernews
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"net/http"
+	"log/slog"
 	"strconv"
 	"time"
 )
@@ -38,8 +38,8 @@ type Item struct {
 	Descendants int      `json:"descendants,omitempty"` // In the case of stories or polls, the total comment count.
 }
 
-func GetItem(id int) (*Item, error) {
-	resp, err := http.Get(itemBaseURL + strconv.Itoa(id) + ".json")
+func GetItem(ctx context.Context, id int) (*Item, error) {
+	resp, err := get(ctx, itemBaseURL+strconv.Itoa(id)+".json")
 	if err != nil {
 		return nil, err
 	}
@@ -54,8 +54,8 @@ func GetItem(id int) (*Item, error) {
 }
 
 // IterateStoriesByBatch retrieves stories by batch of a specified limit.
-func IterateItemsByBatch(batchSize int, cb func(batch []*Item) (bool, error)) error {
-	maxItem, err := GetLatestItemId()
+func IterateItemsByBatch(ctx context.Context, batchSize int, cb func(batch []*Item) (bool, error)) error {
+	maxItem, err := GetLatestItemId(ctx)
 	if err != nil {
 		return err
 	}
@@ -63,9 +63,12 @@ func IterateItemsByBatch(batchSize int, cb func(batch []*Item) (bool, error)) er
 	var items []*Item
 	for i := maxItem; i > -1; i -= batchSize {
 		for j := i; j > i-batchSize && j > -1; j-- {
-			item, err := GetItem(j)
+			item, err := GetItem(ctx, j)
 			if err != nil {
-				fmt.Printf("Error getting item with id %d: %v\n", j, err)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				slog.Warn("could not get hacker news item", "id", j, "error", err)
 				continue
 			}
 
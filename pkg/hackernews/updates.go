@@ -1,9 +1,9 @@
 package hackernews
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
-	"net/http"
 )
 
 // Updates represents the updated items and profiles.
@@ -13,8 +13,8 @@ type Updates struct {
 }
 
 // GetUpdates retrieves the updates.
-func GetUpdates() (Updates, error) {
-	resp, err := http.Get("https://hacker-news.firebaseio.com/v0/updates.json")
+func GetUpdates(ctx context.Context) (Updates, error) {
+	resp, err := get(ctx, "https://hacker-news.firebaseio.com/v0/updates.json")
 	if err != nil {
 		return Updates{}, err
 	}
@@ -1,10 +1,10 @@
 package hackernews
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 )
 
 type User struct {
@@ -20,9 +20,9 @@ type User struct {
 	Submitted []int `json:"submitted"`
 }
 
-func GetUser(userId string) (*User, error) {
+func GetUser(ctx context.Context, userId string) (*User, error) {
 	url := fmt.Sprintf("https://hacker-news.firebaseio.com/v0/user/%s.json", userId)
-	resp, err := http.Get(url)
+	resp, err := get(ctx, url)
 	if err != nil {
 		return nil, err
 	}
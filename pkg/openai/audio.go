@@ -0,0 +1,420 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
+)
+
+const (
+	urlSuffix_audiotranscriptions = "v1/audio/transcriptions"
+	urlSuffix_audiotranslations   = "v1/audio/translations"
+	urlSuffix_audiospeech         = "v1/audio/speech"
+)
+
+type AudioResponseFormat string
+
+const (
+	AudioResponseFormatJSON AudioResponseFormat = "json"
+	AudioResponseFormatText AudioResponseFormat = "text"
+	// AudioResponseFormatVerboseJSON adds the language, the duration and the segments of the audio to the text
+	AudioResponseFormatVerboseJSON AudioResponseFormat = "verbose_json"
+	AudioResponseFormatSRT         AudioResponseFormat = "srt"
+	AudioResponseFormatVTT         AudioResponseFormat = "vtt"
+)
+
+// TimestampGranularity is only supported with AudioResponseFormatVerboseJSON
+type TimestampGranularity string
+
+const (
+	TimestampGranularitySegment TimestampGranularity = "segment"
+	TimestampGranularityWord    TimestampGranularity = "word"
+)
+
+type Voice string
+
+const (
+	VoiceAlloy   Voice = "alloy"
+	VoiceEcho    Voice = "echo"
+	VoiceFable   Voice = "fable"
+	VoiceOnyx    Voice = "onyx"
+	VoiceNova    Voice = "nova"
+	VoiceShimmer Voice = "shimmer"
+)
+
+type SpeechFormat string
+
+const (
+	SpeechFormatMP3  SpeechFormat = "mp3"
+	SpeechFormatOpus SpeechFormat = "opus"
+	SpeechFormatAAC  SpeechFormat = "aac"
+	SpeechFormatFLAC SpeechFormat = "flac"
+	SpeechFormatWAV  SpeechFormat = "wav"
+	// SpeechFormatPCM is raw 24kHz 16-bit signed little-endian samples, without header
+	SpeechFormatPCM SpeechFormat = "pcm"
+)
+
+type TranscriptionRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string
+	MaxRetries int
+
+	// Audio file of less than 25MB, in one of these formats: flac, mp3, mp4, mpeg, mpga, m4a, ogg, wav or webm
+	File io.Reader
+	// FileName must have the extension of the format of File since the API relies on it, for example audio.mp3
+	FileName string
+
+	// Defaults to whisper-1
+	Model Model
+	// Optional, the language of the audio in ISO-639-1 format, for example en. It improves accuracy and latency.
+	Language string
+	// Optional, a text to guide the style of the transcription or to continue a previous segment. It should be in the
+	// same language as the audio.
+	Prompt string
+	// Defaults to json. Every format reports a Price, see AudioResponse.
+	ResponseFormat AudioResponseFormat
+	// Between 0 and 1, 0 lets the model pick the temperature automatically
+	Temperature float64
+	// Only supported with AudioResponseFormatVerboseJSON, defaults to segment
+	TimestampGranularities []TimestampGranularity
+}
+
+// TranslationRequest translates audio into english text
+type TranslationRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string
+	MaxRetries int
+
+	// Audio file of less than 25MB, in one of these formats: flac, mp3, mp4, mpeg, mpga, m4a, ogg, wav or webm
+	File io.Reader
+	// FileName must have the extension of the format of File since the API relies on it, for example audio.mp3
+	FileName string
+
+	// Defaults to whisper-1
+	Model Model
+	// Optional, a text in english to guide the style of the translation
+	Prompt string
+	// Defaults to json. Every format reports a Price, see AudioResponse.
+	ResponseFormat AudioResponseFormat
+	// Between 0 and 1, 0 lets the model pick the temperature automatically
+	Temperature float64
+}
+
+type AudioSegment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+type AudioWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// AudioResponse is the result of a transcription or a translation. With the text, srt and vtt response formats,
+// Text holds the body of the response.
+//
+// The json and text formats do not tell the duration of the audio, so verbose_json is requested instead and only
+// the text, the duration and the price are kept. With srt and vtt, the duration is the end of the last cue, which
+// leaves out the silence at the end of the audio.
+type AudioResponse struct {
+	Task     string `json:"task,omitempty"`
+	Language string `json:"language,omitempty"`
+	// Duration of the audio in seconds
+	Duration float64        `json:"duration,omitempty"`
+	Text     string         `json:"text"`
+	Segments []AudioSegment `json:"segments,omitempty"`
+	Words    []AudioWord    `json:"words,omitempty"`
+
+	//
+
+	Price float64 `json:"price,omitempty"`
+}
+
+// SpeechRequest generates audio from text
+type SpeechRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	// tts-1 or tts-1-hd
+	Model Model `json:"model"`
+	// At most 4096 characters
+	Input string `json:"input"`
+	Voice Voice  `json:"voice"`
+	// Defaults to mp3
+	ResponseFormat SpeechFormat `json:"response_format,omitempty"`
+	// Between 0.25 and 4.0, defaults to 1.0
+	Speed float64 `json:"speed,omitempty"`
+}
+
+type SpeechResponse struct {
+	// Number of characters of the input, which is what the API bills
+	Characters int `json:"characters"`
+
+	//
+
+	Price float64 `json:"price,omitempty"`
+}
+
+func CreateTranscription(ctx context.Context, req *TranscriptionRequest) (*AudioResponse, error) {
+	return DefaultClient.CreateTranscription(ctx, req)
+}
+
+// CreateTranscription transcribes audio into the language of the audio
+func (c *Client) CreateTranscription(ctx context.Context, req *TranscriptionRequest) (*AudioResponse, error) {
+	if len(req.TimestampGranularities) > 0 && req.ResponseFormat != AudioResponseFormatVerboseJSON {
+		return nil, fmt.Errorf("timestamp granularities are only supported with the %s response format", AudioResponseFormatVerboseJSON)
+	}
+
+	form, err := newAudioForm(req.File, req.FileName, req.Model, req.Prompt, req.ResponseFormat, req.Temperature)
+	if err != nil {
+		return nil, err
+	}
+	form.AddField("language", req.Language)
+	for _, g := range req.TimestampGranularities {
+		form.AddField("timestamp_granularities[]", string(g))
+	}
+
+	return c.audioRequest(ctx, urlSuffix_audiotranscriptions, req, form, req.Model, req.ResponseFormat, req.APIKEY, req.MaxRetries)
+}
+
+func CreateTranslation(ctx context.Context, req *TranslationRequest) (*AudioResponse, error) {
+	return DefaultClient.CreateTranslation(ctx, req)
+}
+
+// CreateTranslation translates audio into english
+func (c *Client) CreateTranslation(ctx context.Context, req *TranslationRequest) (*AudioResponse, error) {
+	form, err := newAudioForm(req.File, req.FileName, req.Model, req.Prompt, req.ResponseFormat, req.Temperature)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.audioRequest(ctx, urlSuffix_audiotranslations, req, form, req.Model, req.ResponseFormat, req.APIKEY, req.MaxRetries)
+}
+
+func newAudioForm(file io.Reader, fileName string, model Model, prompt string, responseFormat AudioResponseFormat, temperature float64) (*requests.MultipartForm, error) {
+	if file == nil {
+		return nil, fmt.Errorf("we need an audio file")
+	}
+	if fileName == "" {
+		return nil, fmt.Errorf("we need the name of the audio file, with its extension")
+	}
+	if model == "" {
+		model = Whisper1
+	}
+	pricing, err := GetPricing(model)
+	if err != nil {
+		return nil, err
+	}
+	if pricing.PerSecond == 0 {
+		return nil, fmt.Errorf("model %s is not an audio model", model)
+	}
+
+	form := &requests.MultipartForm{}
+	err = form.AddFile("file", fileName, "", file)
+	if err != nil {
+		return nil, err
+	}
+	form.AddField("model", string(model))
+	form.AddField("prompt", prompt)
+	// verbose_json is the only JSON format reporting the duration of the audio we need for the price
+	switch responseFormat {
+	case "", AudioResponseFormatJSON, AudioResponseFormatText:
+		form.AddField("response_format", string(AudioResponseFormatVerboseJSON))
+	default:
+		form.AddField("response_format", string(responseFormat))
+	}
+	if temperature > 0 {
+		form.AddField("temperature", strconv.FormatFloat(temperature, 'f', -1, 64))
+	}
+
+	return form, nil
+}
+
+func (c *Client) audioRequest(ctx context.Context, path string, req any, form *requests.MultipartForm, model Model, responseFormat AudioResponseFormat, apikey string, maxRetries int) (*AudioResponse, error) {
+	if model == "" {
+		model = Whisper1
+	}
+	pricing, err := GetPricing(model)
+	if err != nil {
+		return nil, err
+	}
+
+	subtitles := responseFormat == AudioResponseFormatSRT || responseFormat == AudioResponseFormatVTT
+	plain := subtitles
+
+	resp := &AudioResponse{}
+	var raw []byte
+
+	var response any = resp
+	if plain {
+		response = &raw
+	}
+
+	r, err := c.newRetryableRequest("POST", path, form, response, apikey, maxRetries)
+	if err != nil {
+		return nil, err
+	}
+	if plain {
+		r.Headers.Set("Accept", "text/plain")
+	}
+
+	ctx, span, err := c.startSpan(ctx, r, path, req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.retrier.Request(ctx, r)
+	if err == nil && plain {
+		resp.Text = string(raw)
+	}
+	if err == nil && subtitles {
+		resp.Duration, err = subtitlesDuration(resp.Text)
+	}
+	if err == nil && responseFormat != AudioResponseFormatVerboseJSON && !subtitles {
+		resp = &AudioResponse{Text: resp.Text, Duration: resp.Duration}
+	}
+	if err == nil {
+		resp.Price = resp.Duration * pricing.PerSecond
+	}
+
+	endSpan(span, r.Attempts(), req, resp, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// subtitlesDuration returns the end of the last cue of srt or vtt subtitles in seconds. Silence at the end of the
+// audio is not included, so it may be a bit lower than the billed duration.
+func subtitlesDuration(subtitles string) (float64, error) {
+	var duration time.Duration
+
+	for _, line := range strings.Split(subtitles, "\n") {
+		_, end, found := strings.Cut(line, "-->")
+		if !found {
+			continue
+		}
+
+		// vtt cues may have settings after the timestamp
+		fields := strings.Fields(end)
+		if len(fields) == 0 {
+			continue
+		}
+
+		d, err := parseSubtitleTimestamp(fields[0])
+		if err != nil {
+			return 0, err
+		}
+		if d > duration {
+			duration = d
+		}
+	}
+
+	return duration.Seconds(), nil
+}
+
+// parseSubtitleTimestamp parses hh:mm:ss,mmm for srt and hh:mm:ss.mmm or mm:ss.mmm for vtt
+func parseSubtitleTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(strings.Replace(s, ",", ".", 1), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid subtitle timestamp: %s", s)
+	}
+
+	var seconds float64
+	for i, p := range parts {
+		var v float64
+		var err error
+		if i < len(parts)-1 {
+			var n int
+			n, err = strconv.Atoi(p)
+			v = float64(n)
+		} else {
+			v, err = strconv.ParseFloat(p, 64)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("invalid subtitle timestamp %s: %v", s, err)
+		}
+		seconds = seconds*60 + v
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func CreateSpeech(ctx context.Context, req *SpeechRequest, w io.Writer) (*SpeechResponse, error) {
+	return DefaultClient.CreateSpeech(ctx, req, w)
+}
+
+// CreateSpeech writes the audio to w as the API streams it, so playback can start before the whole audio is generated
+func (c *Client) CreateSpeech(ctx context.Context, req *SpeechRequest, w io.Writer) (*SpeechResponse, error) {
+	if req.Input == "" {
+		return nil, fmt.Errorf("we need an input to generate speech from")
+	}
+	if req.Voice == "" {
+		return nil, fmt.Errorf("we need a voice")
+	}
+	if req.Model == "" {
+		req.Model = TTS1
+	}
+	pricing, err := GetPricing(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	if pricing.Per1000Characters == 0 {
+		return nil, fmt.Errorf("model %s is not a speech model", req.Model)
+	}
+
+	httpresp := &http.Response{}
+
+	r, err := c.newRetryableRequest("POST", urlSuffix_audiospeech, req, httpresp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+	r.Headers.Set("Accept", "*/*")
+
+	ctx, span, err := c.startSpan(ctx, r, urlSuffix_audiospeech, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &SpeechResponse{}
+
+	err = c.retrier.Request(ctx, r)
+	if err == nil {
+		_, err = io.Copy(w, httpresp.Body)
+		httpresp.Body.Close()
+		if err != nil {
+			err = fmt.Errorf("could not stream the speech audio: %w", err)
+		}
+	}
+	if err == nil {
+		resp.Characters = utf8.RuneCountInString(req.Input)
+		resp.Price = float64(resp.Characters) / 1000 * pricing.Per1000Characters
+	}
+
+	endSpan(span, r.Attempts(), req, resp, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
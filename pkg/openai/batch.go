@@ -0,0 +1,596 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const urlSuffix_batches = "v1/batches"
+
+// BatchDiscount is the share of the regular price billed for the requests of a batch, unless the pricing of the model
+// sets another one
+const BatchDiscount = 0.5
+
+type BatchStatus string
+
+const (
+	BatchStatusValidating BatchStatus = "validating"
+	BatchStatusFailed     BatchStatus = "failed"
+	BatchStatusInProgress BatchStatus = "in_progress"
+	BatchStatusFinalizing BatchStatus = "finalizing"
+	BatchStatusCompleted  BatchStatus = "completed"
+	BatchStatusExpired    BatchStatus = "expired"
+	BatchStatusCancelling BatchStatus = "cancelling"
+	BatchStatusCancelled  BatchStatus = "cancelled"
+)
+
+// IsTerminal tells whether the batch is over, some of its requests may still have results if it expired or was cancelled
+func (s BatchStatus) IsTerminal() bool {
+	switch s {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *BatchErrors      `json:"errors,omitempty"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           BatchStatus       `json:"status"`
+	OutputFileID     string            `json:"output_file_id,omitempty"`
+	ErrorFileID      string            `json:"error_file_id,omitempty"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     int64             `json:"in_progress_at,omitempty"`
+	ExpiresAt        int64             `json:"expires_at,omitempty"`
+	FinalizingAt     int64             `json:"finalizing_at,omitempty"`
+	CompletedAt      int64             `json:"completed_at,omitempty"`
+	FailedAt         int64             `json:"failed_at,omitempty"`
+	ExpiredAt        int64             `json:"expired_at,omitempty"`
+	CancellingAt     int64             `json:"cancelling_at,omitempty"`
+	CancelledAt      int64             `json:"cancelled_at,omitempty"`
+	RequestCounts    BatchCounts       `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// BatchErrors are the errors which made the validation of the input file fail
+type BatchErrors struct {
+	Object string       `json:"object"`
+	Data   []BatchError `json:"data"`
+}
+
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+type BatchCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+type CreateBatchRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	// ID of a file uploaded with the batch purpose
+	InputFileID string `json:"input_file_id"`
+	// /v1/chat/completions, /v1/embeddings or /v1/completions
+	Endpoint string `json:"endpoint"`
+	// Only 24h is supported for now, which is the default
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+type BatchRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	BatchID string `json:"-"`
+}
+
+type ListBatchesRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	// Optional, between 1 and 100, defaults to 20
+	Limit int `json:"-"`
+	// Optional, the ID of the last batch of the previous page
+	After string `json:"-"`
+}
+
+type ListBatchesResponse struct {
+	Object  string  `json:"object"`
+	Data    []Batch `json:"data"`
+	FirstID string  `json:"first_id,omitempty"`
+	LastID  string  `json:"last_id,omitempty"`
+	HasMore bool    `json:"has_more"`
+}
+
+func CreateBatch(ctx context.Context, req *CreateBatchRequest) (*Batch, error) {
+	return DefaultClient.CreateBatch(ctx, req)
+}
+
+func (c *Client) CreateBatch(ctx context.Context, req *CreateBatchRequest) (*Batch, error) {
+	if req.InputFileID == "" {
+		return nil, fmt.Errorf("we need the id of the input file")
+	}
+	if req.Endpoint == "" {
+		return nil, fmt.Errorf("we need the endpoint of the requests of the batch")
+	}
+	if req.CompletionWindow == "" {
+		req.CompletionWindow = "24h"
+	}
+
+	resp := &Batch{}
+
+	err := c.request(ctx, "POST", urlSuffix_batches, req, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func RetrieveBatch(ctx context.Context, req *BatchRequest) (*Batch, error) {
+	return DefaultClient.RetrieveBatch(ctx, req)
+}
+
+func (c *Client) RetrieveBatch(ctx context.Context, req *BatchRequest) (*Batch, error) {
+	if req.BatchID == "" {
+		return nil, fmt.Errorf("we need a batch id")
+	}
+
+	resp := &Batch{}
+
+	err := c.request(ctx, "GET", urlSuffix_batches+"/"+url.PathEscape(req.BatchID), nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func CancelBatch(ctx context.Context, req *BatchRequest) (*Batch, error) {
+	return DefaultClient.CancelBatch(ctx, req)
+}
+
+// CancelBatch asks for the batch to be cancelled, its status is cancelling for up to 10 minutes before it is cancelled
+func (c *Client) CancelBatch(ctx context.Context, req *BatchRequest) (*Batch, error) {
+	if req.BatchID == "" {
+		return nil, fmt.Errorf("we need a batch id")
+	}
+
+	resp := &Batch{}
+
+	err := c.request(ctx, "POST", urlSuffix_batches+"/"+url.PathEscape(req.BatchID)+"/cancel", nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func ListBatches(ctx context.Context, req *ListBatchesRequest) (*ListBatchesResponse, error) {
+	return DefaultClient.ListBatches(ctx, req)
+}
+
+func (c *Client) ListBatches(ctx context.Context, req *ListBatchesRequest) (*ListBatchesResponse, error) {
+	resp := &ListBatchesResponse{}
+
+	err := c.request(ctx, "GET", withQuery(urlSuffix_batches, pageQuery(req.Limit, req.After)), nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// BatchInputLine is one line of the jsonl input file of a batch
+type BatchInputLine struct {
+	CustomID string `json:"custom_id"`
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	Body     any    `json:"body"`
+}
+
+// BatchOutputLine is one line of the output or error file of a batch
+type BatchOutputLine struct {
+	ID       string `json:"id"`
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		RequestID  string          `json:"request_id"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *BatchError `json:"error"`
+}
+
+type RunBatchRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string
+	MaxRetries int
+
+	// Set either ChatCompletions or Embeddings, a batch only targets one endpoint
+	ChatCompletions []*ChatCompletionRequest
+	Embeddings      []*EmbeddingRequest
+
+	// Optional, the ids to find the result of each request with. It defaults to the index of the request
+	// in ChatCompletions or Embeddings.
+	CustomIDs []string
+	Metadata  map[string]string
+
+	// Optional, the first delay between two checks of the status of the batch. It defaults to 10 seconds and
+	// grows up to MaxPollInterval, which defaults to 5 minutes.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+}
+
+// BatchResult is the result of one request of a batch, Err is set if it failed
+type BatchResult struct {
+	CustomID string
+
+	ChatCompletion *ChatCompletionResponse
+	Embedding      *EmbeddingResponse
+
+	// Err wraps an *APIError if the API rejected the request
+	Err error
+}
+
+type RunBatchResponse struct {
+	Batch *Batch
+	// Results by custom id, there is one for each request of the batch
+	Results map[string]*BatchResult
+
+	//
+
+	// Price of the successful requests, with the batch discount
+	Price float64 `json:"price,omitempty"`
+}
+
+func RunBatch(ctx context.Context, req *RunBatchRequest) (*RunBatchResponse, error) {
+	return DefaultClient.RunBatch(ctx, req)
+}
+
+// RunBatch uploads the requests as a batch input file, creates the batch, waits for it to be over and returns the
+// result of each request. Batches are billed half the regular price but may take up to 24 hours.
+// If ctx is done before the batch is over, the returned error includes the batch id, which can be given to WaitBatch
+// and GetBatchResults later on since the batch keeps running.
+func (c *Client) RunBatch(ctx context.Context, req *RunBatchRequest) (*RunBatchResponse, error) {
+	lines, endpoint, err := batchInputLines(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var input bytes.Buffer
+	enc := json.NewEncoder(&input)
+	for _, line := range lines {
+		err = enc.Encode(line)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode the request %s: %v", line.CustomID, err)
+		}
+	}
+
+	file, err := c.UploadFile(ctx, &UploadFileRequest{
+		APIKEY:     req.APIKEY,
+		MaxRetries: req.MaxRetries,
+		File:       &input,
+		FileName:   "batch_input.jsonl",
+		Purpose:    FilePurposeBatch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not upload the batch input file: %w", err)
+	}
+
+	batch, err := c.CreateBatch(ctx, &CreateBatchRequest{
+		APIKEY:      req.APIKEY,
+		MaxRetries:  req.MaxRetries,
+		InputFileID: file.ID,
+		Endpoint:    endpoint,
+		Metadata:    req.Metadata,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the batch: %w", err)
+	}
+
+	batch, err = c.WaitBatch(ctx, &BatchRequest{APIKEY: req.APIKEY, MaxRetries: req.MaxRetries, BatchID: batch.ID}, req.PollInterval, req.MaxPollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	// The responses report dated versions of the models, which may not be in the pricing table
+	models := make(map[string]Model, len(lines))
+	for _, line := range lines {
+		models[line.CustomID] = requestModel(line.Body)
+	}
+
+	resp, err := c.getBatchResults(ctx, &BatchRequest{APIKEY: req.APIKEY, MaxRetries: req.MaxRetries, BatchID: batch.ID}, batch, models)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests which are in neither file were not processed before the batch expired or was cancelled
+	for _, line := range lines {
+		if resp.Results[line.CustomID] == nil {
+			resp.Results[line.CustomID] = &BatchResult{
+				CustomID: line.CustomID,
+				Err:      fmt.Errorf("batch %s is %s and the request was not processed", batch.ID, batch.Status),
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+func batchInputLines(req *RunBatchRequest) ([]BatchInputLine, string, error) {
+	if (len(req.ChatCompletions) == 0) == (len(req.Embeddings) == 0) {
+		return nil, "", fmt.Errorf("we need either chat completions or embeddings requests")
+	}
+
+	n := len(req.ChatCompletions) + len(req.Embeddings)
+	if req.CustomIDs != nil && len(req.CustomIDs) != n {
+		return nil, "", fmt.Errorf("we got %d custom ids for %d requests", len(req.CustomIDs), n)
+	}
+
+	lines := make([]BatchInputLine, n)
+	seen := make(map[string]bool, n)
+	for i := range lines {
+		id := strconv.Itoa(i)
+		if req.CustomIDs != nil {
+			id = req.CustomIDs[i]
+		}
+		if seen[id] {
+			return nil, "", fmt.Errorf("custom id %s is used more than once", id)
+		}
+		seen[id] = true
+
+		lines[i] = BatchInputLine{CustomID: id, Method: "POST"}
+
+		if len(req.ChatCompletions) > 0 {
+			chatReq := req.ChatCompletions[i]
+			if chatReq.Stream {
+				return nil, "", fmt.Errorf("request %s: streaming is not supported in batches", id)
+			}
+			err := prepareChatCompletionRequest(chatReq)
+			if err != nil {
+				return nil, "", fmt.Errorf("request %s: %w", id, err)
+			}
+			lines[i].URL = "/" + urlSuffix_chatcompletion
+			lines[i].Body = chatReq
+		} else {
+			embReq := req.Embeddings[i]
+			if _, err := GetPricing(embReq.Model); err != nil {
+				return nil, "", fmt.Errorf("request %s: %w", id, err)
+			}
+			lines[i].URL = "/" + urlSuffix_embeddings
+			lines[i].Body = embReq
+		}
+	}
+
+	return lines, lines[0].URL, nil
+}
+
+func WaitBatch(ctx context.Context, req *BatchRequest, pollInterval, maxPollInterval time.Duration) (*Batch, error) {
+	return DefaultClient.WaitBatch(ctx, req, pollInterval, maxPollInterval)
+}
+
+// WaitBatch polls the batch until it is over. The delay between two polls starts at pollInterval, 10 seconds by default,
+// and grows up to maxPollInterval, 5 minutes by default. A failed batch is returned with an error listing its errors.
+func (c *Client) WaitBatch(ctx context.Context, req *BatchRequest, pollInterval, maxPollInterval time.Duration) (*Batch, error) {
+	if pollInterval <= 0 {
+		pollInterval = 10 * time.Second
+	}
+	if maxPollInterval <= 0 {
+		maxPollInterval = 5 * time.Minute
+	}
+	if maxPollInterval < pollInterval {
+		maxPollInterval = pollInterval
+	}
+
+	delay := pollInterval
+	for {
+		batch, err := c.RetrieveBatch(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("could not check the status of batch %s: %w", req.BatchID, err)
+		}
+
+		if batch.Status.IsTerminal() {
+			if batch.Status == BatchStatusFailed {
+				return batch, batchFailedError(batch)
+			}
+			return batch, nil
+		}
+
+		c.log().Debug("waiting for batch", "batch", batch.ID, "status", batch.Status,
+			"completed", batch.RequestCounts.Completed, "failed", batch.RequestCounts.Failed, "total", batch.RequestCounts.Total)
+
+		// Jitter keeps the clients waiting for batches created at the same time from polling together
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("stopped waiting for batch %s, which is still %s: %w", batch.ID, batch.Status, ctx.Err())
+		}
+
+		delay *= 2
+		if delay > maxPollInterval {
+			delay = maxPollInterval
+		}
+	}
+}
+
+func batchFailedError(batch *Batch) error {
+	if batch.Errors == nil || len(batch.Errors.Data) == 0 {
+		return fmt.Errorf("batch %s failed", batch.ID)
+	}
+
+	msg := fmt.Sprintf("batch %s failed:", batch.ID)
+	for _, e := range batch.Errors.Data {
+		if e.Line > 0 {
+			msg += fmt.Sprintf(" line %d:", e.Line)
+		}
+		msg += fmt.Sprintf(" %s (%s);", e.Message, e.Code)
+	}
+
+	return fmt.Errorf("%s", msg[:len(msg)-1])
+}
+
+func GetBatchResults(ctx context.Context, req *BatchRequest, batch *Batch) (*RunBatchResponse, error) {
+	return DefaultClient.GetBatchResults(ctx, req, batch)
+}
+
+// GetBatchResults downloads the output and error files of a batch which is over and maps their lines to the custom
+// ids of the requests. If batch is nil, it is retrieved first.
+func (c *Client) GetBatchResults(ctx context.Context, req *BatchRequest, batch *Batch) (*RunBatchResponse, error) {
+	return c.getBatchResults(ctx, req, batch, nil)
+}
+
+// getBatchResults prices the responses with the models of the requests by custom id if known, with the models of
+// the responses otherwise
+func (c *Client) getBatchResults(ctx context.Context, req *BatchRequest, batch *Batch, models map[string]Model) (*RunBatchResponse, error) {
+	var err error
+	if batch == nil {
+		batch, err = c.RetrieveBatch(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !batch.Status.IsTerminal() {
+		return nil, fmt.Errorf("batch %s is still %s", batch.ID, batch.Status)
+	}
+
+	resp := &RunBatchResponse{
+		Batch:   batch,
+		Results: map[string]*BatchResult{},
+	}
+
+	for _, fileID := range []string{batch.OutputFileID, batch.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+
+		var content bytes.Buffer
+		err = c.DownloadFile(ctx, &FileRequest{APIKEY: req.APIKEY, MaxRetries: req.MaxRetries, FileID: fileID}, &content)
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.parseBatchOutput(batch, &content, models, resp)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the file %s of batch %s: %v", fileID, batch.ID, err)
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *Client) parseBatchOutput(batch *Batch, content *bytes.Buffer, models map[string]Model, resp *RunBatchResponse) error {
+	scanner := bufio.NewScanner(content)
+	// Lines hold whole responses, embeddings of large models are long
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var line BatchOutputLine
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			return err
+		}
+
+		result := &BatchResult{CustomID: line.CustomID}
+		resp.Results[line.CustomID] = result
+
+		switch {
+		case line.Error != nil:
+			result.Err = fmt.Errorf("batch %s request %s failed: %s (%s)", batch.ID, line.CustomID, line.Error.Message, line.Error.Code)
+		case line.Response == nil:
+			result.Err = fmt.Errorf("batch %s request %s has no response", batch.ID, line.CustomID)
+		case line.Response.StatusCode < http.StatusOK || line.Response.StatusCode >= http.StatusMultipleChoices:
+			result.Err = batchLineError(batch, &line)
+		default:
+			result.Err = c.decodeBatchResponse(batch, &line, models[line.CustomID], result, resp)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func batchLineError(batch *Batch, line *BatchOutputLine) error {
+	var errRes ErrorResponse
+	err := json.Unmarshal(line.Response.Body, &errRes)
+	if err != nil || errRes.Error == nil {
+		return fmt.Errorf("batch %s request %s failed, status code: %d: %s", batch.ID, line.CustomID, line.Response.StatusCode, string(line.Response.Body))
+	}
+
+	header := http.Header{}
+	header.Set("X-Request-Id", line.Response.RequestID)
+
+	return fmt.Errorf("batch %s request %s failed, status code: %d, message: %w", batch.ID, line.CustomID, line.Response.StatusCode,
+		newAPIError(errRes.Error, line.Response.StatusCode, header))
+}
+
+func (c *Client) decodeBatchResponse(batch *Batch, line *BatchOutputLine, model Model, result *BatchResult, resp *RunBatchResponse) error {
+	var responseModel Model
+	var usage *Usage
+
+	switch batch.Endpoint {
+	default:
+		return fmt.Errorf("batch %s targets %s, which we do not support", batch.ID, batch.Endpoint)
+	case "/" + urlSuffix_chatcompletion:
+		result.ChatCompletion = &ChatCompletionResponse{}
+		err := json.Unmarshal(line.Response.Body, result.ChatCompletion)
+		if err != nil {
+			return fmt.Errorf("could not decode the response of request %s: %v", line.CustomID, err)
+		}
+		responseModel, usage = Model(result.ChatCompletion.Model), &result.ChatCompletion.Usage
+	case "/" + urlSuffix_embeddings:
+		result.Embedding = &EmbeddingResponse{}
+		err := json.Unmarshal(line.Response.Body, result.Embedding)
+		if err != nil {
+			return fmt.Errorf("could not decode the response of request %s: %v", line.CustomID, err)
+		}
+		responseModel, usage = Model(result.Embedding.Model), &result.Embedding.Usage
+	}
+
+	if model == "" {
+		model = responseModel
+	}
+	// The requests are billed at the prices of the day the batch was created
+	price, err := usage.batchPrice(model, time.Unix(batch.CreatedAt, 0))
+	if err != nil {
+		c.log().Warn("unknown model in batch response, the price is not computed", "batch", batch.ID, "model", model)
+		return nil
+	}
+
+	if result.ChatCompletion != nil {
+		result.ChatCompletion.Price = price
+	} else {
+		result.Embedding.Price = price
+	}
+	resp.Price += price
+
+	return nil
+}
@@ -1,7 +1,11 @@
 package openai
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 )
 
 const urlSuffix_chatcompletion = "v1/chat/completions"
@@ -16,21 +20,63 @@ const (
 	// Note: The 'Function' role is deprecated
 )
 
+// ContentPart is a part of a multimodal message, see NewTextPart, NewImagePart and NewImagePartFromFile
 type ContentPart struct {
-	Type string `json:"type"`           // "text" or "image_url"
-	Text string `json:"text,omitempty"` // for text type
-	// For image_url type
-	URL    string `json:"url,omitempty"`
-	Detail string `json:"detail,omitempty"`
+	Type     string    `json:"type"`                // "text" or "image_url"
+	Text     string    `json:"text,omitempty"`      // for text type
+	ImageURL *ImageURL `json:"image_url,omitempty"` // for image_url type
 }
 
 type ChatCompletionMessage struct {
 	Role      MessageRole `json:"role"`
 	Content   interface{} `json:"content"` // string, []ContentPart, or null
 	ToolCalls []*ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID is the id of the tool call a message of the Tool role answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Refusal is set instead of Content when the model refuses to answer with a structured output
+	Refusal string `json:"refusal,omitempty"`
 	// Deprecated: Name, FunctionCall
 }
 
+// UnmarshalJSON decodes the content of the message as a string or as []ContentPart, for conversations
+// to be restored from JSON
+func (m *ChatCompletionMessage) UnmarshalJSON(b []byte) error {
+	type message ChatCompletionMessage
+	aux := struct {
+		*message
+		Content json.RawMessage `json:"content"`
+	}{
+		message: (*message)(m),
+	}
+
+	err := json.Unmarshal(b, &aux)
+	if err != nil {
+		return err
+	}
+
+	m.Content = nil
+	content := bytes.TrimSpace(aux.Content)
+	switch {
+	case len(content) == 0 || string(content) == "null":
+	case content[0] == '[':
+		var parts []ContentPart
+		err = json.Unmarshal(content, &parts)
+		if err != nil {
+			return err
+		}
+		m.Content = parts
+	default:
+		var text string
+		err = json.Unmarshal(content, &text)
+		if err != nil {
+			return err
+		}
+		m.Content = text
+	}
+
+	return nil
+}
+
 type ToolCall struct {
 	ID       string    `json:"id"`
 	Type     string    `json:"type"` // Currently, only "function" is supported
@@ -65,13 +111,50 @@ type ChatCompletionToolCall struct {
 }
 
 type ChatCompletionFunction struct {
-	Name        string              `json:"name,omitempty"`
-	Description string              `json:"description,omitempty"`
-	Parameters  *FunctionParameters `json:"parameters,omitempty"`
+	Name        string      `json:"name,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"` // *FunctionParameters or *JSONSchema
+	// Strict makes the arguments always match Parameters, which must then be a strict schema, see GenerateSchema
+	Strict bool `json:"strict,omitempty"`
+}
+
+// UnmarshalJSON decodes the parameters into a *JSONSchema, so that the tools of requests and fine-tuning examples
+// read from JSON can be counted and sent again. Keywords which JSONSchema does not define are dropped.
+func (f *ChatCompletionFunction) UnmarshalJSON(b []byte) error {
+	type function ChatCompletionFunction
+	aux := &struct {
+		*function
+		Parameters *JSONSchema `json:"parameters,omitempty"`
+	}{function: (*function)(f)}
+
+	err := json.Unmarshal(b, aux)
+	if err != nil {
+		return err
+	}
+
+	f.Parameters = nil
+	if aux.Parameters != nil {
+		f.Parameters = aux.Parameters
+	}
+
+	return nil
 }
 
 type ResponseFormat struct {
-	Type string `json:"type"` // "text" or "json_object"
+	Type       string                    `json:"type"` // "text", "json_object" or "json_schema"
+	JSONSchema *ResponseFormatJSONSchema `json:"json_schema,omitempty"`
+}
+
+type ResponseFormatJSONSchema struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Schema      *JSONSchema `json:"schema"`
+	Strict      bool        `json:"strict"`
+}
+
+type StreamOptions struct {
+	// If set, an additional chunk is streamed before the end of the stream with the usage of the whole request
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type ChatCompletionRequest struct {
@@ -90,6 +173,7 @@ type ChatCompletionRequest struct {
 	TopP             float32        `json:"top_p,omitempty"`
 	N                int            `json:"n,omitempty"`
 	Stream           bool           `json:"stream,omitempty"`
+	StreamOptions    *StreamOptions `json:"stream_options,omitempty"`
 	Stop             []string       `json:"stop,omitempty"`
 	PresencePenalty  float32        `json:"presence_penalty,omitempty"`
 	FrequencyPenalty float32        `json:"frequency_penalty,omitempty"`
@@ -117,42 +201,97 @@ type ChatCompletionResponse struct {
 	Price float64 `json:"price,omitempty"`
 }
 
-func CreateChatCompletion(req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+// CreateChatCompletion uses DefaultClient, see Client.CreateChatCompletion
+func CreateChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return DefaultClient.CreateChatCompletion(ctx, req)
+}
+
+// CreateChatCompletion sends a chat completion request. If req.Stream is set, the streamed response is read
+// until the end and assembled, use CreateChatCompletionStream to process the chunks as they arrive.
+func (c *Client) CreateChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	if req.Stream {
+		stream, err := c.CreateChatCompletionStream(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		defer stream.Close()
+
+		for {
+			_, err = stream.Recv()
+			if err == io.EOF {
+				return stream.Response(), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	resp := &ChatCompletionResponse{}
 
-	if PricingPer1000TokensPerModel[req.Model] == nil {
-		return nil, fmt.Errorf("unknown model: %s", req.Model)
+	err := prepareChatCompletionRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.request(ctx, "POST", urlSuffix_chatcompletion, req, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Price, err = resp.Usage.ComputePrice(req.Model)
+	if err != nil {
+		return nil, err
 	}
 
-	var err error
+	return resp, nil
+}
+
+// prepareChatCompletionRequest checks the model and resolves the special negative MaxTokens values
+func prepareChatCompletionRequest(req *ChatCompletionRequest) error {
+	_, err := LookupModel(req.Model)
+	if err != nil {
+		return err
+	}
 
 	if req.MaxTokens < 0 {
 		switch req.MaxTokens {
 		default:
-			return nil, fmt.Errorf("We got an invalid MaxTokens parameter of %d, you may use -1 or -2 or a positive value", req.MaxTokens)
+			return fmt.Errorf("We got an invalid MaxTokens parameter of %d, you may use -1 or -2 or a positive value", req.MaxTokens)
 		case -1:
 			req.MaxTokens, err = GetMaxRemainingTokensChatCompletion(req)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			if req.MaxTokens <= 16 {
-				return nil, fmt.Errorf("we do not have enough token left in the context window of the model %s, we have %d token left", req.Model, req.MaxTokens)
+				return fmt.Errorf("we do not have enough token left in the context window of the model %s, we have %d token left", req.Model, req.MaxTokens)
 			}
 		case -2, -3:
 			count, err := CountTokensCompletion(req)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			newmodel := req.Model
-			maxcontentlength := int(newmodel.GetContextLength())
+			contextLength, err := newmodel.GetContextLength()
+			if err != nil {
+				return err
+			}
+			maxcontentlength := int(contextLength)
 			isnext := true
 			for isnext && maxcontentlength < count+16 {
-				isnext, newmodel = newmodel.GetSimilarWithNextContextLength()
+				isnext, newmodel, err = newmodel.GetSimilarWithNextContextLength()
+				if err != nil {
+					return err
+				}
 				if !isnext {
-					return nil, fmt.Errorf("We do not have a model similar to %s with a larger maximum context length", req.Model)
+					return fmt.Errorf("We do not have a model similar to %s with a larger maximum context length", req.Model)
+				}
+				contextLength, err = newmodel.GetContextLength()
+				if err != nil {
+					return err
 				}
-				maxcontentlength = int(newmodel.GetContextLength())
+				maxcontentlength = int(contextLength)
 			}
 			req.Model = newmodel
 			if req.MaxTokens == -2 {
@@ -161,14 +300,16 @@ func CreateChatCompletion(req *ChatCompletionRequest) (*ChatCompletionResponse,
 				req.MaxTokens = 0
 			}
 		}
-	}
 
-	err = request("POST", urlSuffix_chatcompletion, req, resp, req.APIKEY, req.MaxRetries)
-	if err != nil {
-		return nil, err
+		// Models with a large context length may generate fewer tokens than they can read
+		info, err := LookupModel(req.Model)
+		if err != nil {
+			return err
+		}
+		if info.MaxOutputTokens > 0 && req.MaxTokens > info.MaxOutputTokens {
+			req.MaxTokens = info.MaxOutputTokens
+		}
 	}
 
-	resp.Price = resp.Usage.ComputePrice(req.Model)
-
-	return resp, nil
+	return nil
 }
@@ -0,0 +1,292 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/telemetry"
+)
+
+// ChatCompletionStreamResponse is one chunk of a streamed chat completion
+type ChatCompletionStreamResponse struct {
+	ID                string                       `json:"id"`
+	Object            string                       `json:"object"`
+	Created           int64                        `json:"created"`
+	Model             string                       `json:"model"`
+	SystemFingerprint string                       `json:"system_fingerprint"`
+	Choices           []ChatCompletionStreamChoice `json:"choices"`
+
+	// Only set on the last chunk when StreamOptions.IncludeUsage is true
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+type ChatCompletionStreamChoice struct {
+	Index        int                       `json:"index"`
+	Delta        ChatCompletionStreamDelta `json:"delta"`
+	FinishReason string                    `json:"finish_reason"`
+}
+
+type ChatCompletionStreamDelta struct {
+	Role      MessageRole      `json:"role,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	Refusal   string           `json:"refusal,omitempty"`
+	ToolCalls []*ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta is a fragment of a tool call. The first fragment of a tool call carries its ID, type and function name,
+// the following ones carry pieces of the function arguments to concatenate.
+type ToolCallDelta struct {
+	Index    int       `json:"index"`
+	ID       string    `json:"id,omitempty"`
+	Type     string    `json:"type,omitempty"`
+	Function *Function `json:"function,omitempty"`
+}
+
+// ChatCompletionStream reads the server-sent events of a streamed chat completion.
+// Call Recv until it returns io.EOF, then Response returns the full assembled response with its Price.
+type ChatCompletionStream struct {
+	ctx    context.Context
+	req    *ChatCompletionRequest
+	body   io.ReadCloser
+	reader *bufio.Reader
+
+	response *ChatCompletionResponse
+	done     bool
+	// err is returned by every call to Recv once the stream failed
+	err error
+
+	span     *telemetry.GenAISpan
+	attempts int
+}
+
+// CreateChatCompletionStream uses DefaultClient, see Client.CreateChatCompletionStream
+func CreateChatCompletionStream(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionStream, error) {
+	return DefaultClient.CreateChatCompletionStream(ctx, req)
+}
+
+// CreateChatCompletionStream sends a chat completion request with streaming enabled.
+// Usage reporting is requested automatically unless StreamOptions is already set, so that the price can be computed at the end.
+func (c *Client) CreateChatCompletionStream(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionStream, error) {
+	err := prepareChatCompletionRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Stream = true
+	if req.StreamOptions == nil {
+		req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	httpresp := &http.Response{}
+
+	r, err := c.newRetryableRequest("POST", urlSuffix_chatcompletion, req, httpresp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+	r.Headers.Set("Accept", "text/event-stream")
+
+	ctx, span, err := c.startSpan(ctx, r, urlSuffix_chatcompletion, req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.retrier.Request(ctx, r)
+	if err != nil {
+		endSpan(span, r.Attempts(), req, nil, err)
+		return nil, err
+	}
+
+	return &ChatCompletionStream{
+		ctx:      ctx,
+		req:      req,
+		body:     httpresp.Body,
+		reader:   bufio.NewReader(httpresp.Body),
+		response: &ChatCompletionResponse{},
+		span:     span,
+		attempts: r.Attempts(),
+	}, nil
+}
+
+// Recv returns the next chunk of the stream, or io.EOF once the stream is over. If the stream breaks, Recv keeps
+// returning the same error instead of io.EOF.
+func (s *ChatCompletionStream) Recv() (*ChatCompletionStreamResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.done {
+		return nil, io.EOF
+	}
+
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if s.ctx.Err() != nil {
+				return nil, s.fail(s.ctx.Err())
+			}
+			return nil, s.fail(fmt.Errorf("reading chat completion stream: %w", err))
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == ':' {
+			continue
+		}
+
+		data, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			// event, id and retry fields are not used by OpenAI
+			continue
+		}
+		data = bytes.TrimSpace(data)
+
+		if string(data) == "[DONE]" {
+			s.finish()
+			return nil, io.EOF
+		}
+
+		chunk := &struct {
+			ChatCompletionStreamResponse
+			Error *APIError `json:"error,omitempty"`
+		}{}
+		err = json.Unmarshal(data, chunk)
+		if err != nil {
+			return nil, s.fail(fmt.Errorf("unmarshal chat completion stream chunk: %v: %s", err, string(data)))
+		}
+		if chunk.Error != nil {
+			return nil, s.fail(fmt.Errorf("error in chat completion stream: %w", chunk.Error))
+		}
+
+		err = s.accumulate(&chunk.ChatCompletionStreamResponse)
+		if err != nil {
+			return nil, s.fail(err)
+		}
+
+		return &chunk.ChatCompletionStreamResponse, nil
+	}
+}
+
+// Response returns the chat completion assembled from all the chunks received so far.
+// It is complete and priced only once Recv has returned io.EOF, it is partial if Recv returned an error.
+func (s *ChatCompletionStream) Response() *ChatCompletionResponse {
+	return s.response
+}
+
+// Close releases the underlying connection, it is safe to call it multiple times
+func (s *ChatCompletionStream) Close() error {
+	s.done = true
+	s.endSpan(nil)
+	return s.body.Close()
+}
+
+func (s *ChatCompletionStream) fail(err error) error {
+	s.err = err
+	s.endSpan(err)
+	s.Close()
+	return err
+}
+
+func (s *ChatCompletionStream) endSpan(err error) {
+	if s.span == nil {
+		return
+	}
+	endSpan(s.span, s.attempts, s.req, s.response, err)
+	s.span = nil
+}
+
+// accumulate adds a chunk to the response. The indexes come from the server, so they are checked before they are
+// used: a choice must be one of the N requested and a tool call must be one already started or the next one.
+func (s *ChatCompletionStream) accumulate(chunk *ChatCompletionStreamResponse) error {
+	resp := s.response
+
+	n := s.req.N
+	if n < 1 {
+		n = 1
+	}
+
+	if resp.ID == "" {
+		resp.ID = chunk.ID
+		resp.Object = "chat.completion"
+		resp.Created = chunk.Created
+		resp.Model = chunk.Model
+		resp.SystemFingerprint = chunk.SystemFingerprint
+	}
+
+	if chunk.Usage != nil {
+		resp.Usage = *chunk.Usage
+	}
+
+	for _, c := range chunk.Choices {
+		if c.Index < 0 || c.Index >= n {
+			return fmt.Errorf("invalid chat completion stream chunk: choice index %d out of the %d choices requested", c.Index, n)
+		}
+		for len(resp.Choices) <= c.Index {
+			resp.Choices = append(resp.Choices, ChatCompletionChoice{
+				Index: len(resp.Choices),
+				Message: ChatCompletionMessage{
+					Role: Assistant,
+				},
+			})
+		}
+		choice := &resp.Choices[c.Index]
+
+		if c.Delta.Role != "" {
+			choice.Message.Role = c.Delta.Role
+		}
+
+		if c.Delta.Content != "" {
+			content, _ := choice.Message.Content.(string)
+			choice.Message.Content = content + c.Delta.Content
+		}
+		if c.Delta.Refusal != "" {
+			choice.Message.Refusal += c.Delta.Refusal
+		}
+
+		for _, tcd := range c.Delta.ToolCalls {
+			if tcd.Index < 0 || tcd.Index > len(choice.Message.ToolCalls) {
+				return fmt.Errorf("invalid chat completion stream chunk: tool call index %d after %d tool calls", tcd.Index, len(choice.Message.ToolCalls))
+			}
+			for len(choice.Message.ToolCalls) <= tcd.Index {
+				choice.Message.ToolCalls = append(choice.Message.ToolCalls, &ToolCall{
+					Type:     "function",
+					Function: &Function{},
+				})
+			}
+			tc := choice.Message.ToolCalls[tcd.Index]
+			if tcd.ID != "" {
+				tc.ID = tcd.ID
+			}
+			if tcd.Type != "" {
+				tc.Type = tcd.Type
+			}
+			if tcd.Function != nil {
+				if tcd.Function.Name != "" {
+					tc.Function.Name = tcd.Function.Name
+				}
+				tc.Function.Arguments += tcd.Function.Arguments
+			}
+		}
+
+		if c.FinishReason != "" {
+			choice.FinishReason = c.FinishReason
+			resp.FinishReason = c.FinishReason
+		}
+	}
+
+	return nil
+}
+
+func (s *ChatCompletionStream) finish() {
+	if s.response.Usage.TotalTokens > 0 {
+		// The model was checked before the request was sent
+		s.response.Price, _ = s.response.Usage.ComputePrice(s.req.Model)
+	}
+
+	s.Close()
+}
@@ -0,0 +1,317 @@
+package openai
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/usage"
+)
+
+const (
+	defaultBaseURL     = "https://api.openai.com"
+	defaultHTTPTimeout = 300 * time.Second
+)
+
+// Client holds the configuration used to reach the OpenAI API, or any OpenAI compatible API.
+// It is safe for concurrent use. The package level functions use DefaultClient.
+type Client struct {
+	// apiKey is a string, Init may set it while requests are sent
+	apiKey       atomic.Value
+	baseURL      *url.URL
+	organization string
+	project      string
+
+	httpClient  *http.Client
+	httpTimeout time.Duration
+
+	retrier *requests.RequestRetrier
+	// ownsRetrier is set when the retrier is not the shared default one, Close stops it
+	ownsRetrier bool
+	rateLimiter *RateLimiter
+
+	logger *slog.Logger
+	hooks  *Hooks
+
+	usageTracker *usage.Tracker
+}
+
+// Hooks are optional callbacks called during the lifecycle of each request, see WithHooks.
+// RequestInfo.Usage is a *Usage for the endpoints which report one.
+type Hooks = requests.Hooks
+
+type RequestInfo = requests.RequestInfo
+
+// DefaultClient is used by the package level functions, its api key is set by Init
+var DefaultClient *Client
+
+var defaultRetrier *requests.RequestRetrier
+
+func init() {
+	defaultRetrier = requests.NewRequestRetrier(initialDelay, maxRetries, backoffFactor)
+	defaultRetrier.Run()
+
+	var err error
+	DefaultClient, err = NewClient()
+	if err != nil {
+		panic(err)
+	}
+}
+
+type ClientOption interface {
+	ClientOption()
+}
+
+type withAPIKeyOption struct {
+	APIKey string
+}
+
+func (*withAPIKeyOption) ClientOption() {}
+
+// WithAPIKey sets the api key used when a request does not provide its own
+func WithAPIKey(apikey string) *withAPIKeyOption {
+	return &withAPIKeyOption{APIKey: apikey}
+}
+
+type withBaseURLOption struct {
+	BaseURL string
+}
+
+func (*withBaseURLOption) ClientOption() {}
+
+// WithBaseURL points the client to a proxy or an OpenAI compatible server. The url must not contain
+// the /v1 version prefix, for example http://localhost:8080 or https://myproxy.com/openai
+func WithBaseURL(baseURL string) *withBaseURLOption {
+	return &withBaseURLOption{BaseURL: baseURL}
+}
+
+type withOrganizationOption struct {
+	Organization string
+}
+
+func (*withOrganizationOption) ClientOption() {}
+
+// WithOrganization sets the OpenAI-Organization header
+func WithOrganization(organization string) *withOrganizationOption {
+	return &withOrganizationOption{Organization: organization}
+}
+
+type withProjectOption struct {
+	Project string
+}
+
+func (*withProjectOption) ClientOption() {}
+
+// WithProject sets the OpenAI-Project header
+func WithProject(project string) *withProjectOption {
+	return &withProjectOption{Project: project}
+}
+
+type withHTTPClientOption struct {
+	HTTPClient *http.Client
+}
+
+func (*withHTTPClientOption) ClientOption() {}
+
+// WithHTTPClient sets the http.Client used to send requests, for example one returned by httptest.Server.Client
+func WithHTTPClient(httpClient *http.Client) *withHTTPClientOption {
+	return &withHTTPClientOption{HTTPClient: httpClient}
+}
+
+type withTransportOption struct {
+	Transport http.RoundTripper
+}
+
+func (*withTransportOption) ClientOption() {}
+
+// WithTransport sets the transport of the http.Client used to send requests
+func WithTransport(transport http.RoundTripper) *withTransportOption {
+	return &withTransportOption{Transport: transport}
+}
+
+type withHTTPTimeoutOption struct {
+	Timeout time.Duration
+}
+
+func (*withHTTPTimeoutOption) ClientOption() {}
+
+// WithHTTPTimeout sets the timeout of each HTTP attempt, it defaults to 300 seconds
+func WithHTTPTimeout(timeout time.Duration) *withHTTPTimeoutOption {
+	return &withHTTPTimeoutOption{Timeout: timeout}
+}
+
+type withRetryPolicyOption struct {
+	InitialDelay  time.Duration
+	MaxRetries    int
+	BackoffFactor int
+}
+
+func (*withRetryPolicyOption) ClientOption() {}
+
+// WithRetryPolicy gives the client its own retrier instead of the one shared by default between all clients.
+// Zero values fall back to the defaults: an initial delay of 30 seconds, 7 retries and a backoff factor of 2.
+func WithRetryPolicy(initialDelay time.Duration, maxRetries, backoffFactor int) *withRetryPolicyOption {
+	return &withRetryPolicyOption{
+		InitialDelay:  initialDelay,
+		MaxRetries:    maxRetries,
+		BackoffFactor: backoffFactor,
+	}
+}
+
+type withMaxConcurrentRetriesOption struct {
+	Max int
+}
+
+func (*withMaxConcurrentRetriesOption) ClientOption() {}
+
+// WithMaxConcurrentRetries gives the client its own retrier, like WithRetryPolicy, which sends at most max
+// retries at the same time. It defaults to 8.
+func WithMaxConcurrentRetries(max int) *withMaxConcurrentRetriesOption {
+	return &withMaxConcurrentRetriesOption{Max: max}
+}
+
+type withRateLimiterOption struct {
+	RateLimiter *RateLimiter
+}
+
+func (*withRateLimiterOption) ClientOption() {}
+
+// WithRateLimiter makes the client wait for the rate limiter before each attempt, see RateLimiter
+func WithRateLimiter(rateLimiter *RateLimiter) *withRateLimiterOption {
+	return &withRateLimiterOption{RateLimiter: rateLimiter}
+}
+
+type withLoggerOption struct {
+	Logger *slog.Logger
+}
+
+func (*withLoggerOption) ClientOption() {}
+
+// WithLogger sets the logger of retries, failures and corrections, slog.Default() is used otherwise
+func WithLogger(logger *slog.Logger) *withLoggerOption {
+	return &withLoggerOption{Logger: logger}
+}
+
+type withHooksOption struct {
+	Hooks *Hooks
+}
+
+func (*withHooksOption) ClientOption() {}
+
+// WithHooks sets callbacks called before each attempt, after each response, on each retry and when a request gives up
+func WithHooks(hooks *Hooks) *withHooksOption {
+	return &withHooksOption{Hooks: hooks}
+}
+
+type withUsageTrackerOption struct {
+	Tracker *usage.Tracker
+}
+
+func (*withUsageTrackerOption) ClientOption() {}
+
+// WithUsageTracker records the usage of the requests of the client in tracker and enforces its budgets, unless the
+// context of a request carries its own tracker, see usage.NewContext
+func WithUsageTracker(tracker *usage.Tracker) *withUsageTrackerOption {
+	return &withUsageTrackerOption{Tracker: tracker}
+}
+
+func NewClient(opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		httpTimeout: defaultHTTPTimeout,
+		retrier:     defaultRetrier,
+	}
+
+	var err error
+	c.baseURL, err = url.Parse(defaultBaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var transport http.RoundTripper
+	var retryPolicy *withRetryPolicyOption
+	var retrierOpts []requests.RetrierOption
+
+	for i := 0; i < len(opts); i++ {
+		switch t := opts[i].(type) {
+		default:
+			return nil, fmt.Errorf("unsupported client option %T", t)
+		case *withAPIKeyOption:
+			c.apiKey.Store(t.APIKey)
+		case *withBaseURLOption:
+			c.baseURL, err = url.Parse(t.BaseURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base url %s: %v", t.BaseURL, err)
+			}
+			if c.baseURL.Scheme == "" || c.baseURL.Host == "" {
+				return nil, fmt.Errorf("invalid base url %s: we need an absolute url", t.BaseURL)
+			}
+			// Paths are resolved relatively to the base url, which needs a trailing slash to keep its own path
+			if !strings.HasSuffix(c.baseURL.Path, "/") {
+				c.baseURL.Path += "/"
+			}
+		case *withOrganizationOption:
+			c.organization = t.Organization
+		case *withProjectOption:
+			c.project = t.Project
+		case *withHTTPClientOption:
+			c.httpClient = t.HTTPClient
+		case *withTransportOption:
+			transport = t.Transport
+		case *withHTTPTimeoutOption:
+			c.httpTimeout = t.Timeout
+		case *withRetryPolicyOption:
+			retryPolicy = t
+		case *withMaxConcurrentRetriesOption:
+			retrierOpts = append(retrierOpts, requests.WithMaxConcurrentRetries(t.Max))
+		case *withRateLimiterOption:
+			c.rateLimiter = t.RateLimiter
+		case *withLoggerOption:
+			c.logger = t.Logger
+		case *withUsageTrackerOption:
+			c.usageTracker = t.Tracker
+		case *withHooksOption:
+			c.hooks = t.Hooks
+		}
+	}
+
+	if retryPolicy != nil || len(retrierOpts) > 0 {
+		if retryPolicy == nil {
+			retryPolicy = WithRetryPolicy(initialDelay, maxRetries, backoffFactor)
+		}
+		c.retrier = requests.NewRequestRetrier(retryPolicy.InitialDelay, retryPolicy.MaxRetries, retryPolicy.BackoffFactor, retrierOpts...)
+		c.retrier.Run()
+		c.ownsRetrier = true
+	}
+
+	if transport != nil {
+		if c.httpClient == nil {
+			c.httpClient = &http.Client{}
+		} else {
+			cp := *c.httpClient
+			c.httpClient = &cp
+		}
+		c.httpClient.Transport = transport
+	}
+
+	return c, nil
+}
+
+// Close stops the retrier of a client created with WithRetryPolicy or WithMaxConcurrentRetries, whose requests
+// waiting for a retry then fail. The other clients share a retrier and Close does nothing for them.
+// The client must not be used after Close.
+func (c *Client) Close() error {
+	if c.ownsRetrier {
+		c.retrier.Stop()
+	}
+	return nil
+}
+
+func (c *Client) getAPIKey() string {
+	apiKey, _ := c.apiKey.Load().(string)
+	return apiKey
+}
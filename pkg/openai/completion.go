@@ -1,7 +1,7 @@
 package openai
 
 import (
-	"fmt"
+	"context"
 )
 
 const urlSuffix_completion = "v1/completions"
@@ -64,19 +64,27 @@ type CompletionResponse struct {
 	Price float64 `json:"price,omitempty"`
 }
 
-func CreateCompletion(req *CompletionRequest) (*CompletionResponse, error) {
+func CreateCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
+	return DefaultClient.CreateCompletion(ctx, req)
+}
+
+func (c *Client) CreateCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
 	resp := &CompletionResponse{}
 
-	if PricingPer1000TokensPerModel[req.Model] == nil {
-		return nil, fmt.Errorf("unknown model: %s", req.Model)
+	_, err := GetPricing(req.Model)
+	if err != nil {
+		return nil, err
 	}
 
-	err := request("POST", urlSuffix_completion, req, resp, req.APIKEY, req.MaxRetries)
+	err = c.request(ctx, "POST", urlSuffix_completion, req, resp, req.APIKEY, req.MaxRetries)
 	if err != nil {
 		return nil, err
 	}
 
-	resp.Price = resp.Usage.ComputePrice(req.Model)
+	resp.Price, err = resp.Usage.ComputePrice(req.Model)
+	if err != nil {
+		return nil, err
+	}
 
 	return resp, nil
 }
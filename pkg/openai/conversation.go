@@ -0,0 +1,338 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type MemoryStrategy string
+
+const (
+	// MemoryDropOldest drops the oldest turns until the conversation fits in the context window of the model
+	MemoryDropOldest MemoryStrategy = "drop_oldest"
+	// MemorySlidingWindow keeps the last WindowTurns turns, and drops the oldest of them if they still do not fit
+	MemorySlidingWindow MemoryStrategy = "sliding_window"
+	// MemorySummarize replaces the oldest turns, at least half of them, with a summary written by SummaryModel
+	// when the conversation does not fit anymore
+	MemorySummarize MemoryStrategy = "summarize"
+)
+
+const defaultSummaryMaxTokens = 512
+
+// Conversation holds the history of a chat and compacts it before each completion for it to fit in the context
+// window of the model, see ContinueConversation. System messages are pinned and sent first. The other messages are
+// grouped in turns, each one starting with a user message, so that tool calls are never separated from their results.
+//
+// A Conversation can be encoded in JSON to be persisted between requests. The dimensions of images are not
+// encoded, they are counted as the largest possible image once restored.
+type Conversation struct {
+	mu sync.Mutex
+
+	Model    Model          `json:"model"`
+	Strategy MemoryStrategy `json:"strategy"`
+	// For MemorySlidingWindow, the number of turns kept
+	WindowTurns int `json:"window_turns,omitempty"`
+	// For MemorySummarize, the model writing the summaries, defaults to GPT4o_Mini_128k
+	SummaryModel Model `json:"summary_model,omitempty"`
+	// For MemorySummarize, the maximum length of the summary, defaults to 512 tokens
+	SummaryMaxTokens int `json:"summary_max_tokens,omitempty"`
+	// ReservedTokens are kept free in the context window for the completion. They default to the MaxTokens of the
+	// request if it is positive, and to 1024 otherwise.
+	ReservedTokens int `json:"reserved_tokens,omitempty"`
+
+	Messages []ChatCompletionMessage `json:"messages"`
+	// Summary of the turns compacted with MemorySummarize
+	Summary string `json:"summary,omitempty"`
+
+	// Price of the completions and of the summaries of the conversation
+	Price float64 `json:"price,omitempty"`
+}
+
+func NewConversation(model Model, strategy MemoryStrategy) *Conversation {
+	return &Conversation{
+		Model:    model,
+		Strategy: strategy,
+	}
+}
+
+// Add appends messages to the history, for example a system prompt or the results of tool calls
+func (cv *Conversation) Add(messages ...ChatCompletionMessage) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	cv.Messages = append(cv.Messages, messages...)
+}
+
+// History returns a copy of the messages of the conversation
+func (cv *Conversation) History() []ChatCompletionMessage {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	return append([]ChatCompletionMessage(nil), cv.Messages...)
+}
+
+// MarshalJSON encodes the conversation while it is not being continued
+func (cv *Conversation) MarshalJSON() ([]byte, error) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	type conversation Conversation
+	return json.Marshal((*conversation)(cv))
+}
+
+// ContinueConversation uses DefaultClient, see Client.ContinueConversation
+func ContinueConversation(ctx context.Context, cv *Conversation, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return DefaultClient.ContinueConversation(ctx, cv, req)
+}
+
+// ContinueConversation appends req.Messages to the conversation, compacts it with its strategy and sends the whole
+// conversation with req. req.Model defaults to the model of the conversation. The history is only updated, with the
+// answer of the model, if the completion succeeds.
+func (c *Client) ContinueConversation(ctx context.Context, cv *Conversation, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	completionReq := *req
+	if completionReq.Model == "" {
+		completionReq.Model = cv.Model
+	}
+
+	reserved := cv.ReservedTokens
+	if req.MaxTokens > 0 {
+		reserved = req.MaxTokens
+	}
+	if reserved <= 0 {
+		reserved = 1024
+	}
+
+	messages := append(append([]ChatCompletionMessage(nil), cv.Messages...), req.Messages...)
+
+	memory, err := c.compactConversation(ctx, cv, &completionReq, messages, reserved)
+	if err != nil {
+		return nil, err
+	}
+
+	completionReq.Messages = memory.prompt()
+
+	resp, err := c.CreateChatCompletion(ctx, &completionReq)
+	if err != nil {
+		return nil, err
+	}
+	cv.Price += resp.Price
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("the response has no choice")
+	}
+
+	cv.Messages = append(memory.messages(), resp.Choices[0].Message)
+	cv.Summary = memory.summary
+
+	return resp, nil
+}
+
+type conversationMemory struct {
+	pinned  []ChatCompletionMessage
+	summary string
+	turns   [][]ChatCompletionMessage
+}
+
+// prompt returns the messages sent to the model
+func (m *conversationMemory) prompt() []ChatCompletionMessage {
+	prompt := append([]ChatCompletionMessage(nil), m.pinned...)
+	if m.summary != "" {
+		prompt = append(prompt, summaryMessage(m.summary))
+	}
+	for _, turn := range m.turns {
+		prompt = append(prompt, turn...)
+	}
+	return prompt
+}
+
+// messages returns the history kept, without the summary
+func (m *conversationMemory) messages() []ChatCompletionMessage {
+	messages := append([]ChatCompletionMessage(nil), m.pinned...)
+	for _, turn := range m.turns {
+		messages = append(messages, turn...)
+	}
+	return messages
+}
+
+func summaryMessage(summary string) ChatCompletionMessage {
+	return ChatCompletionMessage{Role: System, Content: "Summary of the earlier conversation:\n" + summary}
+}
+
+func (c *Client) compactConversation(ctx context.Context, cv *Conversation, req *ChatCompletionRequest, messages []ChatCompletionMessage, reserved int) (*conversationMemory, error) {
+	info, err := chatModelInfo(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	if info.ContextLength == 0 {
+		return nil, fmt.Errorf("model %s has no context length", req.Model)
+	}
+
+	toolsTokens, err := countToolsTokens(info.Encoding, req.Tools)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every reply is primed with 3 tokens
+	budget := int(info.ContextLength) - reserved - toolsTokens - 3
+
+	memory := &conversationMemory{summary: cv.Summary}
+	for _, message := range messages {
+		switch {
+		case message.Role == System:
+			memory.pinned = append(memory.pinned, message)
+		case message.Role == User || len(memory.turns) == 0:
+			memory.turns = append(memory.turns, []ChatCompletionMessage{message})
+		default:
+			memory.turns[len(memory.turns)-1] = append(memory.turns[len(memory.turns)-1], message)
+		}
+	}
+
+	if cv.Strategy == MemorySlidingWindow && cv.WindowTurns > 0 && len(memory.turns) > cv.WindowTurns {
+		memory.turns = memory.turns[len(memory.turns)-cv.WindowTurns:]
+	}
+
+	used, err := countMessagesTokens(info, memory.pinned...)
+	if err != nil {
+		return nil, err
+	}
+	turnsTokens := make([]int, len(memory.turns))
+	for i, turn := range memory.turns {
+		turnsTokens[i], err = countMessagesTokens(info, turn...)
+		if err != nil {
+			return nil, err
+		}
+		used += turnsTokens[i]
+	}
+
+	summaryMaxTokens := cv.SummaryMaxTokens
+	if summaryMaxTokens <= 0 {
+		summaryMaxTokens = defaultSummaryMaxTokens
+	}
+
+	summaryTokens := 0
+	if memory.summary != "" {
+		summaryTokens, err = countMessagesTokens(info, summaryMessage(memory.summary))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if used+summaryTokens <= budget {
+		return memory, nil
+	}
+
+	if cv.Strategy == MemorySummarize {
+		// Room is kept for the longest summary, the overhead of its message included
+		summaryTokens = summaryMaxTokens + 50
+	}
+
+	// The oldest turns are dropped until the conversation fits, the last one is always kept
+	dropped := 0
+	for used+summaryTokens > budget {
+		if dropped >= len(memory.turns)-1 {
+			return nil, fmt.Errorf("the last turn of the conversation does not fit in the context window of model %s, we need %d tokens and have %d", req.Model, used+summaryTokens, budget)
+		}
+		used -= turnsTokens[dropped]
+		dropped++
+	}
+
+	if cv.Strategy == MemorySummarize {
+		// Half of the turns at least are summarised so as not to summarise at each completion
+		if half := len(memory.turns) / 2; dropped < half {
+			dropped = half
+		}
+
+		memory.summary, err = c.summarizeTurns(ctx, cv, req, memory.summary, memory.turns[:dropped], summaryMaxTokens)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	memory.turns = memory.turns[dropped:]
+
+	return memory, nil
+}
+
+func (c *Client) summarizeTurns(ctx context.Context, cv *Conversation, req *ChatCompletionRequest, summary string, turns [][]ChatCompletionMessage, maxTokens int) (string, error) {
+	model := cv.SummaryModel
+	if model == "" {
+		model = GPT4o_Mini_128k
+	}
+
+	var transcript strings.Builder
+	if summary != "" {
+		transcript.WriteString("Summary of the earlier conversation:\n" + summary + "\n\n")
+	}
+	for _, turn := range turns {
+		for _, message := range turn {
+			writeTranscriptMessage(&transcript, &message)
+		}
+	}
+
+	resp, err := c.CreateChatCompletion(ctx, &ChatCompletionRequest{
+		APIKEY:     req.APIKEY,
+		MaxRetries: req.MaxRetries,
+		Model:      model,
+		MaxTokens:  maxTokens,
+		Messages: []ChatCompletionMessage{
+			{
+				Role: System,
+				Content: "Summarize the following conversation in a few sentences, merging the earlier summary if any. " +
+					"Keep the facts, names, numbers, decisions and open questions needed to continue the conversation.",
+			},
+			{Role: User, Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not summarize the conversation: %w", err)
+	}
+	cv.Price += resp.Price
+
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("could not summarize the conversation: the response has no choice")
+	}
+	text, _ := resp.Choices[0].Message.Content.(string)
+	if text == "" {
+		return "", fmt.Errorf("could not summarize the conversation: the summary is empty")
+	}
+
+	return text, nil
+}
+
+func writeTranscriptMessage(w *strings.Builder, message *ChatCompletionMessage) {
+	w.WriteString(string(message.Role) + ": ")
+	switch t := message.Content.(type) {
+	case string:
+		w.WriteString(t)
+	case []ContentPart:
+		for _, part := range t {
+			if part.Type == "text" {
+				w.WriteString(part.Text + " ")
+			} else {
+				w.WriteString("[image] ")
+			}
+		}
+	}
+	for _, tc := range message.ToolCalls {
+		if tc.Function != nil {
+			w.WriteString("called " + tc.Function.Name + "(" + tc.Function.Arguments + ") ")
+		}
+	}
+	w.WriteString("\n")
+}
+
+func countMessagesTokens(info *ModelInfo, messages ...ChatCompletionMessage) (int, error) {
+	var numTokens int
+	for i := range messages {
+		tokencount, err := countMessageTokens(info, &messages[i])
+		if err != nil {
+			return 0, err
+		}
+		numTokens += tokencount
+	}
+	return numTokens, nil
+}
@@ -1,7 +1,7 @@
 package openai
 
 import (
-	"fmt"
+	"context"
 )
 
 const urlSuffix_edits = "v1/edits"
@@ -38,19 +38,27 @@ type EditsResponse struct {
 	Price float64 `json:"price,omitempty"`
 }
 
-func CreateEdit(req *EditsRequest) (*EditsResponse, error) {
+func CreateEdit(ctx context.Context, req *EditsRequest) (*EditsResponse, error) {
+	return DefaultClient.CreateEdit(ctx, req)
+}
+
+func (c *Client) CreateEdit(ctx context.Context, req *EditsRequest) (*EditsResponse, error) {
 	resp := &EditsResponse{}
 
-	if PricingPer1000TokensPerModel[req.Model] == nil {
-		return nil, fmt.Errorf("unknown model: %s", req.Model)
+	_, err := GetPricing(req.Model)
+	if err != nil {
+		return nil, err
 	}
 
-	err := request("POST", urlSuffix_edits, req, resp, req.APIKEY, req.MaxRetries)
+	err = c.request(ctx, "POST", urlSuffix_edits, req, resp, req.APIKEY, req.MaxRetries)
 	if err != nil {
 		return nil, err
 	}
 
-	resp.Price = resp.Usage.ComputePrice(req.Model)
+	resp.Price, err = resp.Usage.ComputePrice(req.Model)
+	if err != nil {
+		return nil, err
+	}
 
 	return resp, nil
 }
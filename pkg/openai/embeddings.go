@@ -1,7 +1,7 @@
 package openai
 
 import (
-	"fmt"
+	"context"
 )
 
 const urlSuffix_embeddings = "v1/embeddings"
@@ -40,19 +40,27 @@ type EmbeddingResponse struct {
 	Price float64 `json:"price,omitempty"`
 }
 
-func CreateEmbedding(req *EmbeddingRequest) (*EmbeddingResponse, error) {
+func CreateEmbedding(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResponse, error) {
+	return DefaultClient.CreateEmbedding(ctx, req)
+}
+
+func (c *Client) CreateEmbedding(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResponse, error) {
 	resp := &EmbeddingResponse{}
 
-	if PricingPer1000TokensPerModel[req.Model] == nil {
-		return nil, fmt.Errorf("unknown model: %s", req.Model)
+	_, err := GetPricing(req.Model)
+	if err != nil {
+		return nil, err
 	}
 
-	err := request("POST", urlSuffix_embeddings, req, resp, req.APIKEY, req.MaxRetries)
+	err = c.request(ctx, "POST", urlSuffix_embeddings, req, resp, req.APIKEY, req.MaxRetries)
 	if err != nil {
 		return nil, err
 	}
 
-	resp.Price = resp.Usage.ComputePrice(req.Model)
+	resp.Price, err = resp.Usage.ComputePrice(req.Model)
+	if err != nil {
+		return nil, err
+	}
 
 	return resp, nil
 }
@@ -1,14 +1,41 @@
 package openai
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
 )
 
 var reExtractNumbers = regexp.MustCompile(`(?m)(\d+)`)
 
+var (
+	reContextLengthAllowed   = regexp.MustCompile(`maximum context length is (\d+) tokens`)
+	reContextLengthRequested = regexp.MustCompile(`(?:requested|resulted in) (\d+) tokens`)
+)
+
+// The errors returned by the API can be matched against these with errors.Is, for example
+// errors.Is(err, openai.ErrRateLimited). Use errors.As with *APIError or *RequestError to get
+// the status code, the request ID and the retry-after hint.
+var (
+	// ErrRateLimited is a 429 error because of the requests or tokens per minute limits
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQuotaExceeded is a 429 error because the account has no credit left, retrying does not help
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrContextLengthExceeded errors are *ContextLengthError
+	ErrContextLengthExceeded = errors.New("context length exceeded")
+	ErrContentPolicy         = errors.New("rejected by the content policy")
+	ErrServerOverloaded      = errors.New("server overloaded")
+	// ErrTimeout matches requests which did not get a response in time, as well as 408 and 504 errors
+	ErrTimeout = requests.ErrTimeout
+)
+
 // APIError provides error information returned by the OpenAI API.
 type APIError struct {
 	Code       *string `json:"code,omitempty"`
@@ -16,12 +43,30 @@ type APIError struct {
 	Param      *string `json:"param,omitempty"`
 	Type       string  `json:"type"`
 	StatusCode int     `json:"-"`
+
+	// RequestID is the x-request-id header of the response, OpenAI support asks for it
+	RequestID string `json:"-"`
+	// RetryAfter is how long the API asked us to wait before trying again, if it did
+	RetryAfter time.Duration `json:"-"`
 }
 
 // RequestError provides informations about generic request errors.
 type RequestError struct {
 	StatusCode int
 	Err        error
+
+	RequestID  string
+	RetryAfter time.Duration
+}
+
+// ContextLengthError is returned when the prompt and MaxTokens do not fit in the context length of the model
+type ContextLengthError struct {
+	*APIError
+
+	// Requested is the number of tokens of the request, including MaxTokens when it was set
+	Requested int
+	// Allowed is the context length of the model
+	Allowed int
 }
 
 type ErrorResponse struct {
@@ -32,6 +77,14 @@ func (e *APIError) Error() string {
 	return e.Message
 }
 
+func (e *APIError) Is(target error) bool {
+	var code string
+	if e.Code != nil {
+		code = *e.Code
+	}
+	return target != nil && classifyError(e.StatusCode, code, e.Type, e.Message) == target
+}
+
 func (e *RequestError) Error() string {
 	if e.Err != nil {
 		return e.Err.Error()
@@ -43,33 +96,137 @@ func (e *RequestError) Unwrap() error {
 	return e.Err
 }
 
-// IsErrorContextLengthOverflow returns true and the delta to apply to the MaxTokens request parameter
-// if it is a context length overflow, false otherwise
-func IsErrorContextLengthOverflow(err string) (bool, int, error) {
-	err = strings.ToLower(err)
-	if (strings.Contains(err, "maximum") && strings.Contains(err, "context") && strings.Contains(err, "length")) ||
-		strings.Contains(err, "reduce") && strings.Contains(err, "length") && strings.Contains(err, "context") {
-
-		var first, second int
-		for _, ns := range reExtractNumbers.FindAllString(err, -1) {
-			n, err := strconv.Atoi(ns)
-			if err != nil {
-				panic(fmt.Errorf("Should not happen since we use our own regexp to extract numbers: %v", err))
-			}
-			if n > first {
-				second = first
-				first = n
-			} else if n > second {
-				second = n
+func (e *RequestError) Is(target error) bool {
+	return target != nil && classifyError(e.StatusCode, "", "", "") == target
+}
+
+func (e *ContextLengthError) Unwrap() error {
+	return e.APIError
+}
+
+// classifyError returns the sentinel error matching an error returned by the API, or nil
+func classifyError(statusCode int, code, typ, message string) error {
+	switch {
+	case code == "context_length_exceeded":
+		return ErrContextLengthExceeded
+	case code == "insufficient_quota" || typ == "insufficient_quota":
+		return ErrQuotaExceeded
+	case code == "invalid_api_key" || statusCode == http.StatusUnauthorized:
+		return ErrInvalidAPIKey
+	case code == "content_policy_violation" || code == "content_filter":
+		return ErrContentPolicy
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return ErrTimeout
+	case statusCode == http.StatusServiceUnavailable || statusCode == http.StatusBadGateway ||
+		(statusCode >= 500 && strings.Contains(strings.ToLower(message), "overloaded")):
+		return ErrServerOverloaded
+	}
+
+	// Older models report context length overflows without a code
+	if statusCode == http.StatusBadRequest && isContextLengthMessage(message) {
+		return ErrContextLengthExceeded
+	}
+
+	return nil
+}
+
+// newAPIError completes e with the informations of the response, and turns it into a *ContextLengthError if it is one
+func newAPIError(e *APIError, statusCode int, header http.Header) error {
+	e.StatusCode = statusCode
+	e.RequestID = header.Get("X-Request-Id")
+	e.RetryAfter = requests.RetryAfter(header)
+
+	if errors.Is(e, ErrContextLengthExceeded) {
+		requested, allowed, ok := parseContextLength(e.Message)
+		if ok {
+			return &ContextLengthError{
+				APIError:  e,
+				Requested: requested,
+				Allowed:   allowed,
 			}
 		}
+	}
+
+	return e
+}
+
+// isErrorFatal tells whether retrying a request which failed with err is pointless. Rate limits, timeouts and
+// server errors are worth retrying, other client errors are not since the same request would fail again.
+func isErrorFatal(err error) bool {
+	if errors.Is(err, Err429) || errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrInvalidAPIKey) ||
+		errors.Is(err, ErrContentPolicy) || errors.Is(err, ErrContextLengthExceeded) {
+		return true
+	}
+
+	var statusCode int
+	var apiErr *APIError
+	var reqErr *RequestError
+	if errors.As(err, &apiErr) {
+		statusCode = apiErr.StatusCode
+	} else if errors.As(err, &reqErr) {
+		statusCode = reqErr.StatusCode
+	}
+
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	}
+
+	return statusCode >= 400 && statusCode < 500
+}
+
+// parseContextLength extracts the number of tokens requested and the context length of the model from the message
+// of a context length overflow error
+func parseContextLength(message string) (requested, allowed int, ok bool) {
+	message = strings.ToLower(message)
+
+	if m := reContextLengthAllowed.FindStringSubmatch(message); m != nil {
+		allowed, _ = strconv.Atoi(m[1])
+	}
+	if m := reContextLengthRequested.FindStringSubmatch(message); m != nil {
+		requested, _ = strconv.Atoi(m[1])
+	}
+	if allowed > 0 && requested > 0 {
+		return requested, allowed, true
+	}
 
-		if first == 0 || second == 0 {
-			return false, 0, fmt.Errorf("We could not identify the numbers in the context length overflow error")
+	// Otherwise we assume the two largest numbers of the message are the ones we are looking for
+	requested, allowed = 0, 0
+	for _, ns := range reExtractNumbers.FindAllString(message, -1) {
+		n, err := strconv.Atoi(ns)
+		if err != nil {
+			panic(fmt.Errorf("Should not happen since we use our own regexp to extract numbers: %v", err))
+		}
+		if n > requested {
+			allowed = requested
+			requested = n
+		} else if n > allowed {
+			allowed = n
 		}
+	}
+
+	return requested, allowed, requested > 0 && allowed > 0
+}
+
+func isContextLengthMessage(message string) bool {
+	message = strings.ToLower(message)
+	return (strings.Contains(message, "maximum") && strings.Contains(message, "context") && strings.Contains(message, "length")) ||
+		strings.Contains(message, "reduce") && strings.Contains(message, "length") && strings.Contains(message, "context")
+}
+
+// IsErrorContextLengthOverflow returns true and the delta to apply to the MaxTokens request parameter
+// if it is a context length overflow, false otherwise. Prefer errors.As with *ContextLengthError.
+func IsErrorContextLengthOverflow(err string) (bool, int, error) {
+	if !isContextLengthMessage(err) {
+		return false, 0, nil
+	}
 
-		return true, second - first - 1, nil
+	requested, allowed, ok := parseContextLength(err)
+	if !ok {
+		return false, 0, fmt.Errorf("We could not identify the numbers in the context length overflow error")
 	}
 
-	return false, 0, nil
+	return true, allowed - requested - 1, nil
 }
@@ -0,0 +1,238 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
+)
+
+const urlSuffix_files = "v1/files"
+
+type FilePurpose string
+
+const (
+	FilePurposeBatch      FilePurpose = "batch"
+	FilePurposeFineTune   FilePurpose = "fine-tune"
+	FilePurposeAssistants FilePurpose = "assistants"
+	FilePurposeVision     FilePurpose = "vision"
+
+	// Purposes of the files created by OpenAI
+	FilePurposeBatchOutput     FilePurpose = "batch_output"
+	FilePurposeFineTuneResults FilePurpose = "fine-tune-results"
+)
+
+type File struct {
+	ID        string      `json:"id"`
+	Object    string      `json:"object"`
+	Bytes     int64       `json:"bytes"`
+	CreatedAt int64       `json:"created_at"`
+	FileName  string      `json:"filename"`
+	Purpose   FilePurpose `json:"purpose"`
+}
+
+type UploadFileRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string
+	MaxRetries int
+
+	File io.Reader
+	// FileName must have the extension of the format of File, for example input.jsonl for batches
+	FileName string
+	Purpose  FilePurpose
+}
+
+type FileRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	FileID string `json:"-"`
+}
+
+type ListFilesRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	// Optional, only list the files with this purpose
+	Purpose FilePurpose `json:"-"`
+	// Optional, between 1 and 10000, defaults to 10000
+	Limit int `json:"-"`
+	// Optional, the ID of the last file of the previous page
+	After string `json:"-"`
+}
+
+type ListFilesResponse struct {
+	Object  string `json:"object"`
+	Data    []File `json:"data"`
+	FirstID string `json:"first_id,omitempty"`
+	LastID  string `json:"last_id,omitempty"`
+	HasMore bool   `json:"has_more"`
+}
+
+type DeleteFileResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+func UploadFile(ctx context.Context, req *UploadFileRequest) (*File, error) {
+	return DefaultClient.UploadFile(ctx, req)
+}
+
+func (c *Client) UploadFile(ctx context.Context, req *UploadFileRequest) (*File, error) {
+	if req.File == nil {
+		return nil, fmt.Errorf("we need a file to upload")
+	}
+	if req.FileName == "" {
+		return nil, fmt.Errorf("we need the name of the file, with its extension")
+	}
+	if req.Purpose == "" {
+		return nil, fmt.Errorf("we need the purpose of the file")
+	}
+
+	form := &requests.MultipartForm{}
+	err := form.AddFile("file", req.FileName, "", req.File)
+	if err != nil {
+		return nil, err
+	}
+	form.AddField("purpose", string(req.Purpose))
+
+	resp := &File{}
+
+	err = c.request(ctx, "POST", urlSuffix_files, form, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func ListFiles(ctx context.Context, req *ListFilesRequest) (*ListFilesResponse, error) {
+	return DefaultClient.ListFiles(ctx, req)
+}
+
+func (c *Client) ListFiles(ctx context.Context, req *ListFilesRequest) (*ListFilesResponse, error) {
+	query := pageQuery(req.Limit, req.After)
+	if req.Purpose != "" {
+		query.Set("purpose", string(req.Purpose))
+	}
+
+	resp := &ListFilesResponse{}
+
+	err := c.request(ctx, "GET", withQuery(urlSuffix_files, query), nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func RetrieveFile(ctx context.Context, req *FileRequest) (*File, error) {
+	return DefaultClient.RetrieveFile(ctx, req)
+}
+
+func (c *Client) RetrieveFile(ctx context.Context, req *FileRequest) (*File, error) {
+	path, err := filePath(req.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &File{}
+
+	err = c.request(ctx, "GET", path, nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func DeleteFile(ctx context.Context, req *FileRequest) (*DeleteFileResponse, error) {
+	return DefaultClient.DeleteFile(ctx, req)
+}
+
+func (c *Client) DeleteFile(ctx context.Context, req *FileRequest) (*DeleteFileResponse, error) {
+	path, err := filePath(req.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &DeleteFileResponse{}
+
+	err = c.request(ctx, "DELETE", path, nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func DownloadFile(ctx context.Context, req *FileRequest, w io.Writer) error {
+	return DefaultClient.DownloadFile(ctx, req, w)
+}
+
+// DownloadFile writes the content of the file to w as it is received
+func (c *Client) DownloadFile(ctx context.Context, req *FileRequest, w io.Writer) error {
+	path, err := filePath(req.FileID)
+	if err != nil {
+		return err
+	}
+	path += "/content"
+
+	httpresp := &http.Response{}
+
+	r, err := c.newRetryableRequest("GET", path, nil, httpresp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return err
+	}
+	r.Headers.Set("Accept", "*/*")
+
+	ctx, span, err := c.startSpan(ctx, r, path, nil)
+	if err != nil {
+		return err
+	}
+
+	err = c.retrier.Request(ctx, r)
+	if err == nil {
+		_, err = io.Copy(w, httpresp.Body)
+		httpresp.Body.Close()
+		if err != nil {
+			err = fmt.Errorf("could not download file %s: %w", req.FileID, err)
+		}
+	}
+
+	endSpan(span, r.Attempts(), nil, nil, err)
+
+	return err
+}
+
+func filePath(fileID string) (string, error) {
+	if fileID == "" {
+		return "", fmt.Errorf("we need a file id")
+	}
+	return urlSuffix_files + "/" + url.PathEscape(fileID), nil
+}
+
+func withQuery(path string, query url.Values) string {
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+func pageQuery(limit int, after string) url.Values {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if after != "" {
+		query.Set("after", after)
+	}
+	return query
+}
@@ -0,0 +1,528 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+const urlSuffix_finetuningjobs = "v1/fine_tuning/jobs"
+
+type FineTuningJobStatus string
+
+const (
+	FineTuningJobStatusValidatingFiles FineTuningJobStatus = "validating_files"
+	FineTuningJobStatusQueued          FineTuningJobStatus = "queued"
+	FineTuningJobStatusRunning         FineTuningJobStatus = "running"
+	FineTuningJobStatusSucceeded       FineTuningJobStatus = "succeeded"
+	FineTuningJobStatusFailed          FineTuningJobStatus = "failed"
+	FineTuningJobStatusCancelled       FineTuningJobStatus = "cancelled"
+)
+
+type Hyperparameters struct {
+	// "auto" or an int
+	NEpochs interface{} `json:"n_epochs,omitempty"`
+	// "auto" or an int
+	BatchSize interface{} `json:"batch_size,omitempty"`
+	// "auto" or a float
+	LearningRateMultiplier interface{} `json:"learning_rate_multiplier,omitempty"`
+}
+
+type FineTuningJob struct {
+	ID              string              `json:"id"`
+	Object          string              `json:"object"`
+	CreatedAt       int64               `json:"created_at"`
+	FinishedAt      int64               `json:"finished_at,omitempty"`
+	EstimatedFinish int64               `json:"estimated_finish,omitempty"`
+	Model           Model               `json:"model"`
+	FineTunedModel  Model               `json:"fine_tuned_model,omitempty"`
+	OrganizationID  string              `json:"organization_id"`
+	Status          FineTuningJobStatus `json:"status"`
+	Hyperparameters Hyperparameters     `json:"hyperparameters"`
+	TrainingFile    string              `json:"training_file"`
+	ValidationFile  string              `json:"validation_file,omitempty"`
+	ResultFiles     []string            `json:"result_files"`
+	TrainedTokens   int                 `json:"trained_tokens,omitempty"`
+	Seed            int                 `json:"seed"`
+	Error           *FineTuningJobError `json:"error,omitempty"`
+}
+
+type FineTuningJobError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+}
+
+type FineTuningEvent struct {
+	ID        string          `json:"id"`
+	Object    string          `json:"object"`
+	CreatedAt int64           `json:"created_at"`
+	Level     string          `json:"level"`
+	Message   string          `json:"message"`
+	Type      string          `json:"type,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+type FineTuningCheckpoint struct {
+	ID                       string                      `json:"id"`
+	Object                   string                      `json:"object"`
+	CreatedAt                int64                       `json:"created_at"`
+	FineTunedModelCheckpoint Model                       `json:"fine_tuned_model_checkpoint"`
+	FineTuningJobID          string                      `json:"fine_tuning_job_id"`
+	StepNumber               int                         `json:"step_number"`
+	Metrics                  FineTuningCheckpointMetrics `json:"metrics"`
+}
+
+type FineTuningCheckpointMetrics struct {
+	Step                       float64 `json:"step"`
+	TrainLoss                  float64 `json:"train_loss"`
+	TrainMeanTokenAccuracy     float64 `json:"train_mean_token_accuracy"`
+	ValidLoss                  float64 `json:"valid_loss"`
+	ValidMeanTokenAccuracy     float64 `json:"valid_mean_token_accuracy"`
+	FullValidLoss              float64 `json:"full_valid_loss"`
+	FullValidMeanTokenAccuracy float64 `json:"full_valid_mean_token_accuracy"`
+}
+
+type CreateFineTuningJobRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	// Base model, or a fine-tuned model to continue training it
+	Model Model `json:"model"`
+	// ID of a jsonl file uploaded with the fine-tune purpose, see ValidateFineTuningFile
+	TrainingFile    string           `json:"training_file"`
+	ValidationFile  string           `json:"validation_file,omitempty"`
+	Hyperparameters *Hyperparameters `json:"hyperparameters,omitempty"`
+	// Up to 18 characters added to the name of the fine-tuned model
+	Suffix string `json:"suffix,omitempty"`
+	Seed   *int   `json:"seed,omitempty"`
+}
+
+type FineTuningJobRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	JobID string `json:"-"`
+}
+
+// ListFineTuningRequest pages through jobs, or through the events or checkpoints of JobID.
+// Pass the ID of the last item of a page as After to get the next one, as long as HasMore is true.
+type ListFineTuningRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	// Only used to list events and checkpoints
+	JobID string `json:"-"`
+	// Optional, defaults to 20 for jobs and events and to 10 for checkpoints
+	Limit int    `json:"-"`
+	After string `json:"-"`
+}
+
+type ListFineTuningJobsResponse struct {
+	Object  string          `json:"object"`
+	Data    []FineTuningJob `json:"data"`
+	HasMore bool            `json:"has_more"`
+}
+
+type ListFineTuningEventsResponse struct {
+	Object  string            `json:"object"`
+	Data    []FineTuningEvent `json:"data"`
+	HasMore bool              `json:"has_more"`
+}
+
+type ListFineTuningCheckpointsResponse struct {
+	Object  string                 `json:"object"`
+	Data    []FineTuningCheckpoint `json:"data"`
+	FirstID string                 `json:"first_id,omitempty"`
+	LastID  string                 `json:"last_id,omitempty"`
+	HasMore bool                   `json:"has_more"`
+}
+
+func CreateFineTuningJob(ctx context.Context, req *CreateFineTuningJobRequest) (*FineTuningJob, error) {
+	return DefaultClient.CreateFineTuningJob(ctx, req)
+}
+
+func (c *Client) CreateFineTuningJob(ctx context.Context, req *CreateFineTuningJobRequest) (*FineTuningJob, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("we need the model to fine-tune")
+	}
+	if req.TrainingFile == "" {
+		return nil, fmt.Errorf("we need the id of the training file")
+	}
+
+	resp := &FineTuningJob{}
+
+	err := c.request(ctx, "POST", urlSuffix_finetuningjobs, req, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func ListFineTuningJobs(ctx context.Context, req *ListFineTuningRequest) (*ListFineTuningJobsResponse, error) {
+	return DefaultClient.ListFineTuningJobs(ctx, req)
+}
+
+func (c *Client) ListFineTuningJobs(ctx context.Context, req *ListFineTuningRequest) (*ListFineTuningJobsResponse, error) {
+	resp := &ListFineTuningJobsResponse{}
+
+	err := c.request(ctx, "GET", withQuery(urlSuffix_finetuningjobs, pageQuery(req.Limit, req.After)), nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func RetrieveFineTuningJob(ctx context.Context, req *FineTuningJobRequest) (*FineTuningJob, error) {
+	return DefaultClient.RetrieveFineTuningJob(ctx, req)
+}
+
+func (c *Client) RetrieveFineTuningJob(ctx context.Context, req *FineTuningJobRequest) (*FineTuningJob, error) {
+	path, err := fineTuningJobPath(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &FineTuningJob{}
+
+	err = c.request(ctx, "GET", path, nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func CancelFineTuningJob(ctx context.Context, req *FineTuningJobRequest) (*FineTuningJob, error) {
+	return DefaultClient.CancelFineTuningJob(ctx, req)
+}
+
+func (c *Client) CancelFineTuningJob(ctx context.Context, req *FineTuningJobRequest) (*FineTuningJob, error) {
+	path, err := fineTuningJobPath(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &FineTuningJob{}
+
+	err = c.request(ctx, "POST", path+"/cancel", nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func ListFineTuningEvents(ctx context.Context, req *ListFineTuningRequest) (*ListFineTuningEventsResponse, error) {
+	return DefaultClient.ListFineTuningEvents(ctx, req)
+}
+
+func (c *Client) ListFineTuningEvents(ctx context.Context, req *ListFineTuningRequest) (*ListFineTuningEventsResponse, error) {
+	path, err := fineTuningJobPath(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ListFineTuningEventsResponse{}
+
+	err = c.request(ctx, "GET", withQuery(path+"/events", pageQuery(req.Limit, req.After)), nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func ListFineTuningCheckpoints(ctx context.Context, req *ListFineTuningRequest) (*ListFineTuningCheckpointsResponse, error) {
+	return DefaultClient.ListFineTuningCheckpoints(ctx, req)
+}
+
+func (c *Client) ListFineTuningCheckpoints(ctx context.Context, req *ListFineTuningRequest) (*ListFineTuningCheckpointsResponse, error) {
+	path, err := fineTuningJobPath(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ListFineTuningCheckpointsResponse{}
+
+	err = c.request(ctx, "GET", withQuery(path+"/checkpoints", pageQuery(req.Limit, req.After)), nil, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func fineTuningJobPath(jobID string) (string, error) {
+	if jobID == "" {
+		return "", fmt.Errorf("we need a fine-tuning job id")
+	}
+	return urlSuffix_finetuningjobs + "/" + url.PathEscape(jobID), nil
+}
+
+// FineTuningExample is one line of a chat fine-tuning file
+type FineTuningExample struct {
+	Messages          []FineTuningMessage      `json:"messages"`
+	Tools             []ChatCompletionToolCall `json:"tools,omitempty"`
+	ParallelToolCalls *bool                    `json:"parallel_tool_calls,omitempty"`
+}
+
+type FineTuningMessage struct {
+	Role       MessageRole `json:"role"`
+	Content    interface{} `json:"content"`
+	Name       string      `json:"name,omitempty"`
+	ToolCalls  []*ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+	// Deprecated by ToolCalls but still accepted for training
+	FunctionCall *Function `json:"function_call,omitempty"`
+	// Optional, 0 to skip training on this assistant message
+	Weight *int `json:"weight,omitempty"`
+}
+
+// FineTuningFileIssue is a problem found at Line of a fine-tuning file, Line is 0 for the file as a whole
+type FineTuningFileIssue struct {
+	Line    int
+	Message string
+}
+
+func (i FineTuningFileIssue) String() string {
+	if i.Line == 0 {
+		return i.Message
+	}
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+type FineTuningFileReport struct {
+	Examples int
+	// Errors make OpenAI reject the file
+	Errors []FineTuningFileIssue
+	// Warnings do not, for example examples longer than the context length which are truncated
+	Warnings []FineTuningFileIssue
+
+	// Tokens of all the examples, as counted by CountTokensCompletion. It adds a safety margin to each example
+	// so the estimations are on the high side, especially for short examples.
+	TotalTokens      int
+	MaxExampleTokens int
+	// Epochs is the number of epochs the estimation is made for
+	Epochs int
+	// BilledTokens is the number of tokens the training is billed for, truncated examples included
+	BilledTokens int
+	// EstimatedPrice of the training in dollars
+	EstimatedPrice float64
+}
+
+// Valid tells whether OpenAI should accept the file
+func (r *FineTuningFileReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Limits of the fine-tuning files
+const (
+	MinFineTuningExamples = 10
+
+	defaultFineTuningEpochs    = 3
+	minTargetFineTuningSamples = 100
+	maxTargetFineTuningSamples = 25000
+	minDefaultFineTuningEpochs = 1
+	maxDefaultFineTuningEpochs = 25
+)
+
+// DefaultFineTuningEpochs returns the number of epochs OpenAI trains for when n_epochs is auto, for a file of n examples
+func DefaultFineTuningEpochs(n int) int {
+	if n <= 0 {
+		return defaultFineTuningEpochs
+	}
+
+	epochs := defaultFineTuningEpochs
+	if n*epochs < minTargetFineTuningSamples {
+		epochs = (minTargetFineTuningSamples + n - 1) / n
+		if epochs > maxDefaultFineTuningEpochs {
+			epochs = maxDefaultFineTuningEpochs
+		}
+	} else if n*epochs > maxTargetFineTuningSamples {
+		epochs = maxTargetFineTuningSamples / n
+		if epochs < minDefaultFineTuningEpochs {
+			epochs = minDefaultFineTuningEpochs
+		}
+	}
+
+	return epochs
+}
+
+// ValidateFineTuningFile checks the format of a chat fine-tuning jsonl file before it is uploaded, and estimates the
+// price of training model on it for epochs epochs. With epochs 0, the number of epochs OpenAI picks by default is used.
+// Problems of the file are reported in the returned report, the error is only set if model cannot be fine-tuned or
+// if r cannot be read.
+func ValidateFineTuningFile(r io.Reader, model Model, epochs int) (*FineTuningFileReport, error) {
+	info, err := LookupModel(model)
+	if err != nil {
+		return nil, err
+	}
+	// Fine-tuned models can be trained further, at the training price of their base model
+	training := info.Pricing.Training
+	if training == 0 && model.IsFineTuned() {
+		base, err := LookupModel(model.BaseModel())
+		if err == nil {
+			training = base.Pricing.Training
+		}
+	}
+	if training == 0 {
+		return nil, fmt.Errorf("model %s cannot be fine-tuned", model)
+	}
+
+	report := &FineTuningFileReport{}
+
+	// Examples longer than the context length of the model are truncated
+	contextLength := int(info.ContextLength)
+
+	var tokensPerExample []int
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			report.Errors = append(report.Errors, FineTuningFileIssue{Line: line, Message: "empty line"})
+			continue
+		}
+
+		report.Examples++
+
+		var example FineTuningExample
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&example)
+		if err != nil {
+			report.Errors = append(report.Errors, FineTuningFileIssue{Line: line, Message: fmt.Sprintf("invalid example: %v", err)})
+			continue
+		}
+
+		issues := validateFineTuningExample(&example)
+		if len(issues) > 0 {
+			for _, msg := range issues {
+				report.Errors = append(report.Errors, FineTuningFileIssue{Line: line, Message: msg})
+			}
+			continue
+		}
+
+		tokens, err := countFineTuningTokens(&example, model)
+		if err != nil {
+			report.Warnings = append(report.Warnings, FineTuningFileIssue{Line: line, Message: fmt.Sprintf("could not count tokens: %v", err)})
+			continue
+		}
+		if contextLength > 0 && tokens > contextLength {
+			report.Warnings = append(report.Warnings, FineTuningFileIssue{
+				Line:    line,
+				Message: fmt.Sprintf("the example has %d tokens and will be truncated to the %d tokens of the context length", tokens, contextLength),
+			})
+		}
+
+		tokensPerExample = append(tokensPerExample, tokens)
+		report.TotalTokens += tokens
+		if tokens > report.MaxExampleTokens {
+			report.MaxExampleTokens = tokens
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read the fine-tuning file: %v", err)
+	}
+
+	if report.Examples < MinFineTuningExamples {
+		report.Errors = append(report.Errors, FineTuningFileIssue{
+			Message: fmt.Sprintf("the file has %d examples but at least %d are required", report.Examples, MinFineTuningExamples),
+		})
+	}
+
+	report.Epochs = epochs
+	if report.Epochs <= 0 {
+		report.Epochs = DefaultFineTuningEpochs(report.Examples)
+	}
+
+	for _, tokens := range tokensPerExample {
+		if contextLength > 0 && tokens > contextLength {
+			tokens = contextLength
+		}
+		report.BilledTokens += tokens * report.Epochs
+	}
+
+	report.EstimatedPrice = float64(report.BilledTokens) / 1000 * training
+
+	return report, nil
+}
+
+func validateFineTuningExample(example *FineTuningExample) []string {
+	if len(example.Messages) == 0 {
+		return []string{"missing messages"}
+	}
+
+	var issues []string
+	var assistantMessages int
+
+	for i, m := range example.Messages {
+		switch m.Role {
+		default:
+			issues = append(issues, fmt.Sprintf("message %d has the unrecognized role %q", i, m.Role))
+			continue
+		case System, User, Tool, "function":
+		case Assistant:
+			assistantMessages++
+		}
+
+		if m.Content == nil && len(m.ToolCalls) == 0 && m.FunctionCall == nil {
+			issues = append(issues, fmt.Sprintf("message %d has no content", i))
+		}
+		if m.Content != nil {
+			if _, ok := m.Content.(string); !ok {
+				issues = append(issues, fmt.Sprintf("message %d has a content which is not a string", i))
+			}
+		}
+
+		if m.Weight != nil {
+			if m.Role != Assistant {
+				issues = append(issues, fmt.Sprintf("message %d has a weight but only assistant messages can", i))
+			} else if *m.Weight != 0 && *m.Weight != 1 {
+				issues = append(issues, fmt.Sprintf("message %d has a weight of %d but it must be 0 or 1", i, *m.Weight))
+			}
+		}
+	}
+
+	if assistantMessages == 0 {
+		issues = append(issues, "the example has no assistant message to learn from")
+	}
+
+	return issues
+}
+
+func countFineTuningTokens(example *FineTuningExample, model Model) (int, error) {
+	req := &ChatCompletionRequest{
+		Model: model,
+		Tools: example.Tools,
+	}
+
+	for _, m := range example.Messages {
+		msg := ChatCompletionMessage{
+			Role:      m.Role,
+			Content:   m.Content,
+			ToolCalls: m.ToolCalls,
+		}
+		if m.FunctionCall != nil {
+			msg.ToolCalls = append(msg.ToolCalls, &ToolCall{Type: "function", Function: m.FunctionCall})
+		}
+		req.Messages = append(req.Messages, msg)
+	}
+
+	return CountTokensCompletion(req)
+}
@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 
-	"github.com/arthurweinmann/go-ai-sdk/pkg/openai/pythontool"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/openai/tokenizer"
 )
 
-// GetMaxRemainingTokens uses openai tiktoken to compute the number of tokens and thus you need to have python3 installed
+// GetMaxRemainingTokens uses the tiktoken encoding of the model to compute the number of tokens
 // Watchout for functions definitions which count toward the model context length. I did not find information as to the function syntax
 // used by openai to compute its number of tokens. You can probably approximate it.
 func GetMaxRemainingTokens(prompt string, m Model) (int, error) {
@@ -16,30 +16,27 @@ func GetMaxRemainingTokens(prompt string, m Model) (int, error) {
 		return 0, err
 	}
 
-	switch m {
-	default:
-		return 0, fmt.Errorf("model %s not yet supported", m)
-	case GPT3_5_turbo_4k, GPT3_5_turbo_4k_0301, GPT3_5_turbo_4k_0613:
-		return int(Context4K) - tokencount, nil
-	case GPT3_5_turbo_16k_0613, GPT3_5_turbo_16k:
-		return int(Context16K) - tokencount, nil
-	case GPT4_8k, GPT4_8k_0613:
-		return int(Context8K) - tokencount, nil
-	case GPT4_32k, GPT4_32k_0613:
-		return int(Context32K) - tokencount, nil
+	contextLength, err := m.GetContextLength()
+	if err != nil {
+		return 0, err
+	}
+	if contextLength == 0 {
+		return 0, fmt.Errorf("model %s has no context length", m)
 	}
+
+	return int(contextLength) - tokencount, nil
 }
 
 func CountTokens(prompt string, m Model) (int, error) {
-	var encoding string
-	switch m {
-	default:
-		return 0, fmt.Errorf("model %s not implemented yet for GetMaxRemainingTokens", m)
-	case GPT3_5_turbo_4k, GPT4_8k, GPT4_32k, GPT3_5_turbo_4k_0301, GPT3_5_turbo_4k_0613, GPT3_5_turbo_16k_0613, GPT3_5_turbo_16k, GPT4_8k_0613, GPT4_32k_0613:
-		encoding = "cl100k_base"
+	info, err := LookupModel(m)
+	if err != nil {
+		return 0, err
+	}
+	if info.Encoding == "" {
+		return 0, fmt.Errorf("model %s has no tokenizer encoding", m)
 	}
 
-	tokencount, err := pythontool.CountTokens(encoding, prompt)
+	tokencount, err := countTokens(info.Encoding, prompt)
 	if err != nil {
 		return 0, err
 	}
@@ -56,99 +53,127 @@ func GetMaxRemainingTokensChatCompletion(req *ChatCompletionRequest) (int, error
 		return 0, err
 	}
 
-	switch req.Model {
-	default:
-		return 0, fmt.Errorf("model %s not yet supported", req.Model)
-	case GPT3_5_turbo_4k, GPT3_5_turbo_4k_0301, GPT3_5_turbo_4k_0613:
-		return int(Context4K) - numTokens, nil
-	case GPT3_5_turbo_16k_0613, GPT3_5_turbo_16k:
-		return int(Context16K) - numTokens, nil
-	case GPT4_8k, GPT4_8k_0613:
-		return int(Context8K) - numTokens, nil
-	case GPT4_32k, GPT4_32k_0613:
-		return int(Context32K) - numTokens, nil
+	contextLength, err := req.Model.GetContextLength()
+	if err != nil {
+		return 0, err
+	}
+	if contextLength == 0 {
+		return 0, fmt.Errorf("model %s has no context length", req.Model)
 	}
+
+	return int(contextLength) - numTokens, nil
 }
 
 func CountTokensCompletion(req *ChatCompletionRequest) (int, error) {
-	messages := req.Messages
+	info, err := chatModelInfo(req.Model)
+	if err != nil {
+		return 0, err
+	}
+
+	var numTokens int
+	for i := range req.Messages {
+		tokencount, err := countMessageTokens(info, &req.Messages[i])
+		if err != nil {
+			return 0, err
+		}
+		numTokens += tokencount
+	}
+	numTokens += 3 // every reply is primed with <|start|>assistant<|message|>
 
-	var tokenPerMessage int
-	var encoding string
+	tokencount, err := countToolsTokens(info.Encoding, req.Tools)
+	if err != nil {
+		return 0, err
+	}
+	numTokens += tokencount
 
-	switch req.Model {
-	default:
-		return 0, fmt.Errorf("model %s not implemented yet for GetMaxRemainingTokens", req.Model)
-	case GPT3_5_turbo_4k, GPT3_5_turbo_4k_0301, GPT3_5_turbo_4k_0613, GPT3_5_turbo_16k_0613, GPT3_5_turbo_16k:
-		encoding = "cl100k_base"
+	// We do not seem to get it quite right in some scenario
+	numTokens += 50
 
-		// every message follows <im_start>{role/name}\n{content}<im_end>\n
-		// See https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
-		tokenPerMessage = 4
+	return numTokens, nil
+}
 
-	case GPT4_8k, GPT4_32k, GPT4_8k_0613, GPT4_32k_0613:
-		encoding = "cl100k_base"
-		tokenPerMessage = 3
+func chatModelInfo(m Model) (*ModelInfo, error) {
+	info, err := LookupModel(m)
+	if err != nil {
+		return nil, err
+	}
+	if info.Encoding == "" {
+		return nil, fmt.Errorf("model %s has no tokenizer encoding", m)
 	}
+	if info.TokensPerMessage == 0 {
+		info.TokensPerMessage = 3
+	}
+	return &info, nil
+}
 
-	var numTokens int
-	for _, message := range messages {
-		numTokens += tokenPerMessage
-		switch t := message.Content.(type) {
-		default:
-			return 0, fmt.Errorf("our current implementation does not support a message content of type %T", t)
-		case nil:
-		case string:
-			if t != "" {
-				tokencount, err := pythontool.CountTokens(encoding, t)
-				if err != nil {
-					return 0, err
-				}
-				numTokens += tokencount
-			}
-		case []ContentPart:
-			for _, cp := range t {
-				switch cp.Type {
-				default:
-					return 0, fmt.Errorf("our current implementation does not support a message content part of type %s", cp.Type)
-				case "text":
-					if cp.Text != "" {
-						tokencount, err := pythontool.CountTokens(encoding, cp.Text)
-						if err != nil {
-							return 0, err
-						}
-						numTokens += tokencount
-					}
-				case "image_url":
-					// TODO
-				}
+// countMessageTokens counts the tokens of a message, its overhead included
+func countMessageTokens(info *ModelInfo, message *ChatCompletionMessage) (int, error) {
+	numTokens := info.TokensPerMessage
+	switch t := message.Content.(type) {
+	default:
+		return 0, fmt.Errorf("our current implementation does not support a message content of type %T", t)
+	case nil:
+	case string:
+		if t != "" {
+			tokencount, err := countTokens(info.Encoding, t)
+			if err != nil {
+				return 0, err
 			}
+			numTokens += tokencount
 		}
-
-		for _, tc := range message.ToolCalls {
-			switch tc.Type {
+	case []ContentPart:
+		for _, cp := range t {
+			switch cp.Type {
 			default:
-				return 0, fmt.Errorf("our current implementation does not support a message content part of type %s", tc.Type)
-			case "function":
-				if tc.Function == nil {
-					return 0, fmt.Errorf("we have a message with a toolcall of type function but without a defined function")
+				return 0, fmt.Errorf("our current implementation does not support a message content part of type %s", cp.Type)
+			case "text":
+				if cp.Text != "" {
+					tokencount, err := countTokens(info.Encoding, cp.Text)
+					if err != nil {
+						return 0, err
+					}
+					numTokens += tokencount
 				}
-				numTokens += 12
-				b, err := json.Marshal(tc.Function)
-				if err != nil {
-					return 0, err
+			case "image_url":
+				if cp.ImageURL == nil {
+					return 0, fmt.Errorf("we have a message content part of type image_url without an image url")
 				}
-				tokencount, err := pythontool.CountTokens(encoding, string(b))
-				if err != nil {
-					return 0, err
+				if !supportsModality(info.InputModalities, ModalityImage) {
+					return 0, fmt.Errorf("model %s does not support image inputs", info.Model)
 				}
-				numTokens += tokencount
+				numTokens += imageTokens(*info, cp.ImageURL.Width, cp.ImageURL.Height, cp.ImageURL.Detail)
 			}
 		}
 	}
-	numTokens += 3 // every reply is primed with <|start|>assistant<|message|>
 
-	for _, cf := range req.Tools {
+	for _, tc := range message.ToolCalls {
+		switch tc.Type {
+		default:
+			return 0, fmt.Errorf("our current implementation does not support a message content part of type %s", tc.Type)
+		case "function":
+			if tc.Function == nil {
+				return 0, fmt.Errorf("we have a message with a toolcall of type function but without a defined function")
+			}
+			numTokens += 12
+			b, err := json.Marshal(tc.Function)
+			if err != nil {
+				return 0, err
+			}
+			tokencount, err := countTokens(info.Encoding, string(b))
+			if err != nil {
+				return 0, err
+			}
+			numTokens += tokencount
+		}
+	}
+
+	return numTokens, nil
+}
+
+// countToolsTokens counts the tokens of the definitions of the tools of a request
+func countToolsTokens(encoding string, tools []ChatCompletionToolCall) (int, error) {
+	var numTokens int
+	for _, cf := range tools {
 		switch cf.Type {
 		default:
 			return 0, fmt.Errorf("our current implementation does not support, in the request, a toolcall of type %s", cf.Type)
@@ -157,65 +182,114 @@ func CountTokensCompletion(req *ChatCompletionRequest) (int, error) {
 				return 0, fmt.Errorf("we have a request with a toolcall of type function but without a defined function")
 			}
 			if cf.Function.Name != "" {
-				tokencount, err := pythontool.CountTokens(encoding, cf.Function.Name)
+				tokencount, err := countTokens(encoding, cf.Function.Name)
 				if err != nil {
 					return 0, err
 				}
 				numTokens += tokencount
 			}
 			if cf.Function.Description != "" {
-				tokencount, err := pythontool.CountTokens(encoding, cf.Function.Description)
+				tokencount, err := countTokens(encoding, cf.Function.Description)
 				if err != nil {
 					return 0, err
 				}
 				numTokens += tokencount
 			}
-			if cf.Function.Parameters != nil {
-				numTokens += 11
+			tokencount, err := countParametersTokens(encoding, cf.Function.Parameters)
+			if err != nil {
+				return 0, err
+			}
+			numTokens += tokencount
+		}
+	}
 
-				for propName, prop := range cf.Function.Parameters.Properties {
-					tokencount, err := pythontool.CountTokens(encoding, propName)
-					if err != nil {
-						return 0, err
-					}
-					numTokens += tokencount
+	return numTokens, nil
+}
 
-					if prop.Type != "" {
-						numTokens += 2
-						tokencount, err := pythontool.CountTokens(encoding, prop.Type)
-						if err != nil {
-							return 0, err
-						}
-						numTokens += tokencount
-					}
+// countParametersTokens counts the tokens of the parameters of a function, other types than FunctionParameters,
+// such as a *JSONSchema or a map decoded from JSON, are approximated with their JSON encoding
+func countParametersTokens(encoding string, parameters interface{}) (int, error) {
+	switch params := parameters.(type) {
+	case nil:
+		return 0, nil
+	case FunctionParameters:
+		return countFunctionParametersTokens(encoding, &params)
+	case *FunctionParameters:
+		if params == nil {
+			return 0, nil
+		}
+		return countFunctionParametersTokens(encoding, params)
+	case *JSONSchema:
+		if params == nil {
+			return 0, nil
+		}
+	}
 
-					if prop.Type != "" {
-						numTokens += 2
-						tokencount, err := pythontool.CountTokens(encoding, prop.Type)
-						if err != nil {
-							return 0, err
-						}
-						numTokens += tokencount
-					}
+	b, err := json.Marshal(parameters)
+	if err != nil {
+		return 0, fmt.Errorf("could not encode the function parameters of type %T: %v", parameters, err)
+	}
+	if string(b) == "null" {
+		return 0, nil
+	}
 
-					if len(prop.Enum) > 0 {
-						numTokens -= 3
-						for _, en := range prop.Enum {
-							numTokens += 3
-							tokencount, err := pythontool.CountTokens(encoding, en)
-							if err != nil {
-								return 0, err
-							}
-							numTokens += tokencount
-						}
-					}
+	tokencount, err := countTokens(encoding, string(b))
+	if err != nil {
+		return 0, err
+	}
+
+	return 11 + tokencount, nil
+}
+
+func countFunctionParametersTokens(encoding string, params *FunctionParameters) (int, error) {
+	numTokens := 11
+
+	for propName, prop := range params.Properties {
+		tokencount, err := countTokens(encoding, propName)
+		if err != nil {
+			return 0, err
+		}
+		numTokens += tokencount
+
+		if prop.Type != "" {
+			numTokens += 2
+			tokencount, err := countTokens(encoding, prop.Type)
+			if err != nil {
+				return 0, err
+			}
+			numTokens += tokencount
+		}
+
+		if prop.Type != "" {
+			numTokens += 2
+			tokencount, err := countTokens(encoding, prop.Type)
+			if err != nil {
+				return 0, err
+			}
+			numTokens += tokencount
+		}
+
+		if len(prop.Enum) > 0 {
+			numTokens -= 3
+			for _, en := range prop.Enum {
+				numTokens += 3
+				tokencount, err := countTokens(encoding, en)
+				if err != nil {
+					return 0, err
 				}
+				numTokens += tokencount
 			}
 		}
 	}
 
-	// We do not seem to get it quite right in some scenario
-	numTokens += 50
-
 	return numTokens, nil
 }
+
+func countTokens(encoding, text string) (int, error) {
+	enc, err := tokenizer.Get(encoding)
+	if err != nil {
+		return 0, err
+	}
+
+	return enc.Count(text), nil
+}
@@ -0,0 +1,255 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
+)
+
+const (
+	urlSuffix_imagegenerations = "v1/images/generations"
+	urlSuffix_imageedits       = "v1/images/edits"
+	urlSuffix_imagevariations  = "v1/images/variations"
+)
+
+type ImageSize string
+
+const (
+	ImageSize256x256   ImageSize = "256x256"
+	ImageSize512x512   ImageSize = "512x512"
+	ImageSize1024x1024 ImageSize = "1024x1024"
+	// Only supported by dall-e-3
+	ImageSize1792x1024 ImageSize = "1792x1024"
+	// Only supported by dall-e-3
+	ImageSize1024x1792 ImageSize = "1024x1792"
+)
+
+// ImageQuality is only supported by dall-e-3
+type ImageQuality string
+
+const (
+	ImageQualityStandard ImageQuality = "standard"
+	ImageQualityHD       ImageQuality = "hd"
+)
+
+// ImageStyle is only supported by dall-e-3
+type ImageStyle string
+
+const (
+	ImageStyleVivid   ImageStyle = "vivid"
+	ImageStyleNatural ImageStyle = "natural"
+)
+
+type ImageResponseFormat string
+
+const (
+	// ImageResponseFormatURL urls are only valid for 60 minutes after the image has been generated
+	ImageResponseFormatURL     ImageResponseFormat = "url"
+	ImageResponseFormatB64JSON ImageResponseFormat = "b64_json"
+)
+
+// GetImagePrice returns the price of one image, empty parameters take the default values of the API
+func GetImagePrice(model Model, quality ImageQuality, size ImageSize) (float64, error) {
+	if model == "" {
+		model = DallE2
+	}
+	if quality == "" {
+		quality = ImageQualityStandard
+	}
+	if size == "" {
+		size = ImageSize1024x1024
+	}
+
+	pricing, err := GetPricing(model)
+	if err != nil {
+		return 0, err
+	}
+	if pricing.PerImage == nil {
+		return 0, fmt.Errorf("model %s is not an image model", model)
+	}
+	price, ok := pricing.PerImage[quality][size]
+	if !ok {
+		return 0, fmt.Errorf("model %s does not support the quality %s with the size %s", model, quality, size)
+	}
+
+	return price, nil
+}
+
+type ImageRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string `json:"-"`
+	MaxRetries int    `json:"-"`
+
+	// Defaults to dall-e-2
+	Model  Model  `json:"model,omitempty"`
+	Prompt string `json:"prompt"`
+	// Number of images, dall-e-3 only supports 1
+	N              int                 `json:"n,omitempty"`
+	Size           ImageSize           `json:"size,omitempty"`
+	Quality        ImageQuality        `json:"quality,omitempty"`
+	Style          ImageStyle          `json:"style,omitempty"`
+	ResponseFormat ImageResponseFormat `json:"response_format,omitempty"`
+	User           string              `json:"user,omitempty"`
+}
+
+// ImageEditRequest creates an edited or extended image given an original image and a prompt. Only dall-e-2 supports it.
+type ImageEditRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string
+	MaxRetries int
+
+	// Image to edit, a square PNG file of less than 4MB. If Mask is not provided, the image must have transparency,
+	// which will be used as the mask.
+	Image io.Reader
+	// Optional, a PNG file with the same dimensions as Image whose fully transparent areas indicate where Image should be edited
+	Mask io.Reader
+
+	Model          Model
+	Prompt         string
+	N              int
+	Size           ImageSize
+	ResponseFormat ImageResponseFormat
+	User           string
+}
+
+// ImageVariationRequest creates variations of an image. Only dall-e-2 supports it.
+type ImageVariationRequest struct {
+	// Only required if no default api key was initialized
+	APIKEY     string
+	MaxRetries int
+
+	// Image to use as the basis for the variations, a square PNG file of less than 4MB
+	Image io.Reader
+
+	Model          Model
+	N              int
+	Size           ImageSize
+	ResponseFormat ImageResponseFormat
+	User           string
+}
+
+type ImageData struct {
+	URL     string `json:"url,omitempty"`
+	B64JSON string `json:"b64_json,omitempty"`
+	// Only set by dall-e-3, the prompt it used after rewriting ours
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+type ImageResponse struct {
+	Created int64       `json:"created"`
+	Data    []ImageData `json:"data"`
+
+	//
+
+	Price float64 `json:"price,omitempty"`
+
+	pricePerImage float64
+}
+
+func CreateImage(ctx context.Context, req *ImageRequest) (*ImageResponse, error) {
+	return DefaultClient.CreateImage(ctx, req)
+}
+
+func (c *Client) CreateImage(ctx context.Context, req *ImageRequest) (*ImageResponse, error) {
+	price, err := GetImagePrice(req.Model, req.Quality, req.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ImageResponse{pricePerImage: price}
+
+	err = c.request(ctx, "POST", urlSuffix_imagegenerations, req, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Price = resp.pricePerImage * float64(len(resp.Data))
+
+	return resp, nil
+}
+
+func CreateImageEdit(ctx context.Context, req *ImageEditRequest) (*ImageResponse, error) {
+	return DefaultClient.CreateImageEdit(ctx, req)
+}
+
+func (c *Client) CreateImageEdit(ctx context.Context, req *ImageEditRequest) (*ImageResponse, error) {
+	if req.Image == nil {
+		return nil, fmt.Errorf("we need an image to edit")
+	}
+
+	price, err := GetImagePrice(req.Model, "", req.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	form := &requests.MultipartForm{}
+	err = form.AddFile("image", "image.png", "image/png", req.Image)
+	if err != nil {
+		return nil, err
+	}
+	if req.Mask != nil {
+		err = form.AddFile("mask", "mask.png", "image/png", req.Mask)
+		if err != nil {
+			return nil, err
+		}
+	}
+	form.AddField("prompt", req.Prompt)
+	addImageFormFields(form, req.Model, req.N, req.Size, req.ResponseFormat, req.User)
+
+	resp := &ImageResponse{pricePerImage: price}
+
+	err = c.request(ctx, "POST", urlSuffix_imageedits, form, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Price = resp.pricePerImage * float64(len(resp.Data))
+
+	return resp, nil
+}
+
+func CreateImageVariation(ctx context.Context, req *ImageVariationRequest) (*ImageResponse, error) {
+	return DefaultClient.CreateImageVariation(ctx, req)
+}
+
+func (c *Client) CreateImageVariation(ctx context.Context, req *ImageVariationRequest) (*ImageResponse, error) {
+	if req.Image == nil {
+		return nil, fmt.Errorf("we need an image to create variations of")
+	}
+
+	price, err := GetImagePrice(req.Model, "", req.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	form := &requests.MultipartForm{}
+	err = form.AddFile("image", "image.png", "image/png", req.Image)
+	if err != nil {
+		return nil, err
+	}
+	addImageFormFields(form, req.Model, req.N, req.Size, req.ResponseFormat, req.User)
+
+	resp := &ImageResponse{pricePerImage: price}
+
+	err = c.request(ctx, "POST", urlSuffix_imagevariations, form, resp, req.APIKEY, req.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Price = resp.pricePerImage * float64(len(resp.Data))
+
+	return resp, nil
+}
+
+func addImageFormFields(form *requests.MultipartForm, model Model, n int, size ImageSize, responseFormat ImageResponseFormat, user string) {
+	form.AddField("model", string(model))
+	if n > 0 {
+		form.AddField("n", strconv.Itoa(n))
+	}
+	form.AddField("size", string(size))
+	form.AddField("response_format", string(responseFormat))
+	form.AddField("user", user)
+}
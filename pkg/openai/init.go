@@ -1,18 +1,18 @@
 package openai
 
 import (
+	"context"
 	"fmt"
 )
 
-var defaultAPIKey string
-
+// Init checks the default api key with a test call and sets it on DefaultClient
 func Init(defaultApiKey string) error {
-	_, err := ListModels(&ListModelsRequest{APIKEY: defaultApiKey})
+	_, err := ListModels(context.Background(), &ListModelsRequest{APIKEY: defaultApiKey})
 	if err != nil {
 		return fmt.Errorf("openai test call failed: %s", err)
 	}
 
-	defaultAPIKey = defaultApiKey
+	DefaultClient.apiKey.Store(defaultApiKey)
 
 	return nil
 }
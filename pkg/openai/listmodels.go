@@ -1,5 +1,7 @@
 package openai
 
+import "context"
+
 const urlSuffix_listmodels = "v1/models"
 
 type ListModelsRequest struct {
@@ -36,10 +38,14 @@ type ListModelsResponse struct {
 	Object string             `json:"object"`
 }
 
-func ListModels(req *ListModelsRequest) (*ListModelsResponse, error) {
+func ListModels(ctx context.Context, req *ListModelsRequest) (*ListModelsResponse, error) {
+	return DefaultClient.ListModels(ctx, req)
+}
+
+func (c *Client) ListModels(ctx context.Context, req *ListModelsRequest) (*ListModelsResponse, error) {
 	resp := &ListModelsResponse{}
 
-	err := request("GET", urlSuffix_listmodels, nil, resp, req.APIKEY, req.MaxRetries)
+	err := c.request(ctx, "GET", urlSuffix_listmodels, nil, resp, req.APIKEY, req.MaxRetries)
 	if err != nil {
 		return nil, err
 	}
@@ -1,5 +1,18 @@
 package openai
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/openai/tokenizer"
+)
+
 type Model string
 
 const (
@@ -9,6 +22,12 @@ const (
 
 	Embedding_V3_1536 Model = "text-embedding-3-small"
 
+	GPT4o_128k Model = "gpt-4o"
+
+	GPT4o_Mini_128k Model = "gpt-4o-mini"
+
+	GPT4_Turbo_128k Model = "gpt-4-turbo"
+
 	GPT4_128k_Preview Model = "gpt-4-0125-preview"
 
 	GPT4_128k_Vision_Preview Model = "gpt-4-vision-preview"
@@ -29,6 +48,10 @@ const (
 
 	GPT3_5_turbo_16k_0613 Model = "gpt-3.5-turbo-16k-0613"
 
+	GPT3_5_turbo_16k_1106 Model = "gpt-3.5-turbo-1106"
+
+	GPT3_5_turbo_16k_0125 Model = "gpt-3.5-turbo-0125"
+
 	GPT3_5_turbo_4k_0301 Model = "gpt-3.5-turbo-0301"
 
 	TextDavinci3_4k Model = "text-davinci-003"
@@ -42,6 +65,12 @@ const (
 	DallE3 Model = "dall-e-3"
 
 	DallE2 Model = "dall-e-2"
+
+	Whisper1 Model = "whisper-1"
+
+	TTS1 Model = "tts-1"
+
+	TTS1_HD Model = "tts-1-hd"
 )
 
 type ContextLength int
@@ -54,52 +83,471 @@ const (
 	Context128K ContextLength = 128000
 )
 
-func (m Model) GetContextLength() ContextLength {
-	switch m {
-	default:
-		panic("Model does not exist or the context length does not apply to it (example: DallE)")
-	case Text_Embedding_Ada_2_8k:
-		return Context8K
-	case GPT4_8k, GPT4_8k_0613:
-		return Context8K
-	case GPT4_32k, GPT4_32k_0613:
-		return Context32K
-	case GPT4_128k_Preview, GPT4_128k_Vision_Preview:
-		return Context128K
-	case GPT3_5_turbo_4k, GPT3_5_turbo_4k_0613, GPT3_5_turbo_4k_0301:
-		return Context4K
-	case GPT3_5_turbo_16k, GPT3_5_turbo_16k_0613:
-		return Context16K
-	case TextDavinci3_4k, TextDavinci2_4k, TextDavinci_1_Edit:
-		return Context4K
-	case CodeDavinci2_8k:
-		return Context8K
-	}
-}
-
-func (m Model) GetSimilarWithNextContextLength() (bool, Model) {
-	switch m {
-	default:
-		panic("Model does not exist")
-	case Text_Embedding_Ada_2_8k, Embedding_V3_1536, Embedding_V3_3072:
-		return false, ""
-	case GPT4_8k:
-		return true, GPT4_32k
-	case GPT4_8k_0613:
-		return true, GPT4_32k_0613
-	case GPT4_32k, GPT4_32k_0613:
-		return true, GPT4_128k_Preview
-	case GPT3_5_turbo_4k:
-		return true, GPT3_5_turbo_16k
-	case GPT3_5_turbo_4k_0301:
-		return false, ""
-	case GPT3_5_turbo_4k_0613:
-		return true, GPT3_5_turbo_16k_0613
-	case GPT3_5_turbo_16k, GPT3_5_turbo_16k_0613:
-		return false, ""
-	case TextDavinci3_4k, TextDavinci2_4k, TextDavinci_1_Edit:
-		return false, ""
-	case CodeDavinci2_8k:
-		return false, ""
+// ErrUnknownModel is returned for the models which are not registered, see RegisterModel
+var ErrUnknownModel = errors.New("unknown model")
+
+type Modality string
+
+const (
+	ModalityText      Modality = "text"
+	ModalityImage     Modality = "image"
+	ModalityAudio     Modality = "audio"
+	ModalityEmbedding Modality = "embedding"
+)
+
+// ModelInfo describes a model for the sdk to count its tokens, fit requests in its context length and price them
+type ModelInfo struct {
+	Model Model `json:"model"`
+	// Family groups the versions of a model, for example gpt-4 or gpt-3.5-turbo
+	Family string `json:"family"`
+	// Encoding is the name of the tokenizer encoding, for example cl100k_base. It is empty for the models whose
+	// input is not tokenized.
+	Encoding string `json:"encoding,omitempty"`
+	// TokensPerMessage is the overhead of each message of a chat completion, it defaults to 3
+	TokensPerMessage int `json:"tokens_per_message,omitempty"`
+	// ContextLength is 0 for the models it does not apply to, such as image and audio models
+	ContextLength ContextLength `json:"context_length,omitempty"`
+	// MaxOutputTokens is the maximum length of a completion, 0 if it is only limited by the context length
+	MaxOutputTokens  int        `json:"max_output_tokens,omitempty"`
+	InputModalities  []Modality `json:"input_modalities,omitempty"`
+	OutputModalities []Modality `json:"output_modalities,omitempty"`
+	SupportsTools    bool       `json:"supports_tools,omitempty"`
+	// ImageBaseTokens and ImageTileTokens count the input images of the vision models, see CountImageTokens.
+	// They default to 85 and 170.
+	ImageBaseTokens int `json:"image_base_tokens,omitempty"`
+	ImageTileTokens int `json:"image_tile_tokens,omitempty"`
+
+	Pricing ModelPricing `json:"pricing"`
+
+	// NextContextLength is a similar model with a larger context length, which requests are upgraded to
+	// with MaxTokens -2 and -3
+	NextContextLength Model `json:"next_context_length,omitempty"`
+}
+
+// ModelPricing is in dollars, a zero price means the model is not billed this way. The prices of a model may change
+// over time, see PriceEntry.
+type ModelPricing struct {
+	// Per 1000 tokens
+	Prompt     float64 `json:"prompt,omitempty"`
+	Completion float64 `json:"completion,omitempty"`
+	// CachedPrompt is the price per 1000 prompt tokens read from the prompt cache, they are billed at the
+	// Prompt price if it is 0
+	CachedPrompt float64 `json:"cached_prompt,omitempty"`
+
+	// Per 1000 tokens, for the models fine-tuned from this one
+	FineTunedPrompt     float64 `json:"fine_tuned_prompt,omitempty"`
+	FineTunedCompletion float64 `json:"fine_tuned_completion,omitempty"`
+	// Training is the price of fine-tuning this model per 1000 tokens of the training file and epoch, it is 0 if
+	// the model cannot be fine-tuned
+	Training float64 `json:"training,omitempty"`
+
+	// PerSecond is the price of audio inputs per second
+	PerSecond         float64 `json:"per_second,omitempty"`
+	Per1000Characters float64 `json:"per_1000_characters,omitempty"`
+	// PerImage is the price of one generated image by quality and size
+	PerImage map[ImageQuality]map[ImageSize]float64 `json:"per_image,omitempty"`
+
+	// BatchDiscount is the share of the regular price billed for the requests of a batch, it defaults to the
+	// BatchDiscount constant
+	BatchDiscount float64 `json:"batch_discount,omitempty"`
+}
+
+var registry = struct {
+	mu     sync.RWMutex
+	models map[Model]*ModelInfo
+	// prices holds the dated prices of each model sorted by EffectiveFrom, the Pricing of the ModelInfo applies
+	// before the first of them
+	prices map[Model][]PriceEntry
+}{
+	models: map[Model]*ModelInfo{},
+	prices: map[Model][]PriceEntry{},
+}
+
+func init() {
+	for _, info := range defaultModels {
+		err := RegisterModel(info)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err := AddPrices(defaultPrices...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// RegisterModel adds a model to the registry, or replaces its definition if it is already registered.
+// It lets new models be used before they are added to the sdk. info.Pricing applies before the dated prices of the
+// model, if any, see AddPrices.
+func RegisterModel(info ModelInfo) error {
+	err := validateModelInfo(&info)
+	if err != nil {
+		return err
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.models[info.Model] = cloneModelInfo(&info)
+
+	return nil
+}
+
+// LoadModels registers the models of a JSON array of ModelInfo. Either all of them are registered or none.
+func LoadModels(r io.Reader) error {
+	var infos []ModelInfo
+	err := json.NewDecoder(r).Decode(&infos)
+	if err != nil {
+		return fmt.Errorf("could not decode the models: %v", err)
+	}
+
+	for i := range infos {
+		err = validateModelInfo(&infos[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for i := range infos {
+		registry.models[infos[i].Model] = cloneModelInfo(&infos[i])
+	}
+
+	return nil
+}
+
+func validateModelInfo(info *ModelInfo) error {
+	if info.Model == "" {
+		return fmt.Errorf("the model of a model info cannot be empty")
+	}
+	if info.ContextLength < 0 || info.MaxOutputTokens < 0 || info.TokensPerMessage < 0 || info.ImageBaseTokens < 0 || info.ImageTileTokens < 0 {
+		return fmt.Errorf("model %s: the context length, max output tokens and token counts cannot be negative", info.Model)
 	}
+	if info.Encoding != "" && !tokenizer.Exists(info.Encoding) {
+		return fmt.Errorf("model %s: unknown encoding %s", info.Model, info.Encoding)
+	}
+	if info.NextContextLength == info.Model {
+		return fmt.Errorf("model %s cannot be its own next context length", info.Model)
+	}
+	return nil
+}
+
+// RegisteredModels returns the registered models sorted by name, with their current prices
+func RegisteredModels() []ModelInfo {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	now := time.Now()
+	infos := make([]ModelInfo, 0, len(registry.models))
+	for _, info := range registry.models {
+		c := cloneModelInfo(info)
+		c.Pricing = pricingAt(info, now)
+		infos = append(infos, *c)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Model < infos[j].Model })
+
+	return infos
+}
+
+// LookupModel returns the definition of m with its current prices. A fine-tuned model which is not registered itself
+// gets the definition of its base model, with the fine-tuned prices and without a next context length since it
+// cannot be swapped.
+func LookupModel(m Model) (ModelInfo, error) {
+	return lookupModelAt(m, time.Now())
+}
+
+// lookupModelAt returns the definition of m with its prices at the time at
+func lookupModelAt(m Model, at time.Time) (ModelInfo, error) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	if info, ok := registry.models[m]; ok {
+		c := cloneModelInfo(info)
+		c.Pricing = pricingAt(info, at)
+		return *c, nil
+	}
+
+	if m.IsFineTuned() {
+		if base, ok := registry.models[m.BaseModel()]; ok {
+			info := cloneModelInfo(base)
+			info.Model = m
+			info.NextContextLength = ""
+			pricing := pricingAt(base, at)
+			info.Pricing = ModelPricing{
+				Prompt:        pricing.FineTunedPrompt,
+				Completion:    pricing.FineTunedCompletion,
+				BatchDiscount: pricing.BatchDiscount,
+			}
+			return *info, nil
+		}
+	}
+
+	return ModelInfo{}, fmt.Errorf("%w: %s, it can be registered with RegisterModel", ErrUnknownModel, m)
+}
+
+// pricingAt returns the last prices of info effective at the time at, the registry must be locked
+func pricingAt(info *ModelInfo, at time.Time) ModelPricing {
+	pricing := info.Pricing
+	for _, entry := range registry.prices[info.Model] {
+		if entry.EffectiveFrom.After(at) {
+			break
+		}
+		pricing = entry.Pricing
+	}
+	return pricing.clone()
+}
+
+func cloneModelInfo(info *ModelInfo) *ModelInfo {
+	c := *info
+	c.InputModalities = append([]Modality(nil), info.InputModalities...)
+	c.OutputModalities = append([]Modality(nil), info.OutputModalities...)
+	c.Pricing = info.Pricing.clone()
+	return &c
+}
+
+func (p ModelPricing) clone() ModelPricing {
+	if p.PerImage == nil {
+		return p
+	}
+	perImage := make(map[ImageQuality]map[ImageSize]float64, len(p.PerImage))
+	for quality, sizes := range p.PerImage {
+		perImage[quality] = make(map[ImageSize]float64, len(sizes))
+		for size, price := range sizes {
+			perImage[quality][size] = price
+		}
+	}
+	p.PerImage = perImage
+	return p
+}
+
+// IsFineTuned tells whether m is a fine-tuned model, such as ft:gpt-3.5-turbo-0613:my-org:custom-suffix:id
+func (m Model) IsFineTuned() bool {
+	return strings.HasPrefix(string(m), "ft:")
+}
+
+// BaseModel returns the model a fine-tuned model was trained from, or m itself if it is not fine-tuned
+func (m Model) BaseModel() Model {
+	if !m.IsFineTuned() {
+		return m
+	}
+	parts := strings.SplitN(string(m), ":", 3)
+	if len(parts) < 2 {
+		return m
+	}
+	return Model(parts[1])
+}
+
+// GetContextLength returns 0 for the models it does not apply to, such as image and audio models
+func (m Model) GetContextLength() (ContextLength, error) {
+	info, err := LookupModel(m)
+	if err != nil {
+		return 0, err
+	}
+	return info.ContextLength, nil
+}
+
+func (m Model) GetSimilarWithNextContextLength() (bool, Model, error) {
+	info, err := LookupModel(m)
+	if err != nil {
+		return false, "", err
+	}
+	return info.NextContextLength != "", info.NextContextLength, nil
+}
+
+var (
+	chatModalities      = []Modality{ModalityText}
+	visionModalities    = []Modality{ModalityText, ModalityImage}
+	embeddingModalities = []Modality{ModalityEmbedding}
+)
+
+var defaultModels = []ModelInfo{
+	{
+		Model: Text_Embedding_Ada_2_8k, Family: "text-embedding-ada", Encoding: tokenizer.Cl100kBase, ContextLength: Context8K,
+		InputModalities: chatModalities, OutputModalities: embeddingModalities,
+		Pricing: ModelPricing{Prompt: 0.0001, Completion: 0.0001},
+	},
+	{
+		Model: Embedding_V3_1536, Family: "text-embedding-3", Encoding: tokenizer.Cl100kBase, ContextLength: 8191,
+		InputModalities: chatModalities, OutputModalities: embeddingModalities,
+		Pricing: ModelPricing{Prompt: 0.00002, Completion: 0.00002},
+	},
+	{
+		Model: Embedding_V3_3072, Family: "text-embedding-3", Encoding: tokenizer.Cl100kBase, ContextLength: 8191,
+		InputModalities: chatModalities, OutputModalities: embeddingModalities,
+		Pricing: ModelPricing{Prompt: 0.00013, Completion: 0.00013},
+	},
+
+	{
+		Model: GPT4o_128k, Family: "gpt-4o", Encoding: tokenizer.O200kBase, TokensPerMessage: 3,
+		ContextLength: Context128K, MaxOutputTokens: 4096, SupportsTools: true,
+		InputModalities: visionModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.005, Completion: 0.015},
+	},
+	{
+		Model: GPT4o_Mini_128k, Family: "gpt-4o-mini", Encoding: tokenizer.O200kBase, TokensPerMessage: 3,
+		ContextLength: Context128K, MaxOutputTokens: 16384, SupportsTools: true,
+		InputModalities: visionModalities, OutputModalities: chatModalities,
+		ImageBaseTokens: 2833, ImageTileTokens: 5667,
+		Pricing: ModelPricing{Prompt: 0.00015, Completion: 0.0006, FineTunedPrompt: 0.0003, FineTunedCompletion: 0.0012, Training: 0.003},
+	},
+	{
+		Model: GPT4_Turbo_128k, Family: "gpt-4-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 3,
+		ContextLength: Context128K, MaxOutputTokens: 4096, SupportsTools: true,
+		InputModalities: visionModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.01, Completion: 0.03},
+	},
+	{
+		Model: GPT4_128k_Preview, Family: "gpt-4-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 3,
+		ContextLength: Context128K, MaxOutputTokens: 4096, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.01, Completion: 0.03},
+	},
+	{
+		Model: GPT4_128k_Vision_Preview, Family: "gpt-4-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 3,
+		ContextLength: Context128K, MaxOutputTokens: 4096,
+		InputModalities: visionModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.01, Completion: 0.03},
+	},
+	{
+		Model: GPT4_8k, Family: "gpt-4", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 3,
+		ContextLength: Context8K, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing:           ModelPricing{Prompt: 0.03, Completion: 0.06},
+		NextContextLength: GPT4_32k,
+	},
+	{
+		Model: GPT4_8k_0613, Family: "gpt-4", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 3,
+		ContextLength: Context8K, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing:           ModelPricing{Prompt: 0.03, Completion: 0.06},
+		NextContextLength: GPT4_32k_0613,
+	},
+	{
+		Model: GPT4_32k, Family: "gpt-4", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 3,
+		ContextLength: Context32K, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing:           ModelPricing{Prompt: 0.06, Completion: 0.12},
+		NextContextLength: GPT4_128k_Preview,
+	},
+	{
+		Model: GPT4_32k_0613, Family: "gpt-4", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 3,
+		ContextLength: Context32K, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing:           ModelPricing{Prompt: 0.06, Completion: 0.12},
+		NextContextLength: GPT4_128k_Preview,
+	},
+
+	// every message follows <im_start>{role/name}\n{content}<im_end>\n
+	// See https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
+	{
+		Model: GPT3_5_turbo_4k, Family: "gpt-3.5-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 4,
+		ContextLength: Context4K, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing:           ModelPricing{Prompt: 0.0015, Completion: 0.0020, FineTunedPrompt: 0.003, FineTunedCompletion: 0.006, Training: 0.008},
+		NextContextLength: GPT3_5_turbo_16k,
+	},
+	{
+		Model: GPT3_5_turbo_4k_0301, Family: "gpt-3.5-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 4,
+		ContextLength:   Context4K,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.0015, Completion: 0.0020},
+	},
+	{
+		Model: GPT3_5_turbo_4k_0613, Family: "gpt-3.5-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 4,
+		ContextLength: Context4K, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing:           ModelPricing{Prompt: 0.0015, Completion: 0.0020, FineTunedPrompt: 0.003, FineTunedCompletion: 0.006, Training: 0.008},
+		NextContextLength: GPT3_5_turbo_16k_0613,
+	},
+	{
+		Model: GPT3_5_turbo_16k, Family: "gpt-3.5-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 4,
+		ContextLength: Context16K, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.0030, Completion: 0.0040},
+	},
+	{
+		Model: GPT3_5_turbo_16k_0613, Family: "gpt-3.5-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 4,
+		ContextLength: Context16K, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.0030, Completion: 0.0040},
+	},
+	{
+		Model: GPT3_5_turbo_16k_1106, Family: "gpt-3.5-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 4,
+		ContextLength: Context16K, MaxOutputTokens: 4096, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.0010, Completion: 0.0020, FineTunedPrompt: 0.003, FineTunedCompletion: 0.006, Training: 0.008},
+	},
+	{
+		Model: GPT3_5_turbo_16k_0125, Family: "gpt-3.5-turbo", Encoding: tokenizer.Cl100kBase, TokensPerMessage: 4,
+		ContextLength: Context16K, MaxOutputTokens: 4096, SupportsTools: true,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.0005, Completion: 0.0015, FineTunedPrompt: 0.003, FineTunedCompletion: 0.006, Training: 0.008},
+	},
+
+	{
+		Model: TextDavinci3_4k, Family: "davinci", Encoding: tokenizer.P50kBase, ContextLength: Context4K,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.02, Completion: 0.02},
+	},
+	{
+		Model: TextDavinci2_4k, Family: "davinci", Encoding: tokenizer.P50kBase, ContextLength: Context4K,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.02, Completion: 0.02},
+	},
+	{
+		Model: TextDavinci_1_Edit, Family: "davinci", Encoding: tokenizer.P50kBase, ContextLength: Context4K,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.02, Completion: 0.02},
+	},
+	{
+		Model: CodeDavinci2_8k, Family: "codex", Encoding: tokenizer.P50kBase, ContextLength: Context8K,
+		InputModalities: chatModalities, OutputModalities: chatModalities,
+		Pricing: ModelPricing{Prompt: 0.02, Completion: 0.02},
+	},
+
+	{
+		Model: DallE3, Family: "dall-e",
+		InputModalities: chatModalities, OutputModalities: []Modality{ModalityImage},
+		Pricing: ModelPricing{PerImage: map[ImageQuality]map[ImageSize]float64{
+			ImageQualityStandard: {
+				ImageSize1024x1024: 0.040,
+				ImageSize1792x1024: 0.080,
+				ImageSize1024x1792: 0.080,
+			},
+			ImageQualityHD: {
+				ImageSize1024x1024: 0.080,
+				ImageSize1792x1024: 0.120,
+				ImageSize1024x1792: 0.120,
+			},
+		}},
+	},
+	{
+		Model: DallE2, Family: "dall-e",
+		InputModalities: visionModalities, OutputModalities: []Modality{ModalityImage},
+		Pricing: ModelPricing{PerImage: map[ImageQuality]map[ImageSize]float64{
+			ImageQualityStandard: {
+				ImageSize1024x1024: 0.020,
+				ImageSize512x512:   0.018,
+				ImageSize256x256:   0.016,
+			},
+		}},
+	},
+
+	{
+		Model: Whisper1, Family: "whisper",
+		InputModalities: []Modality{ModalityAudio}, OutputModalities: chatModalities,
+		Pricing: ModelPricing{PerSecond: 0.0001},
+	},
+	{
+		Model: TTS1, Family: "tts",
+		InputModalities: chatModalities, OutputModalities: []Modality{ModalityAudio},
+		Pricing: ModelPricing{Per1000Characters: 0.015},
+	},
+	{
+		Model: TTS1_HD, Family: "tts",
+		InputModalities: chatModalities, OutputModalities: []Modality{ModalityAudio},
+		Pricing: ModelPricing{Per1000Characters: 0.030},
+	},
 }
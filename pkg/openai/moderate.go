@@ -1,7 +1,7 @@
 package openai
 
 import (
-	"fmt"
+	"context"
 )
 
 var urlSuffix_moderate = "v1/moderations"
@@ -26,12 +26,14 @@ type Result struct {
 	Flagged        bool               `json:"flagged"`
 }
 
-func Moderate(req *ModerateRequest) (*ModerateResponse, error) {
-	defer fmt.Println("")
+func Moderate(ctx context.Context, req *ModerateRequest) (*ModerateResponse, error) {
+	return DefaultClient.Moderate(ctx, req)
+}
 
+func (c *Client) Moderate(ctx context.Context, req *ModerateRequest) (*ModerateResponse, error) {
 	resp := &ModerateResponse{}
 
-	err := request("POST", urlSuffix_moderate, req, resp, req.APIKEY, req.MaxRetries)
+	err := c.request(ctx, "POST", urlSuffix_moderate, req, resp, req.APIKEY, req.MaxRetries)
 	if err != nil {
 		return nil, err
 	}
@@ -1,45 +1,308 @@
 package openai
 
-import "fmt"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
-// order in array: prompt, completion
-var PricingPer1000TokensPerModel = map[Model][]float64{
-	GPT4_128k_Preview:        {0.01, 0.03},
-	GPT4_128k_Vision_Preview: {0.01, 0.03},
-	GPT4_8k:                  {0.03, 0.06},
-	GPT4_8k_0613:             {0.03, 0.06},
-	GPT4_32k:                 {0.06, 0.12},
-	GPT4_32k_0613:            {0.06, 0.12},
+	"gopkg.in/yaml.v3"
+)
 
-	GPT3_5_turbo_4k:      {0.0015, 0.0020},
-	GPT3_5_turbo_4k_0301: {0.0015, 0.0020},
-	GPT3_5_turbo_4k_0613: {0.0015, 0.0020},
+// PriceEntry sets the prices of a model from EffectiveFrom until the next entry of the model, so that past usage
+// can be priced at the prices of the time, see GetPricingAt
+type PriceEntry struct {
+	Model Model `json:"model"`
+	// EffectiveFrom is encoded as a date, such as 2024-10-01, or as an RFC 3339 time
+	EffectiveFrom time.Time    `json:"effective_from"`
+	Pricing       ModelPricing `json:"pricing"`
+}
+
+func (e *PriceEntry) UnmarshalJSON(data []byte) error {
+	type priceEntry PriceEntry
+	aux := &struct {
+		*priceEntry
+		EffectiveFrom string `json:"effective_from"`
+	}{
+		priceEntry: (*priceEntry)(e),
+	}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+
+	e.EffectiveFrom = time.Time{}
+	if aux.EffectiveFrom != "" {
+		e.EffectiveFrom, err = time.Parse(time.DateOnly, aux.EffectiveFrom)
+		if err != nil {
+			e.EffectiveFrom, err = time.Parse(time.RFC3339, aux.EffectiveFrom)
+			if err != nil {
+				return fmt.Errorf("the effective date must be a date or an RFC 3339 time, we got %s", aux.EffectiveFrom)
+			}
+		}
+	}
+
+	return nil
+}
+
+// defaultPrices are the price changes of the default models, whose Pricing is the first price they had
+var defaultPrices = []PriceEntry{
+	{
+		// gpt-3.5-turbo points to gpt-3.5-turbo-0125
+		Model: GPT3_5_turbo_4k, EffectiveFrom: time.Date(2024, 2, 16, 0, 0, 0, 0, time.UTC),
+		Pricing: ModelPricing{Prompt: 0.0005, Completion: 0.0015, FineTunedPrompt: 0.003, FineTunedCompletion: 0.006, Training: 0.008},
+	},
+	{
+		// gpt-4o points to gpt-4o-2024-08-06
+		Model: GPT4o_128k, EffectiveFrom: time.Date(2024, 10, 2, 0, 0, 0, 0, time.UTC),
+		Pricing: ModelPricing{
+			Prompt: 0.0025, CachedPrompt: 0.00125, Completion: 0.01,
+			FineTunedPrompt: 0.00375, FineTunedCompletion: 0.015, Training: 0.025,
+		},
+	},
+	{
+		// Prompt caching
+		Model: GPT4o_Mini_128k, EffectiveFrom: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+		Pricing: ModelPricing{
+			Prompt: 0.00015, CachedPrompt: 0.000075, Completion: 0.0006,
+			FineTunedPrompt: 0.0003, FineTunedCompletion: 0.0012, Training: 0.003,
+		},
+	},
+}
+
+// AddPrices adds dated prices to registered models, or replaces those with the same model and effective date.
+// Either all of them are added or none.
+func AddPrices(entries ...PriceEntry) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, entry := range entries {
+		if _, ok := registry.models[entry.Model]; !ok {
+			return fmt.Errorf("%w: %s, it must be registered before its prices", ErrUnknownModel, entry.Model)
+		}
+		if entry.EffectiveFrom.IsZero() {
+			return fmt.Errorf("we need the effective date of the prices of model %s, the prices which always applied are set with RegisterModel", entry.Model)
+		}
+	}
+
+	for _, entry := range entries {
+		entry.Pricing = entry.Pricing.clone()
+
+		prices := registry.prices[entry.Model]
+		i := sort.Search(len(prices), func(i int) bool { return !prices[i].EffectiveFrom.Before(entry.EffectiveFrom) })
+		if i < len(prices) && prices[i].EffectiveFrom.Equal(entry.EffectiveFrom) {
+			prices[i] = entry
+			continue
+		}
+		prices = append(prices, PriceEntry{})
+		copy(prices[i+1:], prices[i:])
+		prices[i] = entry
+		registry.prices[entry.Model] = prices
+	}
 
-	GPT3_5_turbo_16k:      {0.0030, 0.0040},
-	GPT3_5_turbo_16k_0613: {0.0030, 0.0040},
+	return nil
+}
+
+// LoadPrices adds the dated prices of a JSON array of PriceEntry, see AddPrices
+func LoadPrices(r io.Reader) error {
+	var entries []PriceEntry
+	err := json.NewDecoder(r).Decode(&entries)
+	if err != nil {
+		return fmt.Errorf("could not decode the prices: %v", err)
+	}
+
+	return AddPrices(entries...)
+}
+
+// LoadPricesFile adds the dated prices of a JSON or YAML file, depending on its extension, with the same fields as
+// LoadPrices
+func LoadPricesFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yaml", ".yml":
+		var v interface{}
+		err = yaml.Unmarshal(data, &v)
+		if err != nil {
+			return fmt.Errorf("could not decode the prices: %v", err)
+		}
+		data, err = json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("could not decode the prices: %v", err)
+		}
+	default:
+		return fmt.Errorf("the prices file must have a .json, .yaml or .yml extension, we got %s", path)
+	}
+
+	return LoadPrices(bytes.NewReader(data))
+}
+
+// PriceHistory returns the dated prices of m sorted by effective date, without the prices set with RegisterModel
+func PriceHistory(m Model) []PriceEntry {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
 
-	TextDavinci3_4k:    {0.02, 0.02},
-	TextDavinci2_4k:    {0.02, 0.02},
-	TextDavinci_1_Edit: {0.02, 0.02},
-	CodeDavinci2_8k:    {0.02, 0.02},
+	history := make([]PriceEntry, len(registry.prices[m]))
+	for i, entry := range registry.prices[m] {
+		entry.Pricing = entry.Pricing.clone()
+		history[i] = entry
+	}
+	return history
+}
+
+// GetPricing returns the current prices of m, see ModelInfo. A fine-tuned model is billed the fine-tuned prices of
+// its base model.
+func GetPricing(m Model) (ModelPricing, error) {
+	return GetPricingAt(m, time.Now())
+}
 
-	Embedding_V3_1536:       {0.00002, 0.00002},
-	Embedding_V3_3072:       {0.00013, 0.00013},
-	Text_Embedding_Ada_2_8k: {0.0001, 0.0001},
+// GetPricingAt returns the prices of m at the time at, for example to recompute a past invoice
+func GetPricingAt(m Model, at time.Time) (ModelPricing, error) {
+	info, err := lookupModelAt(m, at)
+	if err != nil {
+		return ModelPricing{}, err
+	}
+	return info.Pricing, nil
 }
 
 // Usage Represents the total token usage per request to OpenAI.
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                 `json:"prompt_tokens"`
+	CompletionTokens    int                 `json:"completion_tokens"`
+	TotalTokens         int                 `json:"total_tokens"`
+	PromptTokensDetails PromptTokensDetails `json:"prompt_tokens_details"`
+}
+
+type PromptTokensDetails struct {
+	// CachedTokens were read from the prompt cache, they are part of the PromptTokens
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// ComputePrice returns the price of the usage at the current prices of m
+func (u *Usage) ComputePrice(m Model) (float64, error) {
+	return u.ComputePriceAt(m, time.Now())
+}
+
+// ComputePriceAt returns the price of the usage at the prices of m at the time at
+func (u *Usage) ComputePriceAt(m Model, at time.Time) (float64, error) {
+	pricing, err := GetPricingAt(m, at)
+	if err != nil {
+		return 0, err
+	}
+
+	return u.price(pricing), nil
+}
+
+func (u *Usage) price(pricing ModelPricing) float64 {
+	cachedTokens := u.PromptTokensDetails.CachedTokens
+	if cachedTokens > u.PromptTokens {
+		cachedTokens = u.PromptTokens
+	}
+	cachedPrice := pricing.CachedPrompt
+	if cachedPrice == 0 {
+		cachedPrice = pricing.Prompt
+	}
+
+	return (float64(u.PromptTokens-cachedTokens)/1000)*pricing.Prompt +
+		(float64(cachedTokens)/1000)*cachedPrice +
+		(float64(u.CompletionTokens)/1000)*pricing.Completion
+}
+
+// batchPrice returns the price of the usage of a request of a batch created at the time at
+func (u *Usage) batchPrice(m Model, at time.Time) (float64, error) {
+	pricing, err := GetPricingAt(m, at)
+	if err != nil {
+		return 0, err
+	}
+
+	discount := pricing.BatchDiscount
+	if discount == 0 {
+		discount = BatchDiscount
+	}
+	return u.price(pricing) * discount, nil
+}
+
+// estimateCost estimates the price of a request before it is sent, for the budgets of usage trackers. Completions
+// are estimated with MaxTokens output tokens, or none if it is not set, and 0 is returned when we cannot tell.
+func estimateCost(body any) float64 {
+	switch t := body.(type) {
+	case *ChatCompletionRequest:
+		pricing, err := GetPricing(t.Model)
+		if err != nil {
+			return 0
+		}
+		promptTokens, err := CountTokensCompletion(t)
+		if err != nil {
+			return 0
+		}
+		return (&Usage{PromptTokens: promptTokens, CompletionTokens: positive(t.MaxTokens)}).price(pricing)
+	case *CompletionRequest:
+		return estimateTextCost(t.Model, t.Prompt, t.MaxTokens*max1(t.N))
+	case *EmbeddingRequest:
+		return estimateTextCost(t.Model, t.Input, 0)
+	case *ImageRequest:
+		price, err := GetImagePrice(t.Model, t.Quality, t.Size)
+		if err != nil {
+			return 0
+		}
+		return price * float64(max1(t.N))
+	case *SpeechRequest:
+		pricing, err := GetPricing(t.Model)
+		if err != nil {
+			return 0
+		}
+		return float64(utf8.RuneCountInString(t.Input)) / 1000 * pricing.Per1000Characters
+	}
+	return 0
+}
+
+// estimateTextCost counts the tokens of input when it is a string or an array of strings
+func estimateTextCost(m Model, input any, completionTokens int) float64 {
+	info, err := LookupModel(m)
+	if err != nil || info.Encoding == "" {
+		return 0
+	}
+
+	var texts []string
+	switch t := input.(type) {
+	case string:
+		texts = []string{t}
+	case []string:
+		texts = t
+	}
+
+	u := &Usage{CompletionTokens: positive(completionTokens)}
+	for _, text := range texts {
+		n, err := countTokens(info.Encoding, text)
+		if err != nil {
+			return 0
+		}
+		u.PromptTokens += n
+	}
+
+	return u.price(info.Pricing)
 }
 
-func (u *Usage) ComputePrice(m Model) float64 {
-	if PricingPer1000TokensPerModel[m] == nil {
-		panic(fmt.Errorf("model %s is not yet registered in pricing", m))
+func positive(n int) int {
+	if n < 0 {
+		return 0
 	}
+	return n
+}
 
-	return (float64(u.PromptTokens)/1000)*PricingPer1000TokensPerModel[m][0] +
-		(float64(u.CompletionTokens)/1000)*PricingPer1000TokensPerModel[m][1]
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
 }
@@ -0,0 +1,300 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/openai/tokenizer"
+)
+
+// RateLimits of an api key for a model. A zero value means the limit is unknown, it is then learned from
+// the x-ratelimit-limit-* headers of the first response.
+type RateLimits struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+}
+
+// RateLimiter makes requests wait on the client side instead of sending them into a guaranteed 429.
+// It tracks requests and tokens per minute for each api key and model, estimates the tokens of each request
+// with the tokenizer before sending it, and corrects itself with the x-ratelimit-remaining-* headers OpenAI
+// returns with every response. A RateLimiter may be shared between clients, it is safe for concurrent use.
+type RateLimiter struct {
+	mu      sync.Mutex
+	limits  map[Model]RateLimits
+	buckets map[rateLimitKey]*rateBucket
+}
+
+type rateLimitKey struct {
+	apikey string
+	model  Model
+}
+
+// rateBucket refills continuously at the per minute rates. Available capacity goes negative when requests
+// are queued: each one reserves its share when it arrives and waits for the bucket to be back at zero.
+// Requests are not counted against a limit while it is unknown, the bucket starts from the remaining capacity
+// reported by the API once it is learned.
+type rateBucket struct {
+	limits RateLimits
+
+	requests float64
+	tokens   float64
+	last     time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		limits:  map[Model]RateLimits{},
+		buckets: map[rateLimitKey]*rateBucket{},
+	}
+}
+
+// SetLimits sets the limits of model for every api key, they are overridden by the limits the API reports
+func (l *RateLimiter) SetLimits(model Model, limits RateLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits[model] = limits
+	for k, b := range l.buckets {
+		if k.model == model {
+			b.refill(time.Now())
+			b.setLimits(limits)
+		}
+	}
+}
+
+// Wait blocks until a request of the given number of tokens can be sent for apikey and model.
+// It returns an error wrapping Err429 if the request can never fit in the limits, or if ctx expires before.
+func (l *RateLimiter) Wait(ctx context.Context, apikey string, model Model, tokens int) error {
+	l.mu.Lock()
+	b := l.bucket(apikey, model)
+
+	if b.limits.TokensPerMinute > 0 && tokens > b.limits.TokensPerMinute {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: the request needs about %d tokens but %s is limited to %d tokens per minute",
+			Err429, tokens, model, b.limits.TokensPerMinute)
+	}
+
+	now := time.Now()
+	b.refill(now)
+	var reservedRequests, reservedTokens float64
+	if b.limits.RequestsPerMinute > 0 {
+		reservedRequests = 1
+	}
+	if b.limits.TokensPerMinute > 0 {
+		reservedTokens = float64(tokens)
+	}
+	b.requests -= reservedRequests
+	b.tokens -= reservedTokens
+	wait := b.wait()
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	if exceedsDeadline(ctx, now.Add(wait)) {
+		l.refund(apikey, model, reservedRequests, reservedTokens)
+		return fmt.Errorf("%w: %s is rate limited for the next %v, after the context deadline", Err429, model, wait.Round(time.Millisecond))
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.refund(apikey, model, reservedRequests, reservedTokens)
+		return ctx.Err()
+	}
+}
+
+// Update records the rate limit headers of a response
+func (l *RateLimiter) Update(apikey string, model Model, statusCode int, header http.Header) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(apikey, model)
+	b.refill(time.Now())
+
+	limits := b.limits
+	if v, err := strconv.Atoi(header.Get("X-Ratelimit-Limit-Requests")); err == nil && v > 0 {
+		limits.RequestsPerMinute = v
+	}
+	if v, err := strconv.Atoi(header.Get("X-Ratelimit-Limit-Tokens")); err == nil && v > 0 {
+		limits.TokensPerMinute = v
+	}
+	learnedRequests := b.limits.RequestsPerMinute == 0 && limits.RequestsPerMinute > 0
+	learnedTokens := b.limits.TokensPerMinute == 0 && limits.TokensPerMinute > 0
+	b.setLimits(limits)
+
+	// The server knows better than our estimations, but it has not seen the requests still in flight yet
+	// so we never give back capacity because of it, unless the limit was unknown and nothing was counted
+	remainingRequests, errRequests := strconv.Atoi(header.Get("X-Ratelimit-Remaining-Requests"))
+	if errRequests == nil && (learnedRequests || float64(remainingRequests) < b.requests) {
+		b.requests = float64(remainingRequests)
+	}
+	remainingTokens, errTokens := strconv.Atoi(header.Get("X-Ratelimit-Remaining-Tokens"))
+	if errTokens == nil && (learnedTokens || float64(remainingTokens) < b.tokens) {
+		b.tokens = float64(remainingTokens)
+	}
+
+	if statusCode == http.StatusTooManyRequests && errRequests != nil && errTokens != nil {
+		if b.requests > 0 {
+			b.requests = 0
+		}
+		if b.tokens > 0 {
+			b.tokens = 0
+		}
+	}
+}
+
+func (l *RateLimiter) refund(apikey string, model Model, requests, tokens float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(apikey, model)
+	b.requests += requests
+	b.tokens += tokens
+	b.refill(time.Now())
+}
+
+func (l *RateLimiter) bucket(apikey string, model Model) *rateBucket {
+	k := rateLimitKey{apikey: apikey, model: model}
+
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &rateBucket{
+			limits: l.limits[model],
+			last:   time.Now(),
+		}
+		b.requests = float64(b.limits.RequestsPerMinute)
+		b.tokens = float64(b.limits.TokensPerMinute)
+		l.buckets[k] = b
+	}
+
+	return b
+}
+
+// setLimits starts the capacity of the limits which were unknown at their maximum, since nothing was counted
+// against them
+func (b *rateBucket) setLimits(limits RateLimits) {
+	if b.limits.RequestsPerMinute == 0 && limits.RequestsPerMinute > 0 {
+		b.requests = float64(limits.RequestsPerMinute)
+	}
+	if b.limits.TokensPerMinute == 0 && limits.TokensPerMinute > 0 {
+		b.tokens = float64(limits.TokensPerMinute)
+	}
+	b.limits = limits
+}
+
+func (b *rateBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Minutes()
+	b.last = now
+
+	if b.limits.RequestsPerMinute > 0 {
+		b.requests += elapsed * float64(b.limits.RequestsPerMinute)
+		if b.requests > float64(b.limits.RequestsPerMinute) {
+			b.requests = float64(b.limits.RequestsPerMinute)
+		}
+	}
+	if b.limits.TokensPerMinute > 0 {
+		b.tokens += elapsed * float64(b.limits.TokensPerMinute)
+		if b.tokens > float64(b.limits.TokensPerMinute) {
+			b.tokens = float64(b.limits.TokensPerMinute)
+		}
+	}
+}
+
+// wait returns how long it takes for the bucket to be back at zero, unknown limits never make us wait
+func (b *rateBucket) wait() time.Duration {
+	var wait time.Duration
+
+	if b.limits.RequestsPerMinute > 0 && b.requests < 0 {
+		wait = time.Duration(-b.requests / float64(b.limits.RequestsPerMinute) * float64(time.Minute))
+	}
+	if b.limits.TokensPerMinute > 0 && b.tokens < 0 {
+		if w := time.Duration(-b.tokens / float64(b.limits.TokensPerMinute) * float64(time.Minute)); w > wait {
+			wait = w
+		}
+	}
+
+	return wait
+}
+
+func exceedsDeadline(ctx context.Context, t time.Time) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && t.After(deadline)
+}
+
+// estimateTokens returns the model of a request body and the number of tokens it counts for against the
+// tokens per minute limit, which includes the completion tokens it may generate
+func estimateTokens(body any) (Model, int) {
+	switch t := body.(type) {
+	default:
+		return "", 0
+	case *ChatCompletionRequest:
+		tokens, err := CountTokensCompletion(t)
+		if err != nil {
+			tokens = estimateJSONTokens(t.Messages)
+		}
+		return t.Model, tokens + completionTokens(t.MaxTokens, t.N)
+	case *CompletionRequest:
+		var tokens int
+		if prompt, ok := t.Prompt.(string); ok {
+			tokens = estimateTextTokens(prompt)
+		} else {
+			tokens = estimateJSONTokens(t.Prompt)
+		}
+		return t.Model, tokens + completionTokens(t.MaxTokens, t.N)
+	case *EmbeddingRequest:
+		switch input := t.Input.(type) {
+		case string:
+			return t.Model, estimateTextTokens(input)
+		case []string:
+			var tokens int
+			for _, s := range input {
+				tokens += estimateTextTokens(s)
+			}
+			return t.Model, tokens
+		default:
+			return t.Model, estimateJSONTokens(input)
+		}
+	case *EditsRequest:
+		return t.Model, estimateTextTokens(t.Input) + estimateTextTokens(t.Instruction)
+	}
+}
+
+// OpenAI counts max_tokens once per choice against the limit, whether the tokens are generated or not
+func completionTokens(maxTokens, n int) int {
+	if maxTokens <= 0 {
+		return 0
+	}
+	if n > 1 {
+		return maxTokens * n
+	}
+	return maxTokens
+}
+
+// Newer models use other encodings but the counts are close enough for rate limiting
+func estimateTextTokens(text string) int {
+	tokens, err := countTokens(tokenizer.Cl100kBase, text)
+	if err != nil {
+		return len(text) / 4
+	}
+	return tokens
+}
+
+// estimateJSONTokens is used for inputs we cannot tokenize, about 4 bytes make a token
+func estimateJSONTokens(v any) int {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(b) / 4
+}
@@ -1,9 +1,11 @@
 package openai
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"time"
@@ -11,6 +13,8 @@ import (
 	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
 )
 
+// Err429 is returned by the client side rate limiter when a request cannot be sent in time, see RateLimiter.
+// Rate limit errors returned by the API match ErrRateLimited instead.
 var Err429 = errors.New("ratelimit or overload")
 
 const (
@@ -19,39 +23,60 @@ const (
 	backoffFactor = 2
 )
 
-var baseurl *url.URL
-var retryrequester *requests.RequestRetrier
-
-func init() {
-	baseurl, _ = url.Parse("https://api.openai.com")
+func (c *Client) request(ctx context.Context, method, path string, body, response any, apikey string, overrideDefaultMaxRetries int) error {
+	r, err := c.newRetryableRequest(method, path, body, response, apikey, overrideDefaultMaxRetries)
+	if err != nil {
+		return err
+	}
 
-	retryrequester = requests.NewRequestRetrier(initialDelay, maxRetries, backoffFactor)
+	ctx, span, err := c.startSpan(ctx, r, path, body)
+	if err != nil {
+		return err
+	}
+	err = c.retrier.Request(ctx, r)
+	endSpan(span, r.Attempts(), body, response, err)
 
-	retryrequester.Run()
+	return err
 }
 
-func request(method, path string, body, response any, apikey string, overrideDefaultMaxRetries int) error {
-	if apikey == "" && defaultAPIKey == "" {
-		return fmt.Errorf("we do not have an openai api key defined as default or provided for this request")
+func (c *Client) newRetryableRequest(method, path string, body, response any, apikey string, overrideDefaultMaxRetries int) (*requests.RetryableRequest, error) {
+	if apikey == "" {
+		apikey = c.getAPIKey()
 	}
-
 	if apikey == "" {
-		apikey = defaultAPIKey
+		return nil, fmt.Errorf("we do not have an openai api key defined as default or provided for this request")
 	}
 
-	url := baseurl.ResolveReference(&url.URL{Path: path}).String()
+	headers := http.Header{
+		"Authorization": []string{fmt.Sprintf("Bearer %s", apikey)},
+	}
+	if c.organization != "" {
+		headers.Set("OpenAI-Organization", c.organization)
+	}
+	if c.project != "" {
+		headers.Set("OpenAI-Project", c.project)
+	}
 
-	return retryrequester.Request(&requests.RetryableRequest{
+	// path may carry a query string, for example for pagination
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %s: %v", path, err)
+	}
+	url := c.baseURL.ResolveReference(ref).String()
+
+	r := &requests.RetryableRequest{
 		URL:                       url,
 		Method:                    method,
 		Body:                      body,
 		OverrideDefaultMaxRetries: int64(overrideDefaultMaxRetries),
 		Response:                  response,
-		HTTPTimeout:               300 * time.Second,
-		Headers: http.Header{
-			"Authorization": []string{fmt.Sprintf("Bearer %s", apikey)},
-		},
-		ParseErrBody: func(b []byte, err error, statusCode int, r *requests.RetryableRequest) error {
+		HTTPClient:                c.httpClient,
+		HTTPTimeout:               c.httpTimeout,
+		Headers:                   headers,
+		Logger:                    c.logger,
+		Hooks:                     c.hooks,
+		ExtractUsage:              usageOf,
+		ParseErrBody: func(b []byte, err error, statusCode int, header http.Header, r *requests.RetryableRequest) error {
 			var errRes ErrorResponse
 			if len(b) > 0 {
 				err = json.Unmarshal(b, &errRes)
@@ -59,39 +84,96 @@ func request(method, path string, body, response any, apikey string, overrideDef
 			if err != nil || errRes.Error == nil {
 				reqErr := RequestError{
 					StatusCode: statusCode,
-					Err:        fmt.Errorf("%v: %v", err, string(b)), // sometimes when OpenAI nginx fails the error messages is an HTML Page
+					RequestID:  header.Get("X-Request-Id"),
+					RetryAfter: requests.RetryAfter(header),
+				}
+				// sometimes when OpenAI nginx fails the error messages is an HTML Page
+				switch {
+				case err != nil:
+					reqErr.Err = fmt.Errorf("%v: %v", err, string(b))
+				case len(b) > 0:
+					reqErr.Err = errors.New(string(b))
 				}
-				err = fmt.Errorf("error requesting %s, %w", url, &reqErr)
-			} else {
-				errRes.Error.StatusCode = statusCode
-				err = fmt.Errorf("error requesting %s, status code: %d, message: %w", url, statusCode, errRes.Error)
+				return fmt.Errorf("error requesting %s, %w", url, &reqErr)
 			}
 
-			// Openai also has 500 errors sometimes for now so we retry them all until it stabilizes
-			// if statusCode == 429 {
-			// 	return Err429
-			// }
+			apiErr := newAPIError(errRes.Error, statusCode, header)
+			err = fmt.Errorf("error requesting %s, status code: %d, message: %w", url, statusCode, apiErr)
 
 			// We may get context overflow errors until we can figure out a reliable way to compute the number of tokens induced by the functions list and calls
 			// features
-			isContextLengthOverflow, delta, e := IsErrorContextLengthOverflow(err.Error())
-			if e != nil {
-				fmt.Println("Could not compute context length overflow correction:", e)
-			} else if isContextLengthOverflow {
-				fmt.Println("Encountered maximum context length overflow error, decreasing the maxtokens parameters by", delta)
+			var ctxErr *ContextLengthError
+			if errors.As(apiErr, &ctxErr) {
+				delta := ctxErr.Allowed - ctxErr.Requested - 1
 				switch t := r.Body.(type) {
 				case *CompletionRequest:
+					c.log().Warn("context length overflow, decreasing max tokens", "url", url, "max_tokens", t.MaxTokens, "delta", delta)
 					t.MaxTokens += delta
 				case *ChatCompletionRequest:
+					c.log().Warn("context length overflow, decreasing max tokens", "url", url, "max_tokens", t.MaxTokens, "delta", delta)
 					t.MaxTokens += delta
 				}
+			} else if errors.Is(apiErr, ErrContextLengthExceeded) {
+				c.log().Warn("could not compute context length overflow correction", "url", url, "error", apiErr)
 			}
 
 			return err
 		},
 		IsErrorFatal: func(err error) bool {
+			// A context length overflow is worth retrying once we decreased MaxTokens, if there was room to. Without
+			// a ContextLengthError we could not compute the correction, and the same request would fail again.
+			if errors.Is(err, ErrContextLengthExceeded) {
+				var ctxErr *ContextLengthError
+				if !errors.As(err, &ctxErr) {
+					return true
+				}
+				switch t := body.(type) {
+				case *CompletionRequest:
+					return t.MaxTokens <= 0
+				case *ChatCompletionRequest:
+					return t.MaxTokens <= 0
+				}
+			}
+
 			// Openai also has 500 errors sometimes for now so we retry them all until it stabilizes
-			return false
+			return isErrorFatal(err)
 		},
-	})
+	}
+
+	if c.rateLimiter != nil {
+		var model Model
+		r.BeforeSend = func(ctx context.Context) error {
+			// The body may change between attempts, for example when we correct MaxTokens
+			var tokens int
+			model, tokens = estimateTokens(body)
+			return c.rateLimiter.Wait(ctx, apikey, model, tokens)
+		}
+		r.OnResponse = func(statusCode int, header http.Header) {
+			c.rateLimiter.Update(apikey, model, statusCode, header)
+		}
+	}
+
+	return r, nil
+}
+
+func (c *Client) log() *slog.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	return slog.Default()
+}
+
+// usageOf returns the usage reported in the responses of the endpoints which have one
+func usageOf(response any) any {
+	switch t := response.(type) {
+	case *ChatCompletionResponse:
+		return &t.Usage
+	case *CompletionResponse:
+		return &t.Usage
+	case *EmbeddingResponse:
+		return &t.Usage
+	case *EditsResponse:
+		return &t.Usage
+	}
+	return nil
 }
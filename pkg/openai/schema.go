@@ -0,0 +1,492 @@
+package openai
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONSchema is the subset of JSON Schema supported by the structured outputs of OpenAI. It can be used as the
+// schema of a json_schema ResponseFormat and as the Parameters of a ChatCompletionFunction.
+type JSONSchema struct {
+	Type        string        `json:"type,omitempty"` // string, number, integer, boolean, array, object or null
+	Description string        `json:"description,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
+	Format      string        `json:"format,omitempty"`
+	// For arrays
+	Items *JSONSchema `json:"items,omitempty"`
+	// For objects
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	// Nullable values are an anyOf of their schema and of the null type
+	AnyOf []*JSONSchema `json:"anyOf,omitempty"`
+	// Recursive types are defined once in the Defs of the root schema and referenced with Ref
+	Ref  string                 `json:"$ref,omitempty"`
+	Defs map[string]*JSONSchema `json:"$defs,omitempty"`
+}
+
+// GenerateSchema returns the strict JSON Schema of the type of v, which must be a struct or a pointer to a struct.
+// Properties are named after their json tag, and the following tags are supported:
+//
+//	description:"The city of the user"
+//	enum:"celsius,fahrenheit"
+//
+// Strict schemas require every property, so the pointer and omitempty fields are the optional ones and they are
+// allowed to be null. Nested and embedded structs, slices and arrays, and time.Time are supported, maps and
+// interfaces are not. Like encoding/json, []byte and the types implementing encoding.TextMarshaler are strings,
+// and the fields of embedded structs are shadowed by the shallower fields with the same name.
+func GenerateSchema(v interface{}) (*JSONSchema, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("we need a struct to generate a schema, got %T", v)
+	}
+
+	g := &schemaGenerator{
+		building:  map[reflect.Type]bool{},
+		recursive: map[reflect.Type]bool{},
+		defs:      map[string]*JSONSchema{},
+	}
+
+	schema, err := g.schemaOf(t, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.defs) > 0 {
+		schema.Defs = g.defs
+	}
+
+	return schema, nil
+}
+
+type schemaGenerator struct {
+	building  map[reflect.Type]bool
+	recursive map[reflect.Type]bool
+	defs      map[string]*JSONSchema
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// implements reports whether the values or the pointers of type t implement iface, encoding/json uses the methods
+// with a pointer receiver of addressable values
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type, root bool) (*JSONSchema, error) {
+	if t.Kind() == reflect.Pointer {
+		return g.schemaOf(t.Elem(), false)
+	}
+
+	switch {
+	case t == timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}, nil
+	case implements(t, jsonMarshalerType):
+		return nil, fmt.Errorf("type %s has a custom JSON encoding, we cannot generate its schema", t)
+	case implements(t, textMarshalerType):
+		return &JSONSchema{Type: "string"}, nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		// encoding/json writes []byte in base64
+		return &JSONSchema{Type: "string"}, nil
+	}
+
+	switch t.Kind() {
+	default:
+		return nil, fmt.Errorf("type %s is not supported in structured outputs", t)
+	case reflect.String:
+		return &JSONSchema{Type: "string"}, nil
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := g.schemaOf(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+		return &JSONSchema{Type: "array", Items: items}, nil
+	case reflect.Struct:
+		if g.building[t] {
+			if t.Name() == "" {
+				return nil, fmt.Errorf("recursive anonymous struct %s is not supported", t)
+			}
+			g.recursive[t] = true
+			return &JSONSchema{Ref: "#/$defs/" + t.Name()}, nil
+		}
+
+		g.building[t] = true
+		schema, err := g.objectSchema(t)
+		delete(g.building, t)
+		if err != nil {
+			return nil, err
+		}
+
+		// The root schema is referenced as #, the other recursive types are moved to the definitions
+		if g.recursive[t] {
+			if root {
+				for _, def := range g.defs {
+					replaceRef(def, "#/$defs/"+t.Name(), "#")
+				}
+				replaceRef(schema, "#/$defs/"+t.Name(), "#")
+				return schema, nil
+			}
+			if other, ok := g.defs[t.Name()]; ok && !reflect.DeepEqual(other, schema) {
+				return nil, fmt.Errorf("two recursive types are named %s", t.Name())
+			}
+			g.defs[t.Name()] = schema
+			return &JSONSchema{Ref: "#/$defs/" + t.Name()}, nil
+		}
+
+		return schema, nil
+	}
+}
+
+func (g *schemaGenerator) objectSchema(t reflect.Type) (*JSONSchema, error) {
+	schema := &JSONSchema{
+		Type:                 "object",
+		Properties:           map[string]*JSONSchema{},
+		Required:             []string{},
+		AdditionalProperties: new(bool),
+	}
+
+	err := g.addFields(schema, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// schemaField is a field of a struct or of its embedded structs, with the JSON name encoding/json gives it
+type schemaField struct {
+	field  reflect.StructField
+	name   string
+	opts   string
+	tagged bool
+	depth  int
+}
+
+// collectFields lists the fields of t with those of its embedded structs, in declaration order. embedding holds
+// the embedded types being walked, to stop on embedded pointers to the struct itself.
+func collectFields(t reflect.Type, depth int, embedding map[reflect.Type]bool, fields []schemaField) []schemaField {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if !embedding[ft] {
+					embedding[ft] = true
+					fields = collectFields(ft, depth+1, embedding, fields)
+					delete(embedding, ft)
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		f := schemaField{field: field, name: name, opts: opts, tagged: name != "", depth: depth}
+		if name == "" {
+			f.name = field.Name
+		}
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// dominantFields applies the rules of encoding/json to fields with the same name: the shallowest field wins, then
+// the only tagged one at that depth, and the name is dropped if that still leaves several
+func dominantFields(fields []schemaField) []schemaField {
+	byName := map[string][]int{}
+	for i, f := range fields {
+		byName[f.name] = append(byName[f.name], i)
+	}
+
+	winners := make(map[int]bool, len(byName))
+	for _, candidates := range byName {
+		var shallowest []int
+		for _, c := range candidates {
+			switch {
+			case len(shallowest) == 0 || fields[c].depth < fields[shallowest[0]].depth:
+				shallowest = []int{c}
+			case fields[c].depth == fields[shallowest[0]].depth:
+				shallowest = append(shallowest, c)
+			}
+		}
+		if len(shallowest) > 1 {
+			var tagged []int
+			for _, c := range shallowest {
+				if fields[c].tagged {
+					tagged = append(tagged, c)
+				}
+			}
+			shallowest = tagged
+		}
+		if len(shallowest) == 1 {
+			winners[shallowest[0]] = true
+		}
+	}
+
+	var dominant []schemaField
+	for i, f := range fields {
+		if winners[i] {
+			dominant = append(dominant, f)
+		}
+	}
+	return dominant
+}
+
+func (g *schemaGenerator) addFields(schema *JSONSchema, t reflect.Type) error {
+	fields := dominantFields(collectFields(t, 0, map[reflect.Type]bool{t: true}, nil))
+
+	for _, f := range fields {
+		field := f.field
+
+		property, err := g.schemaOf(field.Type, false)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", field.Name, err)
+		}
+
+		if enum := field.Tag.Get("enum"); enum != "" {
+			// The enum of a slice applies to its items
+			target := property
+			if target.Type == "array" {
+				target = target.Items
+			}
+			target.Enum, err = parseEnum(enum, target.Type)
+			if err != nil {
+				return fmt.Errorf("field %s: %v", field.Name, err)
+			}
+		}
+
+		if field.Type.Kind() == reflect.Pointer || strings.Contains(","+f.opts+",", ",omitempty,") {
+			property = &JSONSchema{AnyOf: []*JSONSchema{property, {Type: "null"}}}
+		}
+
+		// Descriptions are set on the outer schema so that they are not lost in nullable properties and references
+		property.Description = field.Tag.Get("description")
+
+		schema.Required = append(schema.Required, f.name)
+		schema.Properties[f.name] = property
+	}
+
+	return nil
+}
+
+func parseEnum(tag, typ string) ([]interface{}, error) {
+	var enum []interface{}
+	for _, s := range strings.Split(tag, ",") {
+		s = strings.TrimSpace(s)
+		switch typ {
+		default:
+			return nil, fmt.Errorf("enum is not supported for the type %s", typ)
+		case "string":
+			enum = append(enum, s)
+		case "integer":
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer %s in enum", s)
+			}
+			enum = append(enum, n)
+		case "number":
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s in enum", s)
+			}
+			enum = append(enum, f)
+		}
+	}
+	return enum, nil
+}
+
+func replaceRef(schema *JSONSchema, from, to string) {
+	if schema == nil {
+		return
+	}
+	if schema.Ref == from {
+		schema.Ref = to
+	}
+	replaceRef(schema.Items, from, to)
+	for _, p := range schema.Properties {
+		replaceRef(p, from, to)
+	}
+	for _, s := range schema.AnyOf {
+		replaceRef(s, from, to)
+	}
+}
+
+// Validate checks that the JSON document data matches the schema
+func (s *JSONSchema) Validate(data []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	err := dec.Decode(&v)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	return s.validate(s, v, "$")
+}
+
+func (s *JSONSchema) validate(root *JSONSchema, v interface{}, path string) error {
+	if s.Ref != "" {
+		ref, err := root.resolve(s.Ref)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return ref.validate(root, v, path)
+	}
+
+	if len(s.AnyOf) > 0 {
+		var errs []string
+		for _, option := range s.AnyOf {
+			err := option.validate(root, v, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s matches none of the possible schemas: %s", path, strings.Join(errs, "; "))
+	}
+
+	switch s.Type {
+	case "null":
+		if v != nil {
+			return fmt.Errorf("%s must be null", path)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s must be a date-time: %v", path, err)
+			}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s must be a %s", path, s.Type)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if s.Type == "integer" && f != math.Trunc(f) {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				err := s.Items.validate(root, item, path+"["+strconv.Itoa(i)+"]")
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s is missing the required property %s", path, name)
+			}
+		}
+		for name, value := range obj {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s has the unexpected property %s", path, name)
+				}
+				continue
+			}
+			err := property.validate(root, value, path+"."+name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return fmt.Errorf("%s must be one of %v", path, s.Enum)
+	}
+
+	return nil
+}
+
+func (s *JSONSchema) resolve(ref string) (*JSONSchema, error) {
+	if ref == "#" {
+		return s, nil
+	}
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+	if ok {
+		if def, ok := s.Defs[name]; ok {
+			return def, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown reference %s", ref)
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		switch t := v.(type) {
+		case string:
+			if e == t {
+				return true
+			}
+		case json.Number:
+			f, err := t.Float64()
+			if err != nil {
+				return false
+			}
+			switch en := e.(type) {
+			case int64:
+				if float64(en) == f {
+					return true
+				}
+			case float64:
+				if en == f {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// RefusalError is returned by CreateStructuredChatCompletion when the model refuses to answer with the schema
+type RefusalError struct {
+	Refusal string
+}
+
+func (e *RefusalError) Error() string {
+	return "the model refused to answer: " + e.Refusal
+}
+
+// NewJSONSchemaResponseFormat returns a strict json_schema response format generated from the struct type of v,
+// see GenerateSchema
+func NewJSONSchemaResponseFormat(name string, v interface{}) (*ResponseFormat, error) {
+	schema, err := GenerateSchema(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResponseFormat{
+		Type: "json_schema",
+		JSONSchema: &ResponseFormatJSONSchema{
+			Name:   name,
+			Schema: schema,
+			Strict: true,
+		},
+	}, nil
+}
+
+// NewFunctionTool returns a strict function tool whose parameters are generated from the struct type of v,
+// see GenerateSchema
+func NewFunctionTool(name, description string, v interface{}) (ChatCompletionToolCall, error) {
+	schema, err := GenerateSchema(v)
+	if err != nil {
+		return ChatCompletionToolCall{}, err
+	}
+
+	return ChatCompletionToolCall{
+		Type: "function",
+		Function: &ChatCompletionFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  schema,
+			Strict:      true,
+		},
+	}, nil
+}
+
+// CreateStructuredChatCompletion uses DefaultClient, see CreateStructuredChatCompletionWithClient
+func CreateStructuredChatCompletion[T any](ctx context.Context, req *ChatCompletionRequest) (*T, *ChatCompletionResponse, error) {
+	return CreateStructuredChatCompletionWithClient[T](ctx, DefaultClient, req)
+}
+
+// CreateStructuredChatCompletionWithClient sends a chat completion request with a json_schema response format
+// generated from T if req.ResponseFormat is not set, and decodes the first choice into T once it is validated
+// against the schema. A *RefusalError is returned if the model refused to answer, along with the response.
+func CreateStructuredChatCompletionWithClient[T any](ctx context.Context, c *Client, req *ChatCompletionRequest) (*T, *ChatCompletionResponse, error) {
+	if req.ResponseFormat == nil {
+		var zero T
+		format, err := NewJSONSchemaResponseFormat(fmt.Sprintf("%T", zero), &zero)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The name may only contain letters, digits, underscores and dashes
+		format.JSONSchema.Name = schemaName(format.JSONSchema.Name)
+		req.ResponseFormat = format
+	}
+	if req.ResponseFormat.Type != "json_schema" || req.ResponseFormat.JSONSchema == nil || req.ResponseFormat.JSONSchema.Schema == nil {
+		return nil, nil, fmt.Errorf("we need a json_schema response format")
+	}
+
+	resp, err := c.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, resp, fmt.Errorf("the response has no choice")
+	}
+
+	message := resp.Choices[0].Message
+	if message.Refusal != "" {
+		return nil, resp, &RefusalError{Refusal: message.Refusal}
+	}
+	if resp.Choices[0].FinishReason == "length" {
+		return nil, resp, fmt.Errorf("the structured output was truncated because it reached the max tokens")
+	}
+
+	content, ok := message.Content.(string)
+	if !ok {
+		return nil, resp, fmt.Errorf("we expected a text content, got %T", message.Content)
+	}
+
+	err = req.ResponseFormat.JSONSchema.Schema.Validate([]byte(content))
+	if err != nil {
+		return nil, resp, fmt.Errorf("the structured output does not match the schema: %v", err)
+	}
+
+	result := new(T)
+	err = json.Unmarshal([]byte(content), result)
+	if err != nil {
+		return nil, resp, fmt.Errorf("could not decode the structured output: %v", err)
+	}
+
+	return result, resp, nil
+}
+
+func schemaName(typeName string) string {
+	b := []byte(typeName)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
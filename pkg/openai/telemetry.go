@@ -0,0 +1,153 @@
+package openai
+
+import (
+	"context"
+	"strings"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/telemetry"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/usage"
+)
+
+// operationNames maps endpoints to GenAI operation names
+var operationNames = map[string]string{
+	urlSuffix_chatcompletion: "chat",
+	urlSuffix_completion:     "text_completion",
+	urlSuffix_embeddings:     "embeddings",
+	urlSuffix_edits:          "edits",
+	urlSuffix_moderate:       "moderations",
+	urlSuffix_listmodels:     "list_models",
+
+	urlSuffix_imagegenerations: "image_generation",
+	urlSuffix_imageedits:       "image_edit",
+	urlSuffix_imagevariations:  "image_variation",
+
+	urlSuffix_audiotranscriptions: "transcription",
+	urlSuffix_audiotranslations:   "translation",
+	urlSuffix_audiospeech:         "speech",
+
+	urlSuffix_files:   "files",
+	urlSuffix_batches: "batches",
+
+	urlSuffix_finetuningjobs: "fine_tuning",
+}
+
+// startSpan checks the budgets of the usage tracker of the context, or else of the client, against the estimated
+// price of the request and starts its span. The span is not started if a budget would be exceeded.
+func (c *Client) startSpan(ctx context.Context, r *requests.RetryableRequest, path string, body any) (context.Context, *telemetry.GenAISpan, error) {
+	tracker := usage.FromContext(ctx)
+	if tracker == nil && c.usageTracker != nil {
+		tracker = c.usageTracker
+		ctx = usage.NewContext(ctx, tracker)
+	}
+
+	operation := operationName(path)
+	model := string(requestModel(body))
+	apikey := strings.TrimPrefix(r.Headers.Get("Authorization"), "Bearer ")
+
+	if tracker != nil {
+		err := tracker.Check(ctx, usage.Record{
+			Provider:  "openai",
+			Operation: operation,
+			Model:     model,
+			APIKey:    apikey,
+			Cost:      estimateCost(body),
+		})
+		if err != nil {
+			return ctx, nil, err
+		}
+	}
+
+	ctx, span := telemetry.StartGenAI(ctx, "openai", operation, model)
+	span.APIKey = apikey
+
+	return ctx, span, nil
+}
+
+func operationName(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+
+	// Paths with ids, such as v1/files/file-abc/content, are named after their collection
+	operation, ok := operationNames[path]
+	for p := path; !ok; {
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			return path
+		}
+		p = p[:i]
+		operation, ok = operationNames[p]
+	}
+
+	return operation
+}
+
+// endSpan records the usage, price and finish reasons of response, which is only read if err is nil
+func endSpan(span *telemetry.GenAISpan, attempts int, body, response any, err error) {
+	span.Attempts = attempts
+
+	if err == nil {
+		if usage, ok := usageOf(response).(*Usage); ok {
+			span.InputTokens = usage.PromptTokens
+			span.OutputTokens = usage.CompletionTokens
+			if cost, perr := usage.ComputePrice(requestModel(body)); perr == nil {
+				span.Cost = cost
+			}
+		}
+
+		switch t := response.(type) {
+		case *ChatCompletionResponse:
+			span.ResponseModel = t.Model
+			for _, c := range t.Choices {
+				span.FinishReasons = append(span.FinishReasons, c.FinishReason)
+			}
+		case *CompletionResponse:
+			span.ResponseModel = t.Model
+			for _, c := range t.Choices {
+				span.FinishReasons = append(span.FinishReasons, c.FinishReason)
+			}
+		case *EmbeddingResponse:
+			span.ResponseModel = t.Model
+		case *ModerateResponse:
+			span.ResponseModel = t.Model
+		case *ImageResponse:
+			span.Cost = t.pricePerImage * float64(len(t.Data))
+		case *AudioResponse:
+			span.Cost = t.Price
+		case *SpeechResponse:
+			span.Cost = t.Price
+		}
+	}
+
+	span.End(err)
+}
+
+func requestModel(body any) Model {
+	switch t := body.(type) {
+	case *ChatCompletionRequest:
+		return t.Model
+	case *CompletionRequest:
+		return t.Model
+	case *EmbeddingRequest:
+		return t.Model
+	case *EditsRequest:
+		return t.Model
+	case *ImageRequest:
+		if t.Model == "" {
+			return DallE2
+		}
+		return t.Model
+	case *TranscriptionRequest:
+		if t.Model == "" {
+			return Whisper1
+		}
+		return t.Model
+	case *TranslationRequest:
+		if t.Model == "" {
+			return Whisper1
+		}
+		return t.Model
+	case *SpeechRequest:
+		return t.Model
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package tokenizer
+
+import "math"
+
+type bpePart struct {
+	start int
+	rank  int
+}
+
+// bytePairEncode merges the bytes of piece following the ranks, lowest first, exactly like tiktoken's byte_pair_merge.
+// Every single byte has a rank so the merge always terminates with known tokens.
+func bytePairEncode(piece string, ranks map[string]int, tokens []int) []int {
+	if len(piece) == 1 {
+		return append(tokens, ranks[piece])
+	}
+
+	parts := make([]bpePart, 0, len(piece)+1)
+
+	minRank, minIndex := math.MaxInt, -1
+	for i := 0; i < len(piece)-1; i++ {
+		rank := math.MaxInt
+		if r, ok := ranks[piece[i:i+2]]; ok {
+			rank = r
+		}
+		if rank < minRank {
+			minRank, minIndex = rank, i
+		}
+		parts = append(parts, bpePart{start: i, rank: rank})
+	}
+	parts = append(parts, bpePart{start: len(piece) - 1, rank: math.MaxInt})
+	parts = append(parts, bpePart{start: len(piece), rank: math.MaxInt})
+
+	// getRank returns the rank of the token formed by merging parts i and i+1 with the part that follows them
+	getRank := func(i int) int {
+		if i+3 < len(parts) {
+			if r, ok := ranks[piece[parts[i].start:parts[i+3].start]]; ok {
+				return r
+			}
+		}
+		return math.MaxInt
+	}
+
+	for minRank != math.MaxInt {
+		i := minIndex
+
+		if i > 0 {
+			parts[i-1].rank = getRank(i - 1)
+		}
+		parts[i].rank = getRank(i)
+		parts = append(parts[:i+1], parts[i+2:]...)
+
+		minRank, minIndex = math.MaxInt, -1
+		for j := 0; j < len(parts)-1; j++ {
+			if parts[j].rank < minRank {
+				minRank, minIndex = parts[j].rank, j
+			}
+		}
+	}
+
+	for i := 0; i < len(parts)-1; i++ {
+		tokens = append(tokens, ranks[piece[parts[i].start:parts[i+1].start]])
+	}
+
+	return tokens
+}
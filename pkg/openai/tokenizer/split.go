@@ -0,0 +1,341 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The functions in this file split text in pieces before the byte pair encoding. Each one is a hand written
+// equivalent of the regular expression tiktoken uses for the encoding, since these rely on look-ahead assertions
+// that Go's regexp package does not support. The alternatives are tried in order at each position, as a
+// backtracking regexp engine would, and the first one that matches wins.
+
+// p50k_base and r50k_base:
+//
+//	's|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+
+func splitP50k(text string, yield func(piece string)) {
+	for i := 0; i < len(text); {
+		end := matchContraction(text, i, false)
+		if end < 0 {
+			end = matchOptionalSpaceRun(text, i, isLetter)
+		}
+		if end < 0 {
+			end = matchOptionalSpaceRun(text, i, isNumber)
+		}
+		if end < 0 {
+			end = matchOptionalSpaceRun(text, i, isOther)
+		}
+		if end < 0 {
+			end = matchSpacesNotFollowedByNonSpace(text, i)
+		}
+		if end < 0 {
+			end = matchSpaces(text, i)
+		}
+		if end < 0 {
+			end = nextRune(text, i)
+		}
+
+		yield(text[i:end])
+		i = end
+	}
+}
+
+// cl100k_base:
+//
+//	'(?i:[sdmt]|ll|ve|re)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func splitCl100k(text string, yield func(piece string)) {
+	for i := 0; i < len(text); {
+		end := matchContraction(text, i, true)
+		if end < 0 {
+			end = matchPrefixedLetters(text, i)
+		}
+		if end < 0 {
+			end = matchNumbers(text, i, 3)
+		}
+		if end < 0 {
+			end = matchOptionalSpaceRun(text, i, isOther)
+			if end >= 0 {
+				end = run(text, end, isNewline)
+			}
+		}
+		if end < 0 {
+			end = matchSpacesUntilNewline(text, i)
+		}
+		if end < 0 {
+			end = matchSpacesNotFollowedByNonSpace(text, i)
+		}
+		if end < 0 {
+			end = matchSpaces(text, i)
+		}
+		if end < 0 {
+			end = nextRune(text, i)
+		}
+
+		yield(text[i:end])
+		i = end
+	}
+}
+
+// o200k_base:
+//
+//	[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?
+//	|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?
+//	|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func splitO200k(text string, yield func(piece string)) {
+	for i := 0; i < len(text); {
+		end := matchWithOptionalPrefix(text, i, matchUpperThenLower)
+		if end < 0 {
+			end = matchWithOptionalPrefix(text, i, matchUpperOrLower)
+		}
+		if end >= 0 {
+			if c := matchContraction(text, end, true); c >= 0 {
+				end = c
+			}
+		}
+		if end < 0 {
+			end = matchNumbers(text, i, 3)
+		}
+		if end < 0 {
+			end = matchOptionalSpaceRun(text, i, isOther)
+			if end >= 0 {
+				end = run(text, end, func(r rune) bool { return r == '\r' || r == '\n' || r == '/' })
+			}
+		}
+		if end < 0 {
+			end = matchSpacesUntilNewline(text, i)
+		}
+		if end < 0 {
+			end = matchSpacesNotFollowedByNonSpace(text, i)
+		}
+		if end < 0 {
+			end = matchSpaces(text, i)
+		}
+		if end < 0 {
+			end = nextRune(text, i)
+		}
+
+		yield(text[i:end])
+		i = end
+	}
+}
+
+func isLetter(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+func isNumber(r rune) bool {
+	return unicode.IsNumber(r)
+}
+
+func isSpace(r rune) bool {
+	return unicode.IsSpace(r)
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+// isOther matches [^\s\p{L}\p{N}]
+func isOther(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// isPrefix matches [^\r\n\p{L}\p{N}]
+func isPrefix(r rune) bool {
+	return r != '\r' && r != '\n' && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// isUpper matches [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]
+func isUpper(r rune) bool {
+	return unicode.In(r, unicode.Lu, unicode.Lt, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+// isLower matches [\p{Ll}\p{Lm}\p{Lo}\p{M}]
+func isLower(r rune) bool {
+	return unicode.In(r, unicode.Ll, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+func nextRune(text string, i int) int {
+	_, size := utf8.DecodeRuneInString(text[i:])
+	return i + size
+}
+
+func runeAt(text string, i int) (rune, int) {
+	if i >= len(text) {
+		return -1, 0
+	}
+	return utf8.DecodeRuneInString(text[i:])
+}
+
+// run returns the end of the longest run of runes matching pred starting at i
+func run(text string, i int, pred func(rune) bool) int {
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !pred(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+var contractions = []string{"s", "t", "re", "ve", "m", "ll", "d"}
+
+// matchContraction matches 's|'t|'re|'ve|'m|'ll|'d, case insensitively if asked
+func matchContraction(text string, i int, caseInsensitive bool) int {
+	if i >= len(text) || text[i] != '\'' {
+		return -1
+	}
+
+	rest := text[i+1:]
+
+	for _, suffix := range contractions {
+		if len(rest) < len(suffix) {
+			continue
+		}
+		if rest[:len(suffix)] == suffix || (caseInsensitive && strings.EqualFold(rest[:len(suffix)], suffix)) {
+			return i + 1 + len(suffix)
+		}
+		// Unicode simple case folding maps the long s to s
+		if caseInsensitive && suffix == "s" && strings.HasPrefix(rest, "ſ") {
+			return i + 1 + len("ſ")
+		}
+	}
+
+	return -1
+}
+
+// matchOptionalSpaceRun matches ` ?X+` where X is given by pred, which never matches a space
+func matchOptionalSpaceRun(text string, i int, pred func(rune) bool) int {
+	start := i
+	if text[i] == ' ' {
+		start = i + 1
+	}
+
+	r, size := runeAt(text, start)
+	if size == 0 || !pred(r) {
+		return -1
+	}
+
+	return run(text, start+size, pred)
+}
+
+// matchPrefixedLetters matches [^\r\n\p{L}\p{N}]?\p{L}+
+func matchPrefixedLetters(text string, i int) int {
+	r, size := runeAt(text, i)
+	if isPrefix(r) {
+		i += size
+		r, size = runeAt(text, i)
+	}
+
+	if size == 0 || !unicode.IsLetter(r) {
+		return -1
+	}
+
+	return run(text, i+size, isLetter)
+}
+
+// matchNumbers matches \p{N}{1,max}
+func matchNumbers(text string, i int, max int) int {
+	end := i
+	for n := 0; n < max; n++ {
+		r, size := runeAt(text, end)
+		if size == 0 || !unicode.IsNumber(r) {
+			break
+		}
+		end += size
+	}
+
+	if end == i {
+		return -1
+	}
+
+	return end
+}
+
+// matchSpacesUntilNewline matches \s*[\r\n]+ which, once backtracking is taken into account,
+// ends right after the last line break of the run of spaces
+func matchSpacesUntilNewline(text string, i int) int {
+	end := run(text, i, isSpace)
+
+	// \r and \n can never be found inside a multi-byte UTF-8 sequence
+	for j := end - 1; j >= i; j-- {
+		if text[j] == '\r' || text[j] == '\n' {
+			return j + 1
+		}
+	}
+
+	return -1
+}
+
+// matchSpacesNotFollowedByNonSpace matches \s+(?!\S): all the spaces at the end of the text,
+// or all of them but the last one otherwise
+func matchSpacesNotFollowedByNonSpace(text string, i int) int {
+	end := run(text, i, isSpace)
+	if end == i {
+		return -1
+	}
+
+	if end == len(text) {
+		return end
+	}
+
+	_, size := utf8.DecodeLastRuneInString(text[i:end])
+	if end-size == i {
+		return -1
+	}
+
+	return end - size
+}
+
+// matchSpaces matches \s+
+func matchSpaces(text string, i int) int {
+	end := run(text, i, isSpace)
+	if end == i {
+		return -1
+	}
+	return end
+}
+
+// matchWithOptionalPrefix tries match after a [^\r\n\p{L}\p{N}] prefix first, then without it
+func matchWithOptionalPrefix(text string, i int, match func(text string, i int) int) int {
+	r, size := runeAt(text, i)
+	if isPrefix(r) {
+		if end := match(text, i+size); end >= 0 {
+			return end
+		}
+	}
+
+	return match(text, i)
+}
+
+// matchUpperThenLower matches [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+
+func matchUpperThenLower(text string, i int) int {
+	end := run(text, i, isUpper)
+
+	if r, size := runeAt(text, end); size > 0 && isLower(r) {
+		return run(text, end+size, isLower)
+	}
+
+	// Backtrack to the last rune of the upper run which also belongs to the lower class
+	for j := end; j > i; {
+		r, size := utf8.DecodeLastRuneInString(text[i:j])
+		if isLower(r) {
+			return j
+		}
+		j -= size
+	}
+
+	return -1
+}
+
+// matchUpperOrLower matches [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*
+func matchUpperOrLower(text string, i int) int {
+	end := run(text, i, isUpper)
+	if end == i {
+		return -1
+	}
+
+	return run(text, end, isLower)
+}
@@ -0,0 +1,203 @@
+// Package tokenizer is a native Go implementation of the byte pair encodings used by OpenAI models,
+// giving the same results as the tiktoken python package without spawning any process.
+package tokenizer
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Cl100kBase is used by gpt-4, gpt-3.5-turbo, text-embedding-ada-002 and text-embedding-3 models
+	Cl100kBase = "cl100k_base"
+
+	// O200kBase is used by gpt-4o models
+	O200kBase = "o200k_base"
+
+	// P50kBase is used by Codex models, text-davinci-002 and text-davinci-003
+	P50kBase = "p50k_base"
+)
+
+//go:embed encodings/*.tiktoken
+var rankFiles embed.FS
+
+// Encoding turns text into tokens and back. It is immutable once loaded and safe for concurrent use.
+type Encoding struct {
+	name string
+
+	ranks   map[string]int
+	decoder map[int]string
+	special map[int]string
+
+	split func(text string, yield func(piece string))
+}
+
+type encodingDef struct {
+	file    string
+	special map[string]int
+	split   func(text string, yield func(piece string))
+
+	once sync.Once
+	enc  *Encoding
+	err  error
+}
+
+var encodings = map[string]*encodingDef{
+	Cl100kBase: {
+		file: "encodings/cl100k_base.tiktoken",
+		special: map[string]int{
+			"<|endoftext|>":   100257,
+			"<|fim_prefix|>":  100258,
+			"<|fim_middle|>":  100259,
+			"<|fim_suffix|>":  100260,
+			"<|endofprompt|>": 100276,
+		},
+		split: splitCl100k,
+	},
+	O200kBase: {
+		file: "encodings/o200k_base.tiktoken",
+		special: map[string]int{
+			"<|endoftext|>":   199999,
+			"<|endofprompt|>": 200018,
+		},
+		split: splitO200k,
+	},
+	P50kBase: {
+		file: "encodings/p50k_base.tiktoken",
+		special: map[string]int{
+			"<|endoftext|>": 50256,
+		},
+		split: splitP50k,
+	},
+}
+
+// Exists tells whether an encoding is available without loading it
+func Exists(name string) bool {
+	_, ok := encodings[name]
+	return ok
+}
+
+// Get returns the encoding with the given name, for example cl100k_base. Rank files are parsed on first use only.
+func Get(name string) (*Encoding, error) {
+	def, ok := encodings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %s", name)
+	}
+
+	def.once.Do(func() {
+		def.enc, def.err = load(name, def)
+	})
+
+	return def.enc, def.err
+}
+
+func load(name string, def *encodingDef) (*Encoding, error) {
+	b, err := rankFiles.ReadFile(def.file)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := bytes.Count(b, []byte{'\n'}) + 1
+
+	enc := &Encoding{
+		name:    name,
+		ranks:   make(map[string]int, lines),
+		decoder: make(map[int]string, lines),
+		special: make(map[int]string, len(def.special)),
+		split:   def.split,
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if line == "" {
+			continue
+		}
+
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid line in the rank file of %s: %q", name, line)
+		}
+
+		tokenb, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token in the rank file of %s: %v", name, err)
+		}
+
+		r, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rank in the rank file of %s: %v", name, err)
+		}
+
+		enc.ranks[string(tokenb)] = r
+		enc.decoder[r] = string(tokenb)
+	}
+
+	for s, r := range def.special {
+		enc.special[r] = s
+	}
+
+	return enc, nil
+}
+
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// Encode returns the tokens of text. Special tokens such as <|endoftext|> are encoded as ordinary text.
+func (e *Encoding) Encode(text string) []int {
+	var tokens []int
+
+	e.split(text, func(piece string) {
+		tokens = e.encodePiece(piece, tokens)
+	})
+
+	return tokens
+}
+
+// Count returns the number of tokens of text without allocating the tokens themselves
+func (e *Encoding) Count(text string) int {
+	var count int
+	var buf []int
+
+	e.split(text, func(piece string) {
+		if _, ok := e.ranks[piece]; ok {
+			count++
+			return
+		}
+		buf = e.encodePiece(piece, buf[:0])
+		count += len(buf)
+	})
+
+	return count
+}
+
+// Decode returns the text corresponding to tokens. The result may not be valid UTF-8 if tokens
+// cut a multi-byte character in half.
+func (e *Encoding) Decode(tokens []int) (string, error) {
+	var sb strings.Builder
+
+	for _, t := range tokens {
+		s, ok := e.decoder[t]
+		if !ok {
+			s, ok = e.special[t]
+			if !ok {
+				return "", fmt.Errorf("invalid token %d for encoding %s", t, e.name)
+			}
+		}
+		sb.WriteString(s)
+	}
+
+	return sb.String(), nil
+}
+
+func (e *Encoding) encodePiece(piece string, tokens []int) []int {
+	if r, ok := e.ranks[piece]; ok {
+		return append(tokens, r)
+	}
+
+	return bytePairEncode(piece, e.ranks, tokens)
+}
@@ -0,0 +1,304 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/telemetry"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/usage"
+)
+
+// ErrMaxIterations is returned by RunConversation when the model still calls tools after MaxIterations completions
+var ErrMaxIterations = errors.New("the conversation reached its maximum number of iterations")
+
+// ErrConversationBudgetExceeded is returned by RunConversation when the price of the completions reaches MaxPrice
+// before a final answer. It wraps usage.ErrBudgetExceeded, so that one check covers both kinds of budget.
+var ErrConversationBudgetExceeded = fmt.Errorf("the conversation exceeded its budget: %w", usage.ErrBudgetExceeded)
+
+// ToolRegistry holds the Go functions a model may call, see RegisterTool. It is safe for concurrent use.
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]*registeredTool
+}
+
+type registeredTool struct {
+	definition ChatCompletionToolCall
+	schema     *JSONSchema
+	call       func(ctx context.Context, arguments string) (string, error)
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools: map[string]*registeredTool{},
+	}
+}
+
+// RegisterTool adds fn to the registry under name, or replaces the function already registered under it.
+// The parameters of the tool are the strict schema generated from the struct A, see GenerateSchema.
+// The result of fn is sent to the model as is if it is a string, or encoded in JSON otherwise.
+func RegisterTool[A any, R any](r *ToolRegistry, name, description string, fn func(ctx context.Context, args A) (R, error)) error {
+	if name == "" {
+		return fmt.Errorf("we need the name of the tool")
+	}
+	if fn == nil {
+		return fmt.Errorf("we need the function of the tool %s", name)
+	}
+
+	definition, err := NewFunctionTool(name, description, new(A))
+	if err != nil {
+		return fmt.Errorf("tool %s: %v", name, err)
+	}
+
+	tool := &registeredTool{
+		definition: definition,
+		schema:     definition.Function.Parameters.(*JSONSchema),
+		call: func(ctx context.Context, arguments string) (string, error) {
+			var args A
+			err := json.Unmarshal([]byte(arguments), &args)
+			if err != nil {
+				return "", fmt.Errorf("invalid arguments: %v", err)
+			}
+
+			result, err := fn(ctx, args)
+			if err != nil {
+				return "", err
+			}
+
+			if s, ok := any(result).(string); ok {
+				return s, nil
+			}
+			b, err := json.Marshal(result)
+			if err != nil {
+				return "", fmt.Errorf("could not encode the result: %v", err)
+			}
+			return string(b), nil
+		},
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tools[name] = tool
+
+	return nil
+}
+
+// Tools returns the definitions of the registered tools sorted by name, for ChatCompletionRequest.Tools
+func (r *ToolRegistry) Tools() []ChatCompletionToolCall {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tools := make([]ChatCompletionToolCall, 0, len(r.tools))
+	for _, tool := range r.tools {
+		tools = append(tools, tool.definition)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Function.Name < tools[j].Function.Name })
+
+	return tools
+}
+
+// Call validates the arguments of call against the schema of its tool and runs it.
+// A panic of the tool is recovered and returned as an error.
+func (r *ToolRegistry) Call(ctx context.Context, call *ToolCall) (output string, err error) {
+	if call == nil || call.Function == nil {
+		return "", fmt.Errorf("we need a function call")
+	}
+
+	r.mu.RLock()
+	tool, ok := r.tools[call.Function.Name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown tool %s", call.Function.Name)
+	}
+
+	ctx, span := telemetry.StartTool(ctx, call.Function.Name, call.ID)
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("tool %s panicked: %v", call.Function.Name, p)
+		}
+		span.End(err)
+	}()
+
+	err = tool.schema.Validate([]byte(call.Function.Arguments))
+	if err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+
+	return tool.call(ctx, call.Function.Arguments)
+}
+
+type RunConversationRequest struct {
+	// ChatCompletionRequest is sent at each iteration with the messages of the conversation so far, its Messages
+	// start the conversation and are not modified. The tools of Registry are added to its Tools.
+	// Note that a ToolChoice forcing a tool call makes the conversation run until MaxIterations.
+	ChatCompletionRequest *ChatCompletionRequest
+	Registry              *ToolRegistry
+
+	// Optional, the maximum number of completions, defaults to 10
+	MaxIterations int
+	// Optional, the conversation stops with ErrConversationBudgetExceeded once the price of its completions reaches MaxPrice
+	// dollars without a final answer
+	MaxPrice float64
+	// Optional, the maximum number of tools executed at the same time, 0 for no limit
+	MaxParallelToolCalls int
+	// Optional, called after each iteration, once the tools it called have returned
+	OnStep func(step *ConversationStep)
+}
+
+// ConversationStep is one iteration of a conversation: a completion and the tools it called, if any
+type ConversationStep struct {
+	Iteration int
+	Response  *ChatCompletionResponse
+	ToolCalls []ToolCallResult
+}
+
+type ToolCallResult struct {
+	Call *ToolCall
+	// Output is sent back to the model, it describes Err if the tool failed
+	Output   string
+	Err      error
+	Duration time.Duration
+}
+
+type RunConversationResponse struct {
+	// Messages is the whole conversation, the final answer included
+	Messages []ChatCompletionMessage
+	Steps    []*ConversationStep
+	// Response is the last completion, its first choice is the final answer
+	Response *ChatCompletionResponse
+
+	// Price of all the completions of the conversation
+	Price float64 `json:"price,omitempty"`
+}
+
+// RunConversation uses DefaultClient, see Client.RunConversation
+func RunConversation(ctx context.Context, req *RunConversationRequest) (*RunConversationResponse, error) {
+	return DefaultClient.RunConversation(ctx, req)
+}
+
+// RunConversation sends the chat completion request, runs the tools called by the model in parallel and sends their
+// results back until the model gives a final answer. Failing tools are reported to the model, which may recover.
+// When ErrMaxIterations, ErrConversationBudgetExceeded or a completion error is returned, the conversation so far is returned too.
+func (c *Client) RunConversation(ctx context.Context, req *RunConversationRequest) (*RunConversationResponse, error) {
+	if req.ChatCompletionRequest == nil {
+		return nil, fmt.Errorf("we need a chat completion request")
+	}
+	if req.Registry == nil {
+		return nil, fmt.Errorf("we need a tool registry")
+	}
+
+	maxIterations := req.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = 10
+	}
+
+	tools := append(append([]ChatCompletionToolCall(nil), req.ChatCompletionRequest.Tools...), req.Registry.Tools()...)
+
+	resp := &RunConversationResponse{
+		Messages: append([]ChatCompletionMessage(nil), req.ChatCompletionRequest.Messages...),
+	}
+
+	for i := 0; i < maxIterations; i++ {
+		// MaxTokens is resolved again at each iteration since the conversation grows
+		completionReq := *req.ChatCompletionRequest
+		completionReq.Messages = resp.Messages
+		completionReq.Tools = tools
+
+		completion, err := c.CreateChatCompletion(ctx, &completionReq)
+		if err != nil {
+			return resp, err
+		}
+		if len(completion.Choices) == 0 {
+			return resp, fmt.Errorf("the response has no choice")
+		}
+
+		resp.Response = completion
+		resp.Price += completion.Price
+
+		message := completion.Choices[0].Message
+		resp.Messages = append(resp.Messages, message)
+
+		step := &ConversationStep{
+			Iteration: i,
+			Response:  completion,
+		}
+		resp.Steps = append(resp.Steps, step)
+
+		if len(message.ToolCalls) == 0 {
+			if req.OnStep != nil {
+				req.OnStep(step)
+			}
+			return resp, nil
+		}
+
+		step.ToolCalls = c.runToolCalls(ctx, req.Registry, message.ToolCalls, req.MaxParallelToolCalls)
+		for _, result := range step.ToolCalls {
+			var id string
+			if result.Call != nil {
+				id = result.Call.ID
+			}
+			resp.Messages = append(resp.Messages, ChatCompletionMessage{
+				Role:       Tool,
+				Content:    result.Output,
+				ToolCallID: id,
+			})
+		}
+
+		if req.OnStep != nil {
+			req.OnStep(step)
+		}
+
+		if req.MaxPrice > 0 && resp.Price >= req.MaxPrice {
+			return resp, fmt.Errorf("%w: %f dollars spent for a maximum of %f", ErrConversationBudgetExceeded, resp.Price, req.MaxPrice)
+		}
+	}
+
+	return resp, fmt.Errorf("%w: %d", ErrMaxIterations, maxIterations)
+}
+
+func (c *Client) runToolCalls(ctx context.Context, registry *ToolRegistry, calls []*ToolCall, maxParallel int) []ToolCallResult {
+	results := make([]ToolCallResult, len(calls))
+
+	var sem chan struct{}
+	if maxParallel > 0 {
+		sem = make(chan struct{}, maxParallel)
+	}
+
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call *ToolCall) {
+			defer wg.Done()
+
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+
+			start := time.Now()
+			output, err := registry.Call(ctx, call)
+			results[i] = ToolCallResult{
+				Call:     call,
+				Output:   output,
+				Err:      err,
+				Duration: time.Since(start),
+			}
+			if err != nil {
+				name := ""
+				if call != nil && call.Function != nil {
+					name = call.Function.Name
+				}
+				c.log().Warn("tool call failed, the error is reported to the model", "tool", name, "error", err)
+				results[i].Output = "error: " + err.Error()
+			}
+		}(i, call)
+	}
+	wg.Wait()
+
+	return results
+}
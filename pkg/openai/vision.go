@@ -0,0 +1,167 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"net/http"
+	"os"
+)
+
+type ImageDetail string
+
+const (
+	ImageDetailAuto ImageDetail = "auto"
+	ImageDetailLow  ImageDetail = "low"
+	ImageDetailHigh ImageDetail = "high"
+)
+
+type ImageURL struct {
+	// URL is either the URL of the image or a data URL with its base64 encoded content
+	URL    string      `json:"url"`
+	Detail ImageDetail `json:"detail,omitempty"`
+
+	// Width and Height in pixels are used to count the tokens of the image, they are set by NewImagePartFromBytes
+	// and NewImagePartFromFile. The largest image is assumed when they are unknown.
+	Width  int `json:"-"`
+	Height int `json:"-"`
+}
+
+func NewTextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+// NewImagePart returns an image part for the image at url, which may also be a data URL. Set the Width and Height
+// of its ImageURL if they are known to count its tokens precisely.
+func NewImagePart(url string, detail ImageDetail) ContentPart {
+	return ContentPart{
+		Type: "image_url",
+		ImageURL: &ImageURL{
+			URL:    url,
+			Detail: detail,
+		},
+	}
+}
+
+// NewImagePartFromBytes returns an image part with a data URL of the PNG, JPEG, WEBP or GIF image data
+func NewImagePartFromBytes(data []byte, detail ImageDetail) (ContentPart, error) {
+	contentType := http.DetectContentType(data)
+
+	var width, height int
+	switch contentType {
+	default:
+		return ContentPart{}, fmt.Errorf("the image must be a PNG, JPEG, WEBP or GIF, we got %s", contentType)
+	case "image/png", "image/jpeg", "image/gif":
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return ContentPart{}, fmt.Errorf("could not decode the image: %v", err)
+		}
+		width, height = config.Width, config.Height
+	case "image/webp":
+		var err error
+		width, height, err = webpSize(data)
+		if err != nil {
+			return ContentPart{}, err
+		}
+	}
+
+	part := NewImagePart("data:"+contentType+";base64,"+base64.StdEncoding.EncodeToString(data), detail)
+	part.ImageURL.Width = width
+	part.ImageURL.Height = height
+
+	return part, nil
+}
+
+// NewImagePartFromFile returns an image part with a data URL of the PNG, JPEG, WEBP or GIF image file at path
+func NewImagePartFromFile(path string, detail ImageDetail) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, err
+	}
+
+	return NewImagePartFromBytes(data, detail)
+}
+
+// webpSize reads the dimensions of a WEBP image from the header of its first chunk
+func webpSize(data []byte) (int, int, error) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, fmt.Errorf("invalid WEBP image")
+	}
+
+	switch string(data[12:16]) {
+	case "VP8 ":
+		width := int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff)
+		return width, height, nil
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(data[21:25])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X":
+		width := int(uint32(data[24]) | uint32(data[25])<<8 | uint32(data[26])<<16)
+		height := int(uint32(data[27]) | uint32(data[28])<<8 | uint32(data[29])<<16)
+		return width + 1, height + 1, nil
+	}
+
+	return 0, 0, fmt.Errorf("unsupported WEBP format %s", data[12:16])
+}
+
+// CountImageTokens returns the number of prompt tokens of an image for model m. Images in low detail cost a fixed
+// number of tokens. Otherwise the image is scaled down to fit in 2048x2048 and then so that its shortest side is
+// 768 pixels at most, and each 512x512 tile it covers costs more tokens. A width or height of 0 counts the largest
+// possible image. The auto detail is counted as high.
+func CountImageTokens(m Model, width, height int, detail ImageDetail) (int, error) {
+	info, err := LookupModel(m)
+	if err != nil {
+		return 0, err
+	}
+	if !supportsModality(info.InputModalities, ModalityImage) {
+		return 0, fmt.Errorf("model %s does not support image inputs", m)
+	}
+
+	return imageTokens(info, width, height, detail), nil
+}
+
+func imageTokens(info ModelInfo, width, height int, detail ImageDetail) int {
+	baseTokens, tileTokens := info.ImageBaseTokens, info.ImageTileTokens
+	if baseTokens == 0 {
+		baseTokens = 85
+	}
+	if tileTokens == 0 {
+		tileTokens = 170
+	}
+
+	if detail == ImageDetailLow {
+		return baseTokens
+	}
+
+	// The largest number of tiles, for example for a 2048x768 image
+	tiles := 8
+
+	if width > 0 && height > 0 {
+		w, h := float64(width), float64(height)
+		if longest := math.Max(w, h); longest > 2048 {
+			w, h = w*2048/longest, h*2048/longest
+		}
+		if shortest := math.Min(w, h); shortest > 768 {
+			w, h = w*768/shortest, h*768/shortest
+		}
+		tiles = int(math.Ceil(w/512) * math.Ceil(h/512))
+	}
+
+	return baseTokens + tiles*tileTokens
+}
+
+func supportsModality(modalities []Modality, modality Modality) bool {
+	for _, m := range modalities {
+		if m == modality {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package requests
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrTimeout is wrapped by the errors of requests which did not get a response in time
+var ErrTimeout = errors.New("timeout")
+
+// ErrRetrierStopped is wrapped by the errors of requests which were waiting for a retry when their retrier stopped
+var ErrRetrierStopped = errors.New("request retrier stopped")
+
+func transportError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("http request: %w: %w", ErrTimeout, err)
+	}
+	return fmt.Errorf("http request: %w", err)
+}
@@ -0,0 +1,73 @@
+package requests
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Hooks are optional callbacks called during the lifecycle of a RetryableRequest, for example to export metrics.
+// They are called synchronously from the goroutine sending the request and must not block.
+type Hooks struct {
+	// BeforeSend is called before each attempt
+	BeforeSend func(ctx context.Context, info *RequestInfo)
+	// AfterResponse is called after each attempt, info.StatusCode is 0 if we did not get a response at all
+	AfterResponse func(ctx context.Context, info *RequestInfo)
+	// OnRetry is called when a failed attempt is scheduled to be retried after delay
+	OnRetry func(ctx context.Context, info *RequestInfo, delay time.Duration)
+	// OnGiveUp is called when a failed request is not retried anymore, its error is returned to the caller
+	OnGiveUp func(ctx context.Context, info *RequestInfo)
+}
+
+// RequestInfo describes an attempt of a request
+type RequestInfo struct {
+	Method string
+	URL    string
+	// Attempt starts at 1
+	Attempt int
+
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+
+	// Usage reported by the API, set for successful responses when the request has an ExtractUsage function
+	Usage any
+}
+
+func (r *RetryableRequest) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.Default()
+}
+
+func (r *RetryableRequest) logRetry(delay time.Duration) {
+	r.logger().LogAttrs(r.ctx, slog.LevelWarn, "request failed, retrying",
+		slog.String("method", r.info.Method),
+		slog.String("url", r.info.URL),
+		slog.Int("attempt", r.info.Attempt),
+		slog.Int("status", r.info.StatusCode),
+		slog.Duration("latency", r.info.Latency),
+		slog.Duration("delay", delay),
+		slog.Any("error", r.info.Err),
+	)
+
+	if r.Hooks != nil && r.Hooks.OnRetry != nil {
+		r.Hooks.OnRetry(r.ctx, r.info, delay)
+	}
+}
+
+func (r *RetryableRequest) logGiveUp() {
+	r.logger().LogAttrs(r.ctx, slog.LevelError, "request failed",
+		slog.String("method", r.info.Method),
+		slog.String("url", r.info.URL),
+		slog.Int("attempt", r.info.Attempt),
+		slog.Int("status", r.info.StatusCode),
+		slog.Duration("latency", r.info.Latency),
+		slog.Any("error", r.info.Err),
+	)
+
+	if r.Hooks != nil && r.Hooks.OnGiveUp != nil {
+		r.Hooks.OnGiveUp(r.ctx, r.info)
+	}
+}
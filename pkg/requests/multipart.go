@@ -0,0 +1,102 @@
+package requests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// MultipartForm is a request body sent as multipart/form-data, for example to upload files.
+// Files are kept in memory so that the form can be encoded again for each retry.
+type MultipartForm struct {
+	Fields []FormField
+	Files  []FormFile
+}
+
+type FormField struct {
+	Name  string
+	Value string
+}
+
+type FormFile struct {
+	// Name of the form field, for example image
+	Name     string
+	FileName string
+	// Optional, application/octet-stream is used otherwise
+	ContentType string
+	Data        []byte
+}
+
+// AddField appends a field, empty values are skipped since they mean the parameter is not set
+func (f *MultipartForm) AddField(name, value string) {
+	if value == "" {
+		return
+	}
+	f.Fields = append(f.Fields, FormField{Name: name, Value: value})
+}
+
+// AddFile reads r entirely and appends it as a file
+func (f *MultipartForm) AddFile(name, fileName, contentType string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("could not read file %s: %v", fileName, err)
+	}
+
+	f.Files = append(f.Files, FormFile{
+		Name:        name,
+		FileName:    fileName,
+		ContentType: contentType,
+		Data:        data,
+	})
+
+	return nil
+}
+
+// encode returns the body and its Content-Type header, which carries the boundary
+func (f *MultipartForm) encode() ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for _, field := range f.Fields {
+		err := w.WriteField(field.Name, field.Value)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	for _, file := range f.Files {
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(file.Name), escapeQuotes(file.FileName)))
+		h.Set("Content-Type", contentType)
+
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = part.Write(file.Data)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,56 @@
+package requests
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryAfter returns how long the server asked us to wait before trying again, or 0 if it did not say.
+// Retry-After, in seconds or as an HTTP date, takes precedence, then its retry-after-ms variant. Otherwise we look
+// at the x-ratelimit-reset-requests and x-ratelimit-reset-tokens headers sent by OpenAI and compatible APIs,
+// for example 1s or 6m0s, and wait for the reset of the limits which are exhausted.
+func RetryAfter(h http.Header) time.Duration {
+	return retryAfter(h, time.Now())
+}
+
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			if secs > 0 {
+				return time.Duration(secs * float64(time.Second))
+			}
+		} else if t, err := http.ParseTime(v); err == nil {
+			if d := t.Sub(now); d > 0 {
+				return d
+			}
+		}
+	}
+
+	if v := h.Get("Retry-After-Ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	var wait time.Duration
+	for _, limit := range []string{"requests", "tokens"} {
+		reset := h.Get("X-Ratelimit-Reset-" + limit)
+		if reset == "" {
+			continue
+		}
+		// Waiting for a limit which is not exhausted would be pointless, a daily one may only reset hours from now
+		if remaining := h.Get("X-Ratelimit-Remaining-" + limit); remaining != "" && remaining != "0" {
+			continue
+		}
+		d, err := time.ParseDuration(reset)
+		if err != nil {
+			continue
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
@@ -2,6 +2,7 @@ package requests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,10 +19,10 @@ type Request struct {
 	Headers  http.Header
 
 	HTTPTimeout  time.Duration
-	ParseErrBody func(body []byte, err error, statusCode int, r *Request) error
+	ParseErrBody func(body []byte, err error, statusCode int, header http.Header, r *Request) error
 }
 
-func Send(r *Request) error {
+func Send(ctx context.Context, r *Request) error {
 	var err error
 
 	var jsbody []byte
@@ -39,9 +40,9 @@ func Send(r *Request) error {
 	var req *http.Request
 
 	if jsbody != nil {
-		req, err = http.NewRequest(r.Method, r.URL, bytes.NewReader(jsbody))
+		req, err = http.NewRequestWithContext(ctx, r.Method, r.URL, bytes.NewReader(jsbody))
 	} else {
-		req, err = http.NewRequest(r.Method, r.URL, nil)
+		req, err = http.NewRequestWithContext(ctx, r.Method, r.URL, nil)
 	}
 	if err != nil {
 		return fmt.Errorf("http request: %v", err)
@@ -72,7 +73,7 @@ func Send(r *Request) error {
 		Timeout: r.HTTPTimeout,
 	}).Do(req)
 	if err != nil {
-		return fmt.Errorf("http request: %v", err)
+		return transportError(err)
 	}
 	defer resp.Body.Close()
 
@@ -82,7 +83,7 @@ func Send(r *Request) error {
 			err = fmt.Errorf("We received %v and could not read the request's body: %v", err, errReadBody)
 		}
 
-		err = r.ParseErrBody(b, err, resp.StatusCode, r)
+		err = r.ParseErrBody(b, err, resp.StatusCode, resp.Header, r)
 
 		return err
 	}
@@ -2,23 +2,38 @@ package requests
 
 import (
 	"bytes"
+	"container/heap"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// RequestRetrier retries failed requests in the background. Each request waiting for a retry is kept in a
+// timer heap ordered by its retry time, and a scheduler goroutine started by Run sends each one as soon as
+// it is due, concurrently with the others up to a limit.
 type RequestRetrier struct {
 	initialDelay  time.Duration
 	maxRetries    int
 	backoffFactor int
 
-	requestswaiting   []*RetryableRequest
-	requestswaitingMu *sync.Mutex
+	// slots bounds the number of retries in flight
+	slots chan struct{}
+	// wake tells the scheduler that the head of the queue may have changed
+	wake chan struct{}
+	// stop is closed by Stop
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	waiting   retryQueue
+	waitingMu *sync.Mutex
 }
 
 type RetryableRequest struct {
@@ -28,18 +43,58 @@ type RetryableRequest struct {
 	Response any
 	Headers  http.Header
 
+	// Optional, a new http.Client is used for each request otherwise
+	HTTPClient *http.Client
+
 	HTTPTimeout  time.Duration
-	ParseErrBody func(body []byte, err error, statusCode int, r *RetryableRequest) error
+	ParseErrBody func(body []byte, err error, statusCode int, header http.Header, r *RetryableRequest) error
 	IsErrorFatal func(error) bool
 
+	// Optional, called before each attempt, for example to wait for a rate limiter. An error aborts the attempt.
+	BeforeSend func(ctx context.Context) error
+	// Optional, called with the status code and headers of each response, successful or not
+	OnResponse func(statusCode int, header http.Header)
+
+	// Optional, slog.Default() is used otherwise
+	Logger *slog.Logger
+	Hooks  *Hooks
+	// Optional, returns the usage reported in a successful response for RequestInfo.Usage
+	ExtractUsage func(response any) any
+
+	ctx        context.Context
+	readerBody []byte
+	// infoMu guards info and abandoned, an attempt in flight when Request returns must not update info anymore
+	infoMu                    sync.Mutex
+	info                      *RequestInfo
+	abandoned                 bool
 	errCh                     chan error
-	retryTime                 int64
+	retryAt                   time.Time
+	retryAfter                time.Duration
 	retryCount                int64
 	OverrideDefaultMaxRetries int64
-	newDelay                  time.Duration
+
+	// index in the retry queue, -1 when the request is not waiting
+	index int
+}
+
+const defaultMaxConcurrentRetries = 8
+
+type RetrierOption interface {
+	RetrierOption()
+}
+
+type withMaxConcurrentRetriesOption struct {
+	Max int
+}
+
+func (*withMaxConcurrentRetriesOption) RetrierOption() {}
+
+// WithMaxConcurrentRetries sets how many retries may be in flight at the same time, it defaults to 8
+func WithMaxConcurrentRetries(max int) *withMaxConcurrentRetriesOption {
+	return &withMaxConcurrentRetriesOption{Max: max}
 }
 
-func NewRequestRetrier(initialDelay time.Duration, maxRetries, backoffFactor int) *RequestRetrier {
+func NewRequestRetrier(initialDelay time.Duration, maxRetries, backoffFactor int, opts ...RetrierOption) *RequestRetrier {
 	if initialDelay <= 0 {
 		initialDelay = 30 * time.Second
 	}
@@ -50,110 +105,365 @@ func NewRequestRetrier(initialDelay time.Duration, maxRetries, backoffFactor int
 		backoffFactor = 2
 	}
 
+	maxConcurrent := defaultMaxConcurrentRetries
+
+	for i := 0; i < len(opts); i++ {
+		switch t := opts[i].(type) {
+		case *withMaxConcurrentRetriesOption:
+			if t.Max > 0 {
+				maxConcurrent = t.Max
+			}
+		}
+	}
+
 	return &RequestRetrier{
-		initialDelay:      initialDelay,
-		backoffFactor:     backoffFactor,
-		maxRetries:        maxRetries,
-		requestswaitingMu: &sync.Mutex{},
+		initialDelay:  initialDelay,
+		backoffFactor: backoffFactor,
+		maxRetries:    maxRetries,
+		slots:         make(chan struct{}, maxConcurrent),
+		wake:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		waitingMu:     &sync.Mutex{},
 	}
 }
 
+// Run starts the scheduler goroutine, it must be called once before any request is sent
 func (rr *RequestRetrier) Run() {
 	go func() {
-	Main:
 		for {
-			time.Sleep(rr.initialDelay)
-
-			var reqtodo []*RetryableRequest
-
-			now := time.Now().Unix()
+			select {
+			case rr.slots <- struct{}{}:
+			case <-rr.stop:
+				return
+			}
 
-			rr.requestswaitingMu.Lock()
-			for i := len(rr.requestswaiting) - 1; i > -1; i-- {
-				if rr.requestswaiting[i].retryTime < now {
-					reqtodo = append(reqtodo, rr.requestswaiting[i])
-					rr.requestswaiting[i] = rr.requestswaiting[len(rr.requestswaiting)-1]
-					rr.requestswaiting = rr.requestswaiting[:len(rr.requestswaiting)-1]
-				}
+			r := rr.nextDue()
+			if r == nil {
+				return
 			}
-			rr.requestswaitingMu.Unlock()
 
-			var retryall = func(startingindex int) {
-				for i := startingindex; i < len(reqtodo); i++ {
-					r := reqtodo[i]
+			go func() {
+				defer func() {
+					<-rr.slots
+				}()
+				rr.retry(r)
+			}()
+		}
+	}()
+}
 
-					r.newDelay *= time.Duration(rr.backoffFactor)
-					r.retryTime = time.Now().Add(r.newDelay).Unix()
-				}
+// Stop ends the scheduler goroutine started by Run. The requests waiting for a retry fail with an error wrapping
+// ErrRetrierStopped, those in flight complete but are not retried again. It is safe to call it multiple times.
+func (rr *RequestRetrier) Stop() {
+	rr.stopOnce.Do(func() {
+		rr.waitingMu.Lock()
+		close(rr.stop)
+		waiting := rr.waiting
+		rr.waiting = nil
+		rr.waitingMu.Unlock()
+
+		for _, r := range waiting {
+			r.index = -1
+			r.errCh <- fmt.Errorf("%w: %s %s was waiting for a retry", ErrRetrierStopped, r.Method, r.URL)
+		}
+	})
+}
 
-				if startingindex < len(reqtodo) {
-					rr.requestswaitingMu.Lock()
-					rr.requestswaiting = append(rr.requestswaiting, reqtodo[startingindex:]...)
-					rr.requestswaitingMu.Unlock()
-				}
+// nextDue blocks until the request at the head of the queue is due and pops it, it returns nil once the retrier
+// is stopped
+func (rr *RequestRetrier) nextDue() *RetryableRequest {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		wait := time.Duration(-1)
+
+		rr.waitingMu.Lock()
+		if len(rr.waiting) > 0 {
+			wait = time.Until(rr.waiting[0].retryAt)
+			if wait <= 0 {
+				r := heap.Pop(&rr.waiting).(*RetryableRequest)
+				rr.waitingMu.Unlock()
+				return r
 			}
+		}
+		rr.waitingMu.Unlock()
 
-			for i := 0; i < len(reqtodo); i++ {
-				r := reqtodo[i]
-				err := rr.requestnowait(r)
-				if err != nil {
-					if r.IsErrorFatal(err) || (r.OverrideDefaultMaxRetries == 0 && r.retryCount >= int64(rr.maxRetries)) ||
-						(r.OverrideDefaultMaxRetries > 0 && r.retryCount >= int64(r.OverrideDefaultMaxRetries)) {
-						r.errCh <- err
-						time.Sleep(10*time.Second + time.Duration(rand.Intn(10)))
-						continue
-					}
-
-					retryall(i)
-					continue Main
-				}
-
-				r.errCh <- nil
+		if wait < 0 {
+			select {
+			case <-rr.wake:
+			case <-rr.stop:
+				return nil
+			}
+			continue
+		}
 
-				time.Sleep(time.Duration(float64(rand.Intn(100)) / 100.0 * float64(rr.initialDelay)))
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-rr.wake:
+			if !timer.Stop() {
+				<-timer.C
 			}
+		case <-rr.stop:
+			timer.Stop()
+			return nil
 		}
-	}()
+	}
 }
 
-func (rr *RequestRetrier) Request(r *RetryableRequest) error {
+func (rr *RequestRetrier) retry(r *RetryableRequest) {
+	// The caller already got its answer
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	err := rr.requestnowait(r)
+	if err == nil {
+		r.errCh <- nil
+		return
+	}
+
+	if r.ctx.Err() != nil {
+		r.errCh <- r.ctx.Err()
+		return
+	}
+
+	attempts := atomic.LoadInt64(&r.retryCount)
+	if r.IsErrorFatal(err) || (r.OverrideDefaultMaxRetries == 0 && attempts >= int64(rr.maxRetries)) ||
+		(r.OverrideDefaultMaxRetries > 0 && attempts >= int64(r.OverrideDefaultMaxRetries)) {
+		r.logGiveUp()
+		r.errCh <- err
+		return
+	}
+
+	if !rr.schedule(r, err) {
+		r.logGiveUp()
+		r.errCh <- err
+	}
+}
+
+// Request sends r and, if it fails with a non fatal error, waits for the retries to succeed or give up.
+// Cancelling ctx removes r from the waiting list, aborts the request in flight and returns ctx.Err().
+// If ctx has a deadline, no retry is scheduled after it and the last error is returned instead.
+func (rr *RequestRetrier) Request(ctx context.Context, r *RetryableRequest) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	r.ctx = ctx
+	r.index = -1
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := rr.requestnowait(r)
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		if r.IsErrorFatal(err) {
+			r.logGiveUp()
 			return err
 		}
 
 		r.errCh = make(chan error, 1)
-		r.retryTime = time.Now().Add(rr.initialDelay).Unix()
-		r.newDelay = rr.initialDelay * time.Duration(rr.backoffFactor)
-
-		fmt.Printf("* Error: %s, retrying in %v...\n", err, rr.initialDelay)
 
-		rr.requestswaitingMu.Lock()
-		rr.requestswaiting = append(rr.requestswaiting, r)
-		rr.requestswaitingMu.Unlock()
+		if !rr.schedule(r, err) {
+			r.logGiveUp()
+			return err
+		}
 
-		return <-r.errCh
+		select {
+		case err = <-r.errCh:
+			return err
+		case <-ctx.Done():
+			rr.remove(r)
+			r.infoMu.Lock()
+			r.abandoned = true
+			r.infoMu.Unlock()
+			return ctx.Err()
+		}
 	}
 
 	return nil
 }
 
+// schedule queues r for its next attempt, it returns false if that attempt would happen after the deadline of r.ctx
+func (rr *RequestRetrier) schedule(r *RetryableRequest, err error) bool {
+	delay := rr.backoff(r)
+
+	r.retryAt = time.Now().Add(delay)
+	if exceedsDeadline(r.ctx, r.retryAt) {
+		return false
+	}
+
+	r.logRetry(delay)
+
+	rr.waitingMu.Lock()
+	select {
+	case <-rr.stop:
+		rr.waitingMu.Unlock()
+		return false
+	default:
+	}
+	heap.Push(&rr.waiting, r)
+	rr.waitingMu.Unlock()
+
+	select {
+	case rr.wake <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// backoff returns the delay before the next attempt of r. A delay given by the server through the Retry-After or
+// x-ratelimit-reset headers is honoured, otherwise the delay grows exponentially with the number of attempts.
+// Both get some jitter so that requests failing together do not all come back at the same time.
+func (rr *RequestRetrier) backoff(r *RetryableRequest) time.Duration {
+	if r.retryAfter > 0 {
+		return r.retryAfter + jitter(r.retryAfter/10)
+	}
+
+	delay := rr.initialDelay
+	attempts := atomic.LoadInt64(&r.retryCount)
+	for i := int64(1); i < attempts && delay < maxBackoff; i++ {
+		delay *= time.Duration(rr.backoffFactor)
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay/2 + jitter(delay/2)
+}
+
+// maxBackoff only guards against overflows, a backoff reaching it means the request should have given up long ago
+const maxBackoff = 24 * time.Hour
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// Attempts returns the number of times r was sent so far, retries included
+func (r *RetryableRequest) Attempts() int {
+	return int(atomic.LoadInt64(&r.retryCount))
+}
+
+func (rr *RequestRetrier) remove(r *RetryableRequest) {
+	rr.waitingMu.Lock()
+	defer rr.waitingMu.Unlock()
+
+	if r.index >= 0 {
+		heap.Remove(&rr.waiting, r.index)
+	}
+}
+
+func exceedsDeadline(ctx context.Context, t time.Time) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && t.After(deadline)
+}
+
+// retryQueue implements heap.Interface, the request with the earliest retry time comes first
+type retryQueue []*RetryableRequest
+
+func (q retryQueue) Len() int { return len(q) }
+
+func (q retryQueue) Less(i, j int) bool { return q[i].retryAt.Before(q[j].retryAt) }
+
+func (q retryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *retryQueue) Push(x any) {
+	r := x.(*RetryableRequest)
+	r.index = len(*q)
+	*q = append(*q, r)
+}
+
+func (q *retryQueue) Pop() any {
+	old := *q
+	n := len(old)
+	r := old[n-1]
+	old[n-1] = nil
+	r.index = -1
+	*q = old[:n-1]
+	return r
+}
+
 func (rr *RequestRetrier) requestnowait(r *RetryableRequest) error {
-	defer func() {
-		r.retryCount++
-	}()
+	defer atomic.AddInt64(&r.retryCount, 1)
+
+	r.retryAfter = 0
+	info := &RequestInfo{
+		Method:  r.Method,
+		URL:     r.URL,
+		Attempt: int(atomic.LoadInt64(&r.retryCount)) + 1,
+	}
+	defer r.setInfo(info)
+
+	if r.BeforeSend != nil {
+		err := r.BeforeSend(r.ctx)
+		if err != nil {
+			info.Err = err
+			return err
+		}
+	}
+
+	if r.Hooks != nil && r.Hooks.BeforeSend != nil {
+		r.Hooks.BeforeSend(r.ctx, info)
+	}
+
+	start := time.Now()
+	err := r.send(info)
+	info.Latency = time.Since(start)
+	info.Err = err
+
+	if r.Hooks != nil && r.Hooks.AfterResponse != nil {
+		r.Hooks.AfterResponse(r.ctx, info)
+	}
+
+	return err
+}
+
+// setInfo records the last attempt of r, unless the caller of Request already gave up on it
+func (r *RetryableRequest) setInfo(info *RequestInfo) {
+	r.infoMu.Lock()
+	defer r.infoMu.Unlock()
+
+	if !r.abandoned {
+		r.info = info
+	}
+}
+
+func (r *RetryableRequest) send(info *RequestInfo) error {
 	var err error
 
 	var jsbody []byte
+	contentType := "application/json"
 	if r.Body != nil {
 		switch t := r.Body.(type) {
 		case nil:
+		case *MultipartForm:
+			jsbody, contentType, err = t.encode()
+			if err != nil {
+				return fmt.Errorf("multipart body: %v", err)
+			}
 		case io.Reader:
-			jsbody, err = io.ReadAll(t)
-			if err != nil && err != io.EOF {
-				return err
+			// A reader can only be consumed once, we keep its content for the retries
+			if r.readerBody == nil {
+				r.readerBody, err = io.ReadAll(t)
+				if err != nil && err != io.EOF {
+					return err
+				}
 			}
+			jsbody = r.readerBody
 		default:
 			jsbody, err = json.Marshal(t)
 			if err != nil {
@@ -165,16 +475,16 @@ func (rr *RequestRetrier) requestnowait(r *RetryableRequest) error {
 	var req *http.Request
 
 	if jsbody != nil {
-		req, err = http.NewRequest(r.Method, r.URL, bytes.NewReader(jsbody))
+		req, err = http.NewRequestWithContext(r.ctx, r.Method, r.URL, bytes.NewReader(jsbody))
 	} else {
-		req, err = http.NewRequest(r.Method, r.URL, nil)
+		req, err = http.NewRequestWithContext(r.ctx, r.Method, r.URL, nil)
 	}
 	if err != nil {
 		return fmt.Errorf("http request: %v", err)
 	}
 
 	if len(jsbody) > 0 {
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", contentType)
 		req.Header.Set("Content-Length", strconv.Itoa(len(jsbody)))
 	}
 
@@ -188,19 +498,63 @@ func (rr *RequestRetrier) requestnowait(r *RetryableRequest) error {
 		}
 	}
 
-	req.Header.Set("Accept", "application/json")
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
 
 	if req.Header.Get("User-Agent") == "" {
 		req.Header.Set("User-Agent", "github.com/arthurweinmann/go-ai-sdk")
 	}
 
-	resp, err := (&http.Client{
-		Timeout: r.HTTPTimeout,
-	}).Do(req)
+	client := r.httpClient()
+
+	// The timeout of an http.Client covers reading the body, which is the whole stream for the callers reading
+	// the response themselves, so the timeout only applies until the headers are received for them
+	_, streaming := r.Response.(*http.Response)
+	var cancel context.CancelFunc
+	var headerTimer *time.Timer
+	if streaming {
+		noTimeout := *client
+		noTimeout.Timeout = 0
+		client = &noTimeout
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(r.ctx)
+		req = req.WithContext(ctx)
+		if r.HTTPTimeout > 0 {
+			headerTimer = time.AfterFunc(r.HTTPTimeout, cancel)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if headerTimer != nil && !headerTimer.Stop() && r.ctx.Err() == nil {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return fmt.Errorf("http request: %w: no response headers after %v", ErrTimeout, r.HTTPTimeout)
+	}
 	if err != nil {
-		return fmt.Errorf("http request: %v", err)
+		if cancel != nil {
+			cancel()
+		}
+		return transportError(err)
+	}
+	closeBody := true
+	defer func() {
+		if closeBody {
+			resp.Body.Close()
+			if cancel != nil {
+				cancel()
+			}
+		}
+	}()
+
+	info.StatusCode = resp.StatusCode
+
+	if r.OnResponse != nil {
+		r.OnResponse(resp.StatusCode, resp.Header)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
 		b, errReadBody := io.ReadAll(resp.Body)
@@ -208,15 +562,67 @@ func (rr *RequestRetrier) requestnowait(r *RetryableRequest) error {
 			err = fmt.Errorf("We received %v and could not read the request's body: %v", err, errReadBody)
 		}
 
-		err = r.ParseErrBody(b, err, resp.StatusCode, r)
+		r.retryAfter = retryAfter(resp.Header, time.Now())
+
+		err = r.ParseErrBody(b, err, resp.StatusCode, resp.Header, r)
 
 		return err
 	}
 
+	// The caller takes ownership of the response body, for example to read a stream of server-sent events,
+	// and is responsible for closing it
+	if t, ok := r.Response.(*http.Response); ok {
+		*t = *resp
+		t.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		closeBody = false
+		return nil
+	}
+
+	// Responses which are not JSON, such as subtitles, are kept as is
+	if t, ok := r.Response.(*[]byte); ok {
+		*t, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("read response: %v", err)
+		}
+		return nil
+	}
+
 	err = json.NewDecoder(resp.Body).Decode(r.Response)
 	if err != nil {
 		return fmt.Errorf("unmarshal response: %v", err)
 	}
 
+	if r.ExtractUsage != nil {
+		info.Usage = r.ExtractUsage(r.Response)
+	}
+
 	return nil
 }
+
+// cancelOnClose releases the context of a streamed response once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (r *RetryableRequest) httpClient() *http.Client {
+	if r.HTTPClient == nil {
+		return &http.Client{
+			Timeout: r.HTTPTimeout,
+		}
+	}
+
+	if r.HTTPTimeout == 0 || r.HTTPClient.Timeout == r.HTTPTimeout {
+		return r.HTTPClient
+	}
+
+	c := *r.HTTPClient
+	c.Timeout = r.HTTPTimeout
+	return &c
+}
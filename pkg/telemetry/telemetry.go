@@ -0,0 +1,274 @@
+// Package telemetry emits the OpenTelemetry spans and metrics of the calls made by the sdk. It uses the global
+// tracer and meter providers, which are no-op until the application sets its own with otel.SetTracerProvider
+// and otel.SetMeterProvider.
+//
+// Calls to generative AI models follow the GenAI semantic conventions, with gen_ai.usage.cost and
+// gen_ai.client.cost added for the price in dollars computed by the sdk. They are also recorded in the
+// usage.Tracker of their context, if any.
+package telemetry
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/usage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/arthurweinmann/go-ai-sdk"
+
+const (
+	AttrSystem        = attribute.Key("gen_ai.system")
+	AttrOperation     = attribute.Key("gen_ai.operation.name")
+	AttrRequestModel  = attribute.Key("gen_ai.request.model")
+	AttrResponseModel = attribute.Key("gen_ai.response.model")
+	AttrInputTokens   = attribute.Key("gen_ai.usage.input_tokens")
+	AttrOutputTokens  = attribute.Key("gen_ai.usage.output_tokens")
+	AttrFinishReasons = attribute.Key("gen_ai.response.finish_reasons")
+	AttrTokenType     = attribute.Key("gen_ai.token.type")
+	AttrCost          = attribute.Key("gen_ai.usage.cost")
+	AttrResendCount   = attribute.Key("http.request.resend_count")
+	AttrErrorType     = attribute.Key("error.type")
+	AttrToolName      = attribute.Key("gen_ai.tool.name")
+	AttrToolCallID    = attribute.Key("gen_ai.tool.call.id")
+
+	AttrHTTPMethod     = attribute.Key("http.request.method")
+	AttrHTTPStatusCode = attribute.Key("http.response.status_code")
+	AttrURL            = attribute.Key("url.full")
+	AttrServerAddress  = attribute.Key("server.address")
+	AttrPeerService    = attribute.Key("peer.service")
+)
+
+var instruments struct {
+	once sync.Once
+
+	operationDuration metric.Float64Histogram
+	tokenUsage        metric.Int64Histogram
+	cost              metric.Float64Counter
+	httpDuration      metric.Float64Histogram
+}
+
+func initInstruments() {
+	instruments.once.Do(func() {
+		// The global meter provider forwards to the one set later by the application, if any
+		meter := otel.Meter(instrumentationName)
+
+		var err error
+		instruments.operationDuration, err = meter.Float64Histogram("gen_ai.client.operation.duration",
+			metric.WithUnit("s"), metric.WithDescription("Duration of the calls to generative AI models, retries included"))
+		if err != nil {
+			otel.Handle(err)
+		}
+		instruments.tokenUsage, err = meter.Int64Histogram("gen_ai.client.token.usage",
+			metric.WithUnit("{token}"), metric.WithDescription("Number of input and output tokens used by each call"))
+		if err != nil {
+			otel.Handle(err)
+		}
+		instruments.cost, err = meter.Float64Counter("gen_ai.client.cost",
+			metric.WithUnit("USD"), metric.WithDescription("Price of the calls to generative AI models"))
+		if err != nil {
+			otel.Handle(err)
+		}
+		instruments.httpDuration, err = meter.Float64Histogram("http.client.request.duration",
+			metric.WithUnit("s"), metric.WithDescription("Duration of the HTTP calls to other APIs"))
+		if err != nil {
+			otel.Handle(err)
+		}
+	})
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// GenAISpan is the span of a call to a generative AI model. Set the fields known once the call returned, then call End.
+type GenAISpan struct {
+	span  trace.Span
+	start time.Time
+	attrs []attribute.KeyValue
+
+	ctx                      context.Context
+	system, operation, model string
+
+	ResponseModel string
+	InputTokens   int
+	OutputTokens  int
+	// Cost is the price of the call in dollars
+	Cost          float64
+	FinishReasons []string
+	// Attempts is the number of requests sent, retries included
+	Attempts int
+	// APIKey attributes the call to a key in the usage.Tracker of the context, it is not set on the span
+	APIKey string
+}
+
+// StartGenAI starts the span of a call, system is the provider such as openai or cohere and operation one of the
+// GenAI operation names such as chat, text_completion or embeddings
+func StartGenAI(ctx context.Context, system, operation, model string) (context.Context, *GenAISpan) {
+	initInstruments()
+
+	attrs := []attribute.KeyValue{
+		AttrSystem.String(system),
+		AttrOperation.String(operation),
+	}
+	if model != "" {
+		attrs = append(attrs, AttrRequestModel.String(model))
+	}
+
+	name := operation
+	if model != "" {
+		name += " " + model
+	}
+
+	ctx, span := tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+	return ctx, &GenAISpan{
+		span:      span,
+		start:     time.Now(),
+		attrs:     attrs,
+		ctx:       ctx,
+		system:    system,
+		operation: operation,
+		model:     model,
+	}
+}
+
+// End records the result of the call on the span, in the metrics and in the usage.Tracker of its context
+func (s *GenAISpan) End(err error) {
+	ctx := context.Background()
+	metricAttrs := s.attrs
+
+	if s.ResponseModel != "" {
+		s.span.SetAttributes(AttrResponseModel.String(s.ResponseModel))
+		metricAttrs = append(metricAttrs[:len(metricAttrs):len(metricAttrs)], AttrResponseModel.String(s.ResponseModel))
+	}
+	if s.Attempts > 1 {
+		s.span.SetAttributes(AttrResendCount.Int(s.Attempts - 1))
+	}
+
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+		s.span.SetAttributes(AttrErrorType.String("error"))
+		metricAttrs = append(metricAttrs[:len(metricAttrs):len(metricAttrs)], AttrErrorType.String("error"))
+	} else {
+		s.span.SetAttributes(
+			AttrInputTokens.Int(s.InputTokens),
+			AttrOutputTokens.Int(s.OutputTokens),
+			AttrCost.Float64(s.Cost),
+		)
+		if len(s.FinishReasons) > 0 {
+			s.span.SetAttributes(AttrFinishReasons.StringSlice(s.FinishReasons))
+		}
+
+		set := metric.WithAttributes(metricAttrs...)
+		if s.InputTokens > 0 {
+			instruments.tokenUsage.Record(ctx, int64(s.InputTokens), set, metric.WithAttributes(AttrTokenType.String("input")))
+		}
+		if s.OutputTokens > 0 {
+			instruments.tokenUsage.Record(ctx, int64(s.OutputTokens), set, metric.WithAttributes(AttrTokenType.String("output")))
+		}
+		if s.Cost > 0 {
+			instruments.cost.Add(ctx, s.Cost, set)
+		}
+
+		if tracker := usage.FromContext(s.ctx); tracker != nil {
+			tracker.Record(s.ctx, usage.Record{
+				Provider:     s.system,
+				Operation:    s.operation,
+				Model:        s.model,
+				APIKey:       s.APIKey,
+				InputTokens:  s.InputTokens,
+				OutputTokens: s.OutputTokens,
+				Cost:         s.Cost,
+			})
+		}
+	}
+
+	instruments.operationDuration.Record(ctx, time.Since(s.start).Seconds(), metric.WithAttributes(metricAttrs...))
+
+	s.span.End()
+}
+
+// ToolSpan is the span of the execution of a tool called by a model
+type ToolSpan struct {
+	span trace.Span
+}
+
+// StartTool starts the span of the execution of the tool name, callID is the id given by the model to the call
+func StartTool(ctx context.Context, name, callID string) (context.Context, *ToolSpan) {
+	attrs := []attribute.KeyValue{
+		AttrOperation.String("execute_tool"),
+		AttrToolName.String(name),
+	}
+	if callID != "" {
+		attrs = append(attrs, AttrToolCallID.String(callID))
+	}
+
+	ctx, span := tracer().Start(ctx, "execute_tool "+name, trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(attrs...))
+
+	return ctx, &ToolSpan{span: span}
+}
+
+// End records the error of the tool, if any
+func (s *ToolSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+		s.span.SetAttributes(AttrErrorType.String("error"))
+	}
+	s.span.End()
+}
+
+// HTTPSpan is the span of a call to an API which is not a generative AI model, for example Wikipedia
+type HTTPSpan struct {
+	span  trace.Span
+	start time.Time
+	attrs []attribute.KeyValue
+}
+
+// StartHTTP starts the span of an HTTP call to service, such as wikipedia or hackernews
+func StartHTTP(ctx context.Context, service, method, rawURL string) (context.Context, *HTTPSpan) {
+	initInstruments()
+
+	attrs := []attribute.KeyValue{
+		AttrPeerService.String(service),
+		AttrHTTPMethod.String(method),
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		attrs = append(attrs, AttrServerAddress.String(u.Hostname()))
+	}
+
+	ctx, span := tracer().Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...), trace.WithAttributes(AttrURL.String(rawURL)))
+
+	return ctx, &HTTPSpan{
+		span:  span,
+		start: time.Now(),
+		attrs: attrs,
+	}
+}
+
+// End records the result of the call, statusCode is 0 if we did not get a response
+func (s *HTTPSpan) End(statusCode int, err error) {
+	attrs := s.attrs
+	if statusCode > 0 {
+		attrs = append(attrs[:len(attrs):len(attrs)], AttrHTTPStatusCode.Int(statusCode))
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+		attrs = append(attrs[:len(attrs):len(attrs)], AttrErrorType.String("error"))
+	}
+
+	s.span.SetAttributes(attrs...)
+	instruments.httpDuration.Record(context.Background(), time.Since(s.start).Seconds(), metric.WithAttributes(attrs...))
+
+	s.span.End()
+}
@@ -0,0 +1,606 @@
+package uni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/openai"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/telemetry"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/usage"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/wcohere"
+)
+
+// Chatter sends chat completions to its providers in order, falling back to the next one when a provider fails
+type Chatter struct {
+	err       error
+	providers []ChatterOption
+}
+
+type ChatterOption interface {
+	ChatterOption()
+}
+
+type ChatRole string
+
+const (
+	ChatRoleSystem    ChatRole = "system"
+	ChatRoleUser      ChatRole = "user"
+	ChatRoleAssistant ChatRole = "assistant"
+	ChatRoleTool      ChatRole = "tool"
+)
+
+type ChatMessage struct {
+	Role    ChatRole `json:"role"`
+	Content string   `json:"content,omitempty"`
+	// Images of a user message, only supported by openai
+	Images []ChatImage `json:"images,omitempty"`
+	// ToolCalls of an assistant message
+	ToolCalls []ChatToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID is the ID of the call a tool message answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+type ChatImage struct {
+	// URL is either the URL of the image or a data URL with its base64 encoded content
+	URL string `json:"url"`
+	// Detail is auto, low or high
+	Detail string `json:"detail,omitempty"`
+}
+
+type ChatToolCall struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Arguments are encoded in JSON
+	Arguments string `json:"arguments"`
+}
+
+type ChatTool struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Parameters  *openai.JSONSchema `json:"parameters"`
+}
+
+// NewChatTool returns a tool whose parameters are the schema generated from the struct v, see openai.GenerateSchema
+func NewChatTool(name, description string, v any) (ChatTool, error) {
+	schema, err := openai.GenerateSchema(v)
+	if err != nil {
+		return ChatTool{}, err
+	}
+	return ChatTool{
+		Name:        name,
+		Description: description,
+		Parameters:  schema,
+	}, nil
+}
+
+// ChatRequest is sent to each provider in turn. Zero values leave the default of the provider.
+type ChatRequest struct {
+	Messages []ChatMessage `json:"messages"`
+	Tools    []ChatTool    `json:"tools,omitempty"`
+
+	// MaxTokens of openai also accepts the special values -1, -2 and -3, see withOpenAIOption
+	MaxTokens        int      `json:"max_tokens,omitempty"`
+	Temperature      float32  `json:"temperature,omitempty"`
+	TopP             float32  `json:"top_p,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+}
+
+type ChatFinishReason string
+
+const (
+	ChatFinishStop          ChatFinishReason = "stop"
+	ChatFinishLength        ChatFinishReason = "length"
+	ChatFinishToolCalls     ChatFinishReason = "tool_calls"
+	ChatFinishContentFilter ChatFinishReason = "content_filter"
+)
+
+type ChatUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type ChatResponse struct {
+	// Provider is the provider which answered
+	Provider     WithProviderOption `json:"-"`
+	Model        string             `json:"model"`
+	Message      ChatMessage        `json:"message"`
+	FinishReason ChatFinishReason   `json:"finish_reason"`
+	Usage        ChatUsage          `json:"usage"`
+
+	// Errors of the providers which failed before Provider answered
+	Errors []error `json:"-"`
+
+	// Price of the completion, 0 if the price of the model is unknown
+	Price float64 `json:"price,omitempty"`
+}
+
+// WithOpenAIChat uses GPT4o_Mini_128k if model is empty. The sampling parameters of the returned option
+// apply when those of the request are not set.
+func WithOpenAIChat(model openai.Model, apikeyOptional string) *withOpenAIOption {
+	return &withOpenAIOption{
+		APIKey: apikeyOptional,
+		Model:  model,
+	}
+}
+
+// WithCohereChat uses command-r if model is empty
+func WithCohereChat(model, apikeyOptional string) *withCohereOption {
+	return &withCohereOption{
+		APIKey: apikeyOptional,
+		Model:  model,
+	}
+}
+
+// NewChatter returns a Chatter trying the providers in the order of opts
+func NewChatter(opts ...ChatterOption) *Chatter {
+	ch := &Chatter{}
+
+	if len(opts) == 0 {
+		ch.err = fmt.Errorf("We need at least one provider of chat completions")
+		return ch
+	}
+
+	for i := 0; i < len(opts); i++ {
+		switch t := opts[i].(type) {
+		default:
+			ch.err = fmt.Errorf("%w: %T", ErrUnknownProvider, t)
+			return ch
+		case *withOpenAIOption:
+			ch.providers = append(ch.providers, t)
+		case *withCohereOption:
+			ch.providers = append(ch.providers, t)
+		}
+	}
+
+	return ch
+}
+
+// Chat sends req to the first provider and falls back to the next ones while they fail, unless ctx is done.
+// opts restricts the providers tried, all of them are tried if it is empty. The returned error joins the errors
+// of all the providers.
+func (ch *Chatter) Chat(ctx context.Context, req *ChatRequest, opts ...WithProviderOption) (*ChatResponse, error) {
+	if ch.err != nil {
+		return nil, ch.err
+	}
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("We need at least one message")
+	}
+
+	allowed := map[providerIden]bool{}
+	for i := 0; i < len(opts); i++ {
+		t, ok := opts[i].(providerIden)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrUnknownProvider, opts[i])
+		}
+		allowed[t] = true
+	}
+
+	var errs []error
+	for _, prov := range ch.providers {
+		var resp *ChatResponse
+		var err error
+
+		switch t := prov.(type) {
+		default:
+			return nil, fmt.Errorf("%w: %T", ErrUnknownProvider, t)
+		case *withOpenAIOption:
+			if len(allowed) > 0 && !allowed["openai"] {
+				continue
+			}
+			resp, err = openaiChat(ctx, t, req)
+			if err != nil {
+				err = fmt.Errorf("openai: %w", err)
+			}
+		case *withCohereOption:
+			if len(allowed) > 0 && !allowed["cohere"] {
+				continue
+			}
+			resp, err = cohereChat(ctx, t, req)
+			if err != nil {
+				err = fmt.Errorf("cohere: %w", err)
+			}
+		}
+
+		if err == nil {
+			resp.Errors = errs
+			return resp, nil
+		}
+
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("We have no provider of chat completions matching the options")
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func openaiChat(ctx context.Context, opt *withOpenAIOption, req *ChatRequest) (*ChatResponse, error) {
+	model := opt.Model
+	if model == "" {
+		model = openai.GPT4o_Mini_128k
+	}
+
+	oreq := &openai.ChatCompletionRequest{
+		APIKEY:           opt.APIKey,
+		Model:            model,
+		MaxTokens:        opt.MaxTokens,
+		Temperature:      opt.Temperature,
+		TopP:             opt.TopP,
+		Stop:             opt.Stop,
+		PresencePenalty:  opt.PresencePenalty,
+		FrequencyPenalty: opt.FrequencyPenalty,
+		LogitBias:        opt.LogitBias,
+	}
+	if req.MaxTokens != 0 {
+		oreq.MaxTokens = req.MaxTokens
+	}
+	if req.Temperature != 0 {
+		oreq.Temperature = req.Temperature
+	}
+	if req.TopP != 0 {
+		oreq.TopP = req.TopP
+	}
+	if len(req.Stop) > 0 {
+		oreq.Stop = req.Stop
+	}
+	if req.PresencePenalty != 0 {
+		oreq.PresencePenalty = req.PresencePenalty
+	}
+	if req.FrequencyPenalty != 0 {
+		oreq.FrequencyPenalty = req.FrequencyPenalty
+	}
+
+	for _, tool := range req.Tools {
+		oreq.Tools = append(oreq.Tools, openai.ChatCompletionToolCall{
+			Type: "function",
+			Function: &openai.ChatCompletionFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	for _, m := range req.Messages {
+		om := openai.ChatCompletionMessage{
+			Content:    m.Content,
+			ToolCallID: m.ToolCallID,
+		}
+		switch m.Role {
+		default:
+			return nil, fmt.Errorf("unknown role %s", m.Role)
+		case ChatRoleSystem:
+			om.Role = openai.System
+		case ChatRoleUser:
+			om.Role = openai.User
+		case ChatRoleAssistant:
+			om.Role = openai.Assistant
+		case ChatRoleTool:
+			om.Role = openai.Tool
+		}
+
+		if len(m.Images) > 0 {
+			var parts []openai.ContentPart
+			if m.Content != "" {
+				parts = append(parts, openai.NewTextPart(m.Content))
+			}
+			for _, image := range m.Images {
+				parts = append(parts, openai.NewImagePart(image.URL, openai.ImageDetail(image.Detail)))
+			}
+			om.Content = parts
+		}
+
+		for _, tc := range m.ToolCalls {
+			om.ToolCalls = append(om.ToolCalls, &openai.ToolCall{
+				ID:   tc.ID,
+				Type: "function",
+				Function: &openai.Function{
+					Name:      tc.Name,
+					Arguments: tc.Arguments,
+				},
+			})
+		}
+		if len(m.ToolCalls) > 0 && m.Content == "" {
+			om.Content = nil
+		}
+
+		oreq.Messages = append(oreq.Messages, om)
+	}
+
+	oresp, err := openai.CreateChatCompletion(ctx, oreq)
+	if err != nil {
+		return nil, err
+	}
+	if len(oresp.Choices) == 0 {
+		return nil, fmt.Errorf("the response has no choice")
+	}
+
+	choice := oresp.Choices[0]
+	resp := &ChatResponse{
+		Provider: WithOpenAI(),
+		Model:    oresp.Model,
+		Message: ChatMessage{
+			Role: ChatRoleAssistant,
+		},
+		FinishReason: ChatFinishReason(choice.FinishReason),
+		Usage: ChatUsage{
+			InputTokens:  oresp.Usage.PromptTokens,
+			OutputTokens: oresp.Usage.CompletionTokens,
+		},
+		Price: oresp.Price,
+	}
+	resp.Message.Content, _ = choice.Message.Content.(string)
+	if resp.Message.Content == "" && choice.Message.Refusal != "" {
+		resp.Message.Content = choice.Message.Refusal
+	}
+	for _, tc := range choice.Message.ToolCalls {
+		if tc.Function == nil {
+			continue
+		}
+		resp.Message.ToolCalls = append(resp.Message.ToolCalls, ChatToolCall{
+			ID:        tc.ID,
+			Name:      tc.Function.Name,
+			Arguments: tc.Function.Arguments,
+		})
+	}
+
+	return resp, nil
+}
+
+func cohereChat(ctx context.Context, opt *withCohereOption, req *ChatRequest) (resp *ChatResponse, err error) {
+	model := opt.Model
+	if model == "" {
+		model = wcohere.CommandR
+	}
+
+	creq, err := toCohereChatRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	creq.Model = model
+
+	if tracker := usage.FromContext(ctx); tracker != nil {
+		estimate, _ := wcohere.GetChatRequestPrice(model, estimateCohereTokens(chatTexts(req.Messages)), positive(req.MaxTokens))
+		err = tracker.Check(ctx, usage.Record{
+			Provider:  "cohere",
+			Operation: "chat",
+			Model:     model,
+			APIKey:    opt.APIKey,
+			Cost:      estimate,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, span := telemetry.StartGenAI(ctx, "cohere", "chat", model)
+	span.APIKey = opt.APIKey
+	defer func() {
+		span.End(err)
+	}()
+
+	cresp, err := wcohere.Chat(ctx, opt.APIKey, creq)
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &ChatResponse{
+		Provider: WithCohere(),
+		Model:    model,
+		Message: ChatMessage{
+			Role:    ChatRoleAssistant,
+			Content: cresp.Text,
+		},
+	}
+
+	for i, tc := range cresp.ToolCalls {
+		arguments, err := json.Marshal(tc.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		resp.Message.ToolCalls = append(resp.Message.ToolCalls, ChatToolCall{
+			// Cohere does not identify tool calls
+			ID:        fmt.Sprintf("%s-%d", cresp.GenerationID, i),
+			Name:      tc.Name,
+			Arguments: string(arguments),
+		})
+	}
+
+	switch {
+	case len(resp.Message.ToolCalls) > 0:
+		resp.FinishReason = ChatFinishToolCalls
+	case cresp.FinishReason == "COMPLETE":
+		resp.FinishReason = ChatFinishStop
+	case cresp.FinishReason == "MAX_TOKENS":
+		resp.FinishReason = ChatFinishLength
+	case cresp.FinishReason == "ERROR_TOXIC":
+		resp.FinishReason = ChatFinishContentFilter
+	default:
+		resp.FinishReason = ChatFinishReason(strings.ToLower(cresp.FinishReason))
+	}
+
+	if cresp.Meta != nil && cresp.Meta.BilledUnits != nil {
+		resp.Usage.InputTokens = int(cresp.Meta.BilledUnits.InputTokens)
+		resp.Usage.OutputTokens = int(cresp.Meta.BilledUnits.OutputTokens)
+		resp.Price, _ = wcohere.GetChatRequestPrice(model, resp.Usage.InputTokens, resp.Usage.OutputTokens)
+	}
+
+	span.InputTokens = resp.Usage.InputTokens
+	span.OutputTokens = resp.Usage.OutputTokens
+	span.Cost = resp.Price
+	span.FinishReasons = []string{string(resp.FinishReason)}
+
+	return resp, nil
+}
+
+// toCohereChatRequest moves the system messages to the preamble, and the last user message, or the last tool
+// results, out of the chat history
+func toCohereChatRequest(req *ChatRequest) (*wcohere.ChatRequest, error) {
+	creq := &wcohere.ChatRequest{
+		Temperature:      float64(req.Temperature),
+		MaxTokens:        positive(req.MaxTokens),
+		P:                float64(req.TopP),
+		StopSequences:    req.Stop,
+		FrequencyPenalty: float64(req.FrequencyPenalty),
+		PresencePenalty:  float64(req.PresencePenalty),
+	}
+
+	for _, tool := range req.Tools {
+		creq.Tools = append(creq.Tools, wcohere.Tool{
+			Name:                 tool.Name,
+			Description:          tool.Description,
+			ParameterDefinitions: cohereParameterDefinitions(tool.Parameters),
+		})
+	}
+
+	last := len(req.Messages) - 1
+	for last >= 0 && req.Messages[last].Role == ChatRoleTool {
+		last--
+	}
+	if last == len(req.Messages)-1 && req.Messages[last].Role != ChatRoleUser {
+		return nil, fmt.Errorf("the last message must be from the user or a tool")
+	}
+
+	var preamble []string
+	calls := map[string]wcohere.ToolCall{}
+	var history []wcohere.ChatMessage
+
+	for i, m := range req.Messages {
+		if len(m.Images) > 0 {
+			return nil, fmt.Errorf("cohere does not support images")
+		}
+
+		switch m.Role {
+		default:
+			return nil, fmt.Errorf("unknown role %s", m.Role)
+		case ChatRoleSystem:
+			preamble = append(preamble, m.Content)
+		case ChatRoleUser:
+			if i == len(req.Messages)-1 {
+				creq.Message = m.Content
+				continue
+			}
+			history = append(history, wcohere.ChatMessage{Role: wcohere.ChatRoleUser, Message: m.Content})
+		case ChatRoleAssistant:
+			cm := wcohere.ChatMessage{Role: wcohere.ChatRoleChatbot, Message: m.Content}
+			for _, tc := range m.ToolCalls {
+				call := wcohere.ToolCall{Name: tc.Name, Parameters: map[string]interface{}{}}
+				if tc.Arguments != "" {
+					err := json.Unmarshal([]byte(tc.Arguments), &call.Parameters)
+					if err != nil {
+						return nil, fmt.Errorf("invalid arguments of tool call %s: %v", tc.ID, err)
+					}
+				}
+				calls[tc.ID] = call
+				cm.ToolCalls = append(cm.ToolCalls, call)
+			}
+			history = append(history, cm)
+		case ChatRoleTool:
+			call, ok := calls[m.ToolCallID]
+			if !ok {
+				return nil, fmt.Errorf("the tool message answers an unknown tool call %s", m.ToolCallID)
+			}
+			result := wcohere.ToolResult{Call: call, Outputs: []map[string]interface{}{toolOutput(m.Content)}}
+
+			if i > last {
+				creq.ToolResults = append(creq.ToolResults, result)
+				continue
+			}
+			if n := len(history); n > 0 && history[n-1].Role == wcohere.ChatRoleTool {
+				history[n-1].ToolResults = append(history[n-1].ToolResults, result)
+				continue
+			}
+			history = append(history, wcohere.ChatMessage{Role: wcohere.ChatRoleTool, ToolResults: []wcohere.ToolResult{result}})
+		}
+	}
+
+	creq.Preamble = strings.Join(preamble, "\n\n")
+	creq.ChatHistory = history
+
+	return creq, nil
+}
+
+// toolOutput returns the JSON object of a tool output, or wraps the output in one
+func toolOutput(content string) map[string]interface{} {
+	var output map[string]interface{}
+	if json.Unmarshal([]byte(content), &output) == nil && output != nil {
+		return output
+	}
+	return map[string]interface{}{"result": content}
+}
+
+// cohereParameterDefinitions converts the properties of an object schema, nested schemas are described by their type
+func cohereParameterDefinitions(schema *openai.JSONSchema) map[string]wcohere.ToolParameterDefinition {
+	if schema == nil || len(schema.Properties) == 0 {
+		return nil
+	}
+
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+
+	definitions := make(map[string]wcohere.ToolParameterDefinition, len(schema.Properties))
+	for name, property := range schema.Properties {
+		definition := wcohere.ToolParameterDefinition{
+			Description: property.Description,
+			Required:    required[name],
+		}
+
+		// The optional properties of strict schemas are required and nullable
+		for _, s := range property.AnyOf {
+			if s.Type == "null" {
+				definition.Required = false
+			} else {
+				property = s
+			}
+		}
+
+		switch property.Type {
+		case "string":
+			definition.Type = "str"
+		case "integer":
+			definition.Type = "int"
+		case "number":
+			definition.Type = "float"
+		case "boolean":
+			definition.Type = "bool"
+		case "array":
+			definition.Type = "list"
+		default:
+			definition.Type = "dict"
+		}
+		if definition.Description == "" {
+			definition.Description = property.Description
+		}
+
+		definitions[name] = definition
+	}
+
+	return definitions
+}
+
+func chatTexts(messages []ChatMessage) []string {
+	texts := make([]string, 0, len(messages))
+	for _, m := range messages {
+		texts = append(texts, m.Content)
+	}
+	return texts
+}
+
+func positive(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
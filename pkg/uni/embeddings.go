@@ -9,6 +9,9 @@ import (
 	"sync"
 
 	"github.com/arthurweinmann/go-ai-sdk/pkg/openai"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/openai/tokenizer"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/telemetry"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/usage"
 	"github.com/arthurweinmann/go-ai-sdk/pkg/wcohere"
 	api "github.com/cohere-ai/cohere-go/v2"
 	cohere "github.com/cohere-ai/cohere-go/v2"
@@ -17,12 +20,12 @@ import (
 
 type Embedder struct {
 	err       error
-	providers []EmbedderOption
+	providers []EmbeddingProvider
 }
 
 type SingleProviderEmbedder struct {
-	err error
-	opt EmbedderOption
+	err      error
+	provider EmbeddingProvider
 }
 
 type Embedding struct {
@@ -30,13 +33,20 @@ type Embedding struct {
 
 	byprovider32 map[string][]float32
 	byprovider64 map[string][]float64
+	// models by provider, when known, see ModelEmbeddingProvider
+	models map[string]string
 }
 
 type SingleProviderEmbedding struct {
+	provider string
+	model    string
+
 	v32 []float32
 	v64 []float64
 }
 
+// EmbedderOption is either an EmbeddingProvider, such as WithOpenAIEmbed, or the WithProviderOption of a
+// registered provider, such as WithProvider("my-provider")
 type EmbedderOption interface {
 	EmbedderOption()
 }
@@ -57,6 +67,21 @@ func WithCohereEmbed(model, truncate, inputType, apikeyOptional string) *withCoh
 	}
 }
 
+// embeddingProvider resolves an option into a provider, whose name must be registered
+func embeddingProvider(opt EmbedderOption) (EmbeddingProvider, error) {
+	switch t := opt.(type) {
+	case providerIden:
+		return LookupEmbeddingProvider(string(t))
+	case EmbeddingProvider:
+		_, err := LookupEmbeddingProvider(t.Name())
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnknownProvider, opt)
+}
+
 func NewEmbedder(opts ...EmbedderOption) *Embedder {
 	emb := &Embedder{}
 
@@ -66,14 +91,12 @@ func NewEmbedder(opts ...EmbedderOption) *Embedder {
 	}
 
 	for i := 0; i < len(opts); i++ {
-		switch t := opts[i].(type) {
-		default:
-			panic(fmt.Errorf("Should not happen: %T", t))
-		case *withOpenAIOption:
-			emb.providers = append(emb.providers, t)
-		case *withCohereOption:
-			emb.providers = append(emb.providers, t)
+		p, err := embeddingProvider(opts[i])
+		if err != nil {
+			emb.err = err
+			return emb
 		}
+		emb.providers = append(emb.providers, p)
 	}
 
 	return emb
@@ -86,30 +109,13 @@ func NewSingleProviderEmbedder(opts ...EmbedderOption) *SingleProviderEmbedder {
 		emb.err = fmt.Errorf("We need one provider of embeddings")
 		return emb
 	}
-
-	var providerset bool
-
-	for i := 0; i < len(opts); i++ {
-		switch t := opts[i].(type) {
-		default:
-			panic(fmt.Errorf("Should not happen: %T", t))
-		case *withOpenAIOption:
-			emb.opt = t
-			if providerset {
-				emb.err = fmt.Errorf("We support only one provider of embeddings for a SingleProviderEmbedder")
-				return emb
-			}
-			providerset = true
-		case *withCohereOption:
-			emb.opt = t
-			if providerset {
-				emb.err = fmt.Errorf("We support only one provider of embeddings for a SingleProviderEmbedder")
-				return emb
-			}
-			providerset = true
-		}
+	if len(opts) > 1 {
+		emb.err = fmt.Errorf("We support only one provider of embeddings for a SingleProviderEmbedder")
+		return emb
 	}
 
+	emb.provider, emb.err = embeddingProvider(opts[0])
+
 	return emb
 }
 
@@ -117,6 +123,7 @@ func NewEmbedding() *Embedding {
 	return &Embedding{
 		byprovider32: map[string][]float32{},
 		byprovider64: map[string][]float64{},
+		models:       map[string]string{},
 	}
 }
 
@@ -130,57 +137,33 @@ func (emb *Embedding) FirstError() error {
 }
 
 func (emb *Embedding) ByProviderError(provider WithProviderOption) error {
-	switch t := provider.(type) {
-	default:
-		panic(fmt.Errorf("Should not happen: %T", t))
-	case providerIden:
-		switch t {
-		default:
-			panic(fmt.Errorf("Should not happen: %s", t))
-		case "openai", "cohere":
-			return emb.errByProvider[string(t)]
-		}
+	name, err := providerName(provider)
+	if err != nil {
+		return err
 	}
+	return emb.errByProvider[name]
 }
 
-func EmbeddingFrom(provider WithProviderOption, vector []float64) *Embedding {
+func EmbeddingFrom(provider WithProviderOption, vector []float64) (*Embedding, error) {
 	emb := NewEmbedding()
 
-	switch t := provider.(type) {
-	default:
-		panic(fmt.Errorf("Should not happen: %T", t))
-	case providerIden:
-		switch t {
-		default:
-			panic(fmt.Errorf("Should not happen: %s", t))
-		case "openai":
-			emb.byprovider32["openai"] = Float64ToFloat32(vector)
-		case "cohere":
-			emb.byprovider64["cohere"] = vector
-		}
+	err := emb.SetByProvider(provider, vector)
+	if err != nil {
+		return nil, err
 	}
 
-	return emb
+	return emb, nil
 }
 
-func EmbeddingFrom32(provider WithProviderOption, vector []float32) *Embedding {
+func EmbeddingFrom32(provider WithProviderOption, vector []float32) (*Embedding, error) {
 	emb := NewEmbedding()
 
-	switch t := provider.(type) {
-	default:
-		panic(fmt.Errorf("Should not happen: %T", t))
-	case providerIden:
-		switch t {
-		default:
-			panic(fmt.Errorf("Should not happen: %s", t))
-		case "openai":
-			emb.byprovider32["openai"] = vector
-		case "cohere":
-			emb.byprovider64["cohere"] = Float32ToFloat64(vector)
-		}
+	err := emb.SetByProvider32(provider, vector)
+	if err != nil {
+		return nil, err
 	}
 
-	return emb
+	return emb, nil
 }
 
 func (emb *Embedding) ToSingleProvider() (*SingleProviderEmbedding, error) {
@@ -190,18 +173,22 @@ func (emb *Embedding) ToSingleProvider() (*SingleProviderEmbedding, error) {
 
 	s := NewSingleProviderEmbedding()
 
-	for _, v := range emb.byprovider32 {
+	for name, v := range emb.byprovider32 {
+		s.provider = name
 		s.v32 = v
 		s.v64 = nil
 		break
 	}
 
-	for _, v := range emb.byprovider64 {
+	for name, v := range emb.byprovider64 {
+		s.provider = name
 		s.v32 = nil
 		s.v64 = v
 		break
 	}
 
+	s.model = emb.models[s.provider]
+
 	return s, nil
 }
 
@@ -225,61 +212,83 @@ func SingleProviderEmbeddingFrom32(v []float32) *SingleProviderEmbedding {
 	return s
 }
 
-func (s *SingleProviderEmbedding) ToEmbedding(provider WithProviderOption) *Embedding {
-	t, ok := provider.(providerIden)
-	if !ok {
-		panic(fmt.Errorf("Should not happen: %T", provider))
+func (s *SingleProviderEmbedding) ToEmbedding(provider WithProviderOption) (*Embedding, error) {
+	ret := NewEmbedding()
+
+	var err error
+	if len(s.v32) > 0 {
+		err = ret.SetByProvider32(provider, s.v32)
+	} else {
+		err = ret.SetByProvider(provider, s.v64)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.model != "" {
+		name, _ := providerName(provider)
+		ret.models[name] = s.model
 	}
 
-	ret := NewEmbedding()
+	return ret, nil
+}
 
-	switch t {
-	default:
-		panic(fmt.Errorf("Should not happen: %s", t))
-	case "openai":
-		if len(s.v32) > 0 {
-			ret.byprovider32["openai"] = s.v32
-		} else {
-			ret.byprovider32["openai"] = Float64ToFloat32(s.v64)
-		}
-	case "cohere":
-		if len(s.v32) > 0 {
-			ret.byprovider64["cohere"] = Float32ToFloat64(s.v32)
-		} else {
-			ret.byprovider64["cohere"] = s.v64
+// Provider returns the name of the provider of the vector, when known
+func (s *SingleProviderEmbedding) Provider() string {
+	return s.provider
+}
+
+// Model returns the model which computed the vector, when known
+func (s *SingleProviderEmbedding) Model() string {
+	return s.model
+}
+
+// Model returns the model which computed the vector of a provider, when known
+func (emb *Embedding) Model(provider WithProviderOption) string {
+	name, err := providerName(provider)
+	if err != nil {
+		return ""
+	}
+	return emb.models[name]
+}
+
+func embeddingModel(p EmbeddingProvider) string {
+	if mp, ok := p.(ModelEmbeddingProvider); ok {
+		return mp.EmbeddingModel()
+	}
+	return ""
+}
+
+// embedBatch embeds a batch of at most MaxBatchSize texts, in float32 if the provider supports it
+func embedBatch(ctx context.Context, p EmbeddingProvider, batch []string) ([][]float32, [][]float64, error) {
+	if p32, ok := p.(Float32EmbeddingProvider); ok {
+		vectors, err := p32.Embed32(ctx, batch)
+		if err == nil && len(vectors) != len(batch) {
+			err = fmt.Errorf("We got %d embeddings from provider %s for %d texts", len(vectors), p.Name(), len(batch))
 		}
+		return vectors, nil, err
 	}
 
-	return ret
+	vectors, err := p.Embed(ctx, batch)
+	if err == nil && len(vectors) != len(batch) {
+		err = fmt.Errorf("We got %d embeddings from provider %s for %d texts", len(vectors), p.Name(), len(batch))
+	}
+	return nil, vectors, err
 }
 
-func (m *Embedder) BatchEmbed(texts []string, opts ...WithProviderOption) ([]*Embedding, error) {
+// BatchEmbed embeds texts with each provider in parallel, opts restricts the providers used. The error of a
+// provider is set on the embeddings of the texts it failed to embed, see Embedding.FirstError.
+func (m *Embedder) BatchEmbed(ctx context.Context, texts []string, opts ...WithProviderOption) ([]*Embedding, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
 
-	useOpenAI := true
-	useCohere := true
-
-	if len(opts) > 0 {
-		useOpenAI = false
-		useCohere = false
-
-		for i := 0; i < len(opts); i++ {
-			switch t := opts[i].(type) {
-			default:
-				panic(fmt.Errorf("Should not happen: %T", t))
-			case providerIden:
-				switch t {
-				default:
-					panic(fmt.Errorf("Should not happen: %s", t))
-				case "openai":
-					useOpenAI = true
-				case "cohere":
-					useCohere = true
-				}
-			}
+	use := map[string]bool{}
+	for i := 0; i < len(opts); i++ {
+		name, err := providerName(opts[i])
+		if err != nil {
+			return nil, err
 		}
+		use[name] = true
 	}
 
 	ret := make([]*Embedding, len(texts))
@@ -287,6 +296,7 @@ func (m *Embedder) BatchEmbed(texts []string, opts ...WithProviderOption) ([]*Em
 		ret[i] = &Embedding{
 			byprovider32:  map[string][]float32{},
 			byprovider64:  map[string][]float64{},
+			models:        map[string]string{},
 			errByProvider: map[string]error{},
 		}
 	}
@@ -295,9 +305,13 @@ func (m *Embedder) BatchEmbed(texts []string, opts ...WithProviderOption) ([]*Em
 	var mu sync.Mutex
 
 	for _, prov := range m.providers {
+		if len(use) > 0 && !use[prov.Name()] {
+			continue
+		}
 
-		for k := 0; k < len(texts); k += 50 {
-			l := k + 50
+		size := prov.MaxBatchSize()
+		for k := 0; k < len(texts); k += size {
+			l := k + size
 			var tmpbatch []string
 			if l < len(texts) {
 				tmpbatch = texts[k:l]
@@ -305,87 +319,30 @@ func (m *Embedder) BatchEmbed(texts []string, opts ...WithProviderOption) ([]*Em
 				tmpbatch = texts[k:]
 			}
 
-			switch t := prov.(type) {
-			default:
-				panic(fmt.Errorf("Should not happen: %T", t))
-			case *withOpenAIOption:
-				if useOpenAI {
-					wg.Add(1)
-					go func(kindex int, batch []string) {
-						defer wg.Done()
-						resp, err := openai.CreateEmbedding(&openai.EmbeddingRequest{
-							APIKEY: t.APIKey,
-							Model:  t.Model,
-							Input:  batch,
-						})
-						mu.Lock()
-						defer mu.Unlock()
-						if err != nil {
-							for i := 0; i < len(resp.Data); i++ {
-								ret[kindex+resp.Data[i].Index].errByProvider["openai"] = err
-							}
-							return
-						}
-						for i := 0; i < len(resp.Data); i++ {
-							ret[kindex+resp.Data[i].Index].byprovider32["openai"] = resp.Data[i].Embedding
-						}
-					}(k, tmpbatch)
+			wg.Add(1)
+			go func(prov EmbeddingProvider, kindex int, batch []string) {
+				defer wg.Done()
+				vectors32, vectors64, err := embedBatch(ctx, prov, batch)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					for i := 0; i < len(batch); i++ {
+						ret[kindex+i].errByProvider[prov.Name()] = err
+					}
+					return
 				}
-			case *withCohereOption:
-				if useCohere {
-					wg.Add(1)
-					go func(kindex int, batch []string) {
-						defer wg.Done()
-						var client *cohereclient.Client
-						if t.APIKey != "" {
-							var err error
-							client, err = wcohere.NewClient(t.APIKey)
-							if err != nil {
-								mu.Lock()
-								defer mu.Unlock()
-								for i := 0; i < len(batch); i++ {
-									ret[kindex+i].errByProvider["cohere"] = err
-								}
-								return
-							}
-						} else {
-							client = wcohere.DefaultClient
-							if client == nil {
-								mu.Lock()
-								defer mu.Unlock()
-								for i := 0; i < len(batch); i++ {
-									ret[kindex+i].errByProvider["cohere"] = fmt.Errorf("Cohere: we did not get an apikey for this request nor is a default client initialized")
-								}
-								return
-							}
-						}
-						params := &cohere.EmbedRequest{
-							Model: &t.Model,
-							Texts: batch,
-						}
-						truncateOpt := cohere.EmbedRequestTruncate(t.Truncate)
-						if truncateOpt != "" {
-							params.Truncate = &truncateOpt
-						}
-						inputTypeOpt := api.EmbedInputType(t.InputType)
-						if inputTypeOpt != "" {
-							params.InputType = &inputTypeOpt
-						}
-						resp, err := client.Embed(context.Background(), params)
-						mu.Lock()
-						defer mu.Unlock()
-						if err != nil {
-							for i := 0; i < len(batch); i++ {
-								ret[kindex+i].errByProvider["cohere"] = err
-							}
-							return
-						}
-						for i := 0; i < len(resp.EmbeddingsFloats.Embeddings); i++ {
-							ret[kindex+i].byprovider64["cohere"] = resp.EmbeddingsFloats.Embeddings[i]
-						}
-					}(k, tmpbatch)
+				for i := 0; i < len(vectors32); i++ {
+					ret[kindex+i].byprovider32[prov.Name()] = vectors32[i]
 				}
-			}
+				for i := 0; i < len(vectors64); i++ {
+					ret[kindex+i].byprovider64[prov.Name()] = vectors64[i]
+				}
+				if model := embeddingModel(prov); model != "" {
+					for i := 0; i < len(batch); i++ {
+						ret[kindex+i].models[prov.Name()] = model
+					}
+				}
+			}(prov, k, tmpbatch)
 		}
 	}
 
@@ -394,18 +351,22 @@ func (m *Embedder) BatchEmbed(texts []string, opts ...WithProviderOption) ([]*Em
 	return ret, nil
 }
 
-func (m *SingleProviderEmbedder) BatchEmbed(texts []string, opts ...WithProviderOption) ([]*SingleProviderEmbedding, error) {
+func (m *SingleProviderEmbedder) BatchEmbed(ctx context.Context, texts []string, opts ...WithProviderOption) ([]*SingleProviderEmbedding, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
 
 	ret := make([]*SingleProviderEmbedding, len(texts))
 	for i := 0; i < len(ret); i++ {
-		ret[i] = &SingleProviderEmbedding{}
+		ret[i] = &SingleProviderEmbedding{
+			provider: m.provider.Name(),
+			model:    embeddingModel(m.provider),
+		}
 	}
 
-	for k := 0; k < len(texts); k += 50 {
-		l := k + 50
+	size := m.provider.MaxBatchSize()
+	for k := 0; k < len(texts); k += size {
+		l := k + size
 		var tmpbatch []string
 		if l < len(texts) {
 			tmpbatch = texts[k:l]
@@ -413,62 +374,85 @@ func (m *SingleProviderEmbedder) BatchEmbed(texts []string, opts ...WithProvider
 			tmpbatch = texts[k:]
 		}
 
-		switch t := m.opt.(type) {
-		default:
-			panic(fmt.Errorf("Should not happen: %T", t))
-		case *withOpenAIOption:
-			resp, err := openai.CreateEmbedding(&openai.EmbeddingRequest{
-				APIKEY: t.APIKey,
-				Model:  t.Model,
-				Input:  tmpbatch,
-			})
-			if err != nil {
-				return nil, err
-			}
-			for i := 0; i < len(resp.Data); i++ {
-				ret[k+resp.Data[i].Index].v32 = resp.Data[i].Embedding
-			}
-		case *withCohereOption:
-			var client *cohereclient.Client
-			if t.APIKey != "" {
-				var err error
-				client, err = wcohere.NewClient(t.APIKey)
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				client = wcohere.DefaultClient
-				if client == nil {
-					return nil, fmt.Errorf("Cohere: we did not get an apikey for this request nor is a default client initialized")
-				}
-			}
-			params := &cohere.EmbedRequest{
-				Model: &t.Model,
-				Texts: tmpbatch,
-			}
-			truncateOpt := cohere.EmbedRequestTruncate(t.Truncate)
-			if truncateOpt != "" {
-				params.Truncate = &truncateOpt
-			}
-			inputTypeOpt := api.EmbedInputType(t.InputType)
-			if inputTypeOpt != "" {
-				params.InputType = &inputTypeOpt
-			}
-			resp, err := client.Embed(context.Background(), params)
-			if err != nil {
-				return nil, err
-			}
-			for i := 0; i < len(resp.EmbeddingsFloats.Embeddings); i++ {
-				ret[k+i].v64 = resp.EmbeddingsFloats.Embeddings[i]
-			}
+		vectors32, vectors64, err := embedBatch(ctx, m.provider, tmpbatch)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(vectors32); i++ {
+			ret[k+i].v32 = vectors32[i]
+		}
+		for i := 0; i < len(vectors64); i++ {
+			ret[k+i].v64 = vectors64[i]
 		}
 	}
 
 	return ret, nil
 }
 
-func (m *Embedder) Embed(text string, opts ...WithProviderOption) (*Embedding, error) {
-	embs, err := m.BatchEmbed([]string{text}, opts...)
+func cohereEmbed(ctx context.Context, client *cohereclient.Client, opt *withCohereOption, batch []string) (embeddings [][]float64, err error) {
+	if tracker := usage.FromContext(ctx); tracker != nil {
+		err = tracker.Check(ctx, usage.Record{
+			Provider:  "cohere",
+			Operation: "embeddings",
+			Model:     opt.Model,
+			APIKey:    opt.APIKey,
+			Cost:      wcohere.GetEmbedRequestPrice(estimateCohereTokens(batch)),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, span := telemetry.StartGenAI(ctx, "cohere", "embeddings", opt.Model)
+	span.APIKey = opt.APIKey
+	defer func() {
+		span.End(err)
+	}()
+
+	params := &cohere.EmbedRequest{
+		Model: &opt.Model,
+		Texts: batch,
+	}
+	truncateOpt := cohere.EmbedRequestTruncate(opt.Truncate)
+	if truncateOpt != "" {
+		params.Truncate = &truncateOpt
+	}
+	inputTypeOpt := api.EmbedInputType(opt.InputType)
+	if inputTypeOpt != "" {
+		params.InputType = &inputTypeOpt
+	}
+
+	resp, err := client.Embed(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if resp.EmbeddingsFloats == nil {
+		return nil, fmt.Errorf("Cohere: we did not get float embeddings in the response")
+	}
+
+	if meta := resp.EmbeddingsFloats.Meta; meta != nil && meta.BilledUnits != nil && meta.BilledUnits.InputTokens != nil {
+		span.InputTokens = int(*meta.BilledUnits.InputTokens)
+		span.Cost = wcohere.GetEmbedRequestPrice(span.InputTokens)
+	}
+
+	return resp.EmbeddingsFloats.Embeddings, nil
+}
+
+// estimateCohereTokens approximates the tokens of cohere with the cl100k_base encoding of OpenAI
+func estimateCohereTokens(texts []string) int {
+	enc, err := tokenizer.Get(tokenizer.Cl100kBase)
+	if err != nil {
+		return 0
+	}
+	var n int
+	for _, text := range texts {
+		n += enc.Count(text)
+	}
+	return n
+}
+
+func (m *Embedder) Embed(ctx context.Context, text string, opts ...WithProviderOption) (*Embedding, error) {
+	embs, err := m.BatchEmbed(ctx, []string{text}, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -480,8 +464,8 @@ func (m *Embedder) Embed(text string, opts ...WithProviderOption) (*Embedding, e
 	return embs[0], nil
 }
 
-func (m *SingleProviderEmbedder) Embed(text string, opts ...WithProviderOption) (*SingleProviderEmbedding, error) {
-	embs, err := m.BatchEmbed([]string{text}, opts...)
+func (m *SingleProviderEmbedder) Embed(ctx context.Context, text string, opts ...WithProviderOption) (*SingleProviderEmbedding, error) {
+	embs, err := m.BatchEmbed(ctx, []string{text}, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -494,49 +478,37 @@ func (m *SingleProviderEmbedder) Embed(text string, opts ...WithProviderOption)
 }
 
 func (em *Embedding) GetByProvider(provider providerIden) ([]float64, error) {
-	switch provider {
-	default:
-		panic(fmt.Errorf("should not happen: %s", provider))
-	case "openai":
-		if em.errByProvider != nil && em.errByProvider["openai"] != nil {
-			return nil, em.errByProvider["openai"]
-		}
-		if len(em.byprovider32["openai"]) == 0 {
-			return nil, fmt.Errorf("This embedding does not contain provider openai")
-		}
-		return Float32ToFloat64(em.byprovider32["openai"]), nil
-	case "cohere":
-		if em.errByProvider != nil && em.errByProvider["cohere"] != nil {
-			return nil, em.errByProvider["cohere"]
-		}
-		if len(em.byprovider64["cohere"]) == 0 {
-			return nil, fmt.Errorf("This embedding does not contain provider cohere")
-		}
-		return em.byprovider64["cohere"], nil
+	name, err := providerName(provider)
+	if err != nil {
+		return nil, err
+	}
+	if em.errByProvider != nil && em.errByProvider[name] != nil {
+		return nil, em.errByProvider[name]
 	}
+	if v := em.byprovider32[name]; len(v) > 0 {
+		return Float32ToFloat64(v), nil
+	}
+	if v := em.byprovider64[name]; len(v) > 0 {
+		return v, nil
+	}
+	return nil, fmt.Errorf("This embedding does not contain provider %s", name)
 }
 
 func (em *Embedding) GetByProvider32(provider providerIden) ([]float32, error) {
-	switch provider {
-	default:
-		panic(fmt.Errorf("should not happen: %s", provider))
-	case "openai":
-		if em.errByProvider != nil && em.errByProvider["openai"] != nil {
-			return nil, em.errByProvider["openai"]
-		}
-		if len(em.byprovider32["openai"]) == 0 {
-			return nil, fmt.Errorf("This embedding does not contain provider openai")
-		}
-		return em.byprovider32["openai"], nil
-	case "cohere":
-		if em.errByProvider != nil && em.errByProvider["cohere"] != nil {
-			return nil, em.errByProvider["cohere"]
-		}
-		if len(em.byprovider64["cohere"]) == 0 {
-			return nil, fmt.Errorf("This embedding does not contain provider cohere")
-		}
-		return Float64ToFloat32(em.byprovider64["cohere"]), nil
+	name, err := providerName(provider)
+	if err != nil {
+		return nil, err
 	}
+	if em.errByProvider != nil && em.errByProvider[name] != nil {
+		return nil, em.errByProvider[name]
+	}
+	if v := em.byprovider32[name]; len(v) > 0 {
+		return v, nil
+	}
+	if v := em.byprovider64[name]; len(v) > 0 {
+		return Float64ToFloat32(v), nil
+	}
+	return nil, fmt.Errorf("This embedding does not contain provider %s", name)
 }
 
 func (em *SingleProviderEmbedding) Get32() []float32 {
@@ -565,39 +537,45 @@ func (em *SingleProviderEmbedding) Set32(vector []float32) {
 	em.v64 = nil
 }
 
-func (emb *Embedding) SetByProvider(provider providerIden, vector []float64) error {
-	switch provider {
-	default:
-		panic(fmt.Errorf("should not happen: %s", provider))
-	case "openai":
-		emb.byprovider32["openai"] = Float64ToFloat32(vector)
-		if emb.errByProvider != nil {
-			delete(emb.errByProvider, "openai")
-		}
-	case "cohere":
-		emb.byprovider64["cohere"] = vector
-		if emb.errByProvider != nil {
-			delete(emb.errByProvider, "cohere")
-		}
+// SetByProvider stores vector in the precision of the provider, which must be registered
+func (emb *Embedding) SetByProvider(provider WithProviderOption, vector []float64) error {
+	name, is32, err := storesFloat32(provider)
+	if err != nil {
+		return err
+	}
+
+	delete(emb.byprovider32, name)
+	delete(emb.byprovider64, name)
+	delete(emb.models, name)
+	if is32 {
+		emb.byprovider32[name] = Float64ToFloat32(vector)
+	} else {
+		emb.byprovider64[name] = vector
+	}
+	if emb.errByProvider != nil {
+		delete(emb.errByProvider, name)
 	}
 
 	return nil
 }
 
-func (emb *Embedding) SetByProvider32(provider providerIden, vector []float32) error {
-	switch provider {
-	default:
-		panic(fmt.Errorf("should not happen: %s", provider))
-	case "openai":
-		emb.byprovider32["openai"] = vector
-		if emb.errByProvider != nil {
-			delete(emb.errByProvider, "openai")
-		}
-	case "cohere":
-		emb.byprovider64["cohere"] = Float32ToFloat64(vector)
-		if emb.errByProvider != nil {
-			delete(emb.errByProvider, "cohere")
-		}
+// SetByProvider32 stores vector in the precision of the provider, which must be registered
+func (emb *Embedding) SetByProvider32(provider WithProviderOption, vector []float32) error {
+	name, is32, err := storesFloat32(provider)
+	if err != nil {
+		return err
+	}
+
+	delete(emb.byprovider32, name)
+	delete(emb.byprovider64, name)
+	delete(emb.models, name)
+	if is32 {
+		emb.byprovider32[name] = vector
+	} else {
+		emb.byprovider64[name] = Float32ToFloat64(vector)
+	}
+	if emb.errByProvider != nil {
+		delete(emb.errByProvider, name)
 	}
 
 	return nil
@@ -0,0 +1,268 @@
+package uni
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// withHNSWOption makes an Index search approximately with a Hierarchical Navigable Small World graph, as described
+// in https://arxiv.org/abs/1603.09320
+type withHNSWOption struct {
+	// M is the number of neighbors of a node in the upper levels of the graph, and twice as many in the lowest level.
+	// It defaults to 16.
+	M int
+	// EfConstruction is the number of candidates considered when a node is linked, it defaults to 200
+	EfConstruction int
+	// EfSearch is the minimum number of candidates considered by a search, it defaults to 50
+	EfSearch int
+}
+
+func (*withHNSWOption) IndexOption() {}
+
+// WithHNSW makes an Index search approximately with an HNSW graph. Higher values give better recall and slower
+// inserts and searches, 0 takes the default value.
+func WithHNSW(m, efConstruction, efSearch int) *withHNSWOption {
+	return &withHNSWOption{
+		M:              m,
+		EfConstruction: efConstruction,
+		EfSearch:       efSearch,
+	}
+}
+
+func (o *withHNSWOption) setDefaults() {
+	if o.M < 2 {
+		o.M = 16
+	}
+	if o.EfConstruction <= 0 {
+		o.EfConstruction = 200
+	}
+	if o.EfSearch <= 0 {
+		o.EfSearch = 50
+	}
+}
+
+type hnswCandidate struct {
+	node int32
+	dist float32
+}
+
+// hnswHeap is a min-heap of candidates by distance, or a max-heap if max is set
+type hnswHeap struct {
+	max        bool
+	candidates []hnswCandidate
+}
+
+func (h *hnswHeap) Len() int { return len(h.candidates) }
+
+func (h *hnswHeap) Less(i, j int) bool {
+	if h.max {
+		return h.candidates[i].dist > h.candidates[j].dist
+	}
+	return h.candidates[i].dist < h.candidates[j].dist
+}
+
+func (h *hnswHeap) Swap(i, j int) {
+	h.candidates[i], h.candidates[j] = h.candidates[j], h.candidates[i]
+}
+
+func (h *hnswHeap) Push(x any) { h.candidates = append(h.candidates, x.(hnswCandidate)) }
+
+func (h *hnswHeap) Pop() any {
+	c := h.candidates[len(h.candidates)-1]
+	h.candidates = h.candidates[:len(h.candidates)-1]
+	return c
+}
+
+func (ix *Index) randomLevel() int {
+	f := ix.rng.Float64()
+	for f == 0 {
+		f = ix.rng.Float64()
+	}
+	return int(math.Floor(-math.Log(f) / math.Log(float64(ix.hnsw.M))))
+}
+
+// insert links node n into the graph, the index must be locked
+func (ix *Index) insert(n int32) {
+	node := ix.nodes[n]
+	level := ix.randomLevel()
+	node.neighbors = make([][]int32, level+1)
+
+	if ix.entry < 0 {
+		ix.entry = n
+		ix.maxLevel = level
+		return
+	}
+
+	ep := hnswCandidate{node: ix.entry, dist: ix.distance(node.vector, ix.nodes[ix.entry].vector)}
+	for lc := ix.maxLevel; lc > level; lc-- {
+		ep = ix.greedy(node.vector, ep, lc)
+	}
+
+	for lc := minInt(level, ix.maxLevel); lc >= 0; lc-- {
+		candidates := ix.searchLayer(node.vector, ep, ix.hnsw.EfConstruction, lc)
+
+		maxConn := ix.hnsw.M
+		if lc == 0 {
+			maxConn = 2 * ix.hnsw.M
+		}
+
+		neighbors := ix.selectNeighbors(candidates, ix.hnsw.M)
+		node.neighbors[lc] = make([]int32, len(neighbors))
+		for i, nb := range neighbors {
+			node.neighbors[lc][i] = nb.node
+
+			nbNode := ix.nodes[nb.node]
+			nbNode.neighbors[lc] = append(nbNode.neighbors[lc], n)
+			if len(nbNode.neighbors[lc]) > maxConn {
+				ix.shrink(nbNode, lc, maxConn)
+			}
+		}
+
+		ep = candidates[0]
+	}
+
+	if level > ix.maxLevel {
+		ix.maxLevel = level
+		ix.entry = n
+	}
+}
+
+// shrink keeps the best maxConn neighbors of node at a level
+func (ix *Index) shrink(node *indexNode, level, maxConn int) {
+	candidates := make([]hnswCandidate, len(node.neighbors[level]))
+	for i, nb := range node.neighbors[level] {
+		candidates[i] = hnswCandidate{node: nb, dist: ix.distance(node.vector, ix.nodes[nb].vector)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+
+	selected := ix.selectNeighbors(candidates, maxConn)
+	node.neighbors[level] = node.neighbors[level][:0]
+	for _, c := range selected {
+		node.neighbors[level] = append(node.neighbors[level], c.node)
+	}
+}
+
+// selectNeighbors picks up to m of the candidates sorted by distance, preferring those closer to the node than to
+// the neighbors already selected so that the graph links distinct regions, and fills up with the closest others
+func (ix *Index) selectNeighbors(candidates []hnswCandidate, m int) []hnswCandidate {
+	if len(candidates) <= m {
+		return candidates
+	}
+
+	selected := make([]hnswCandidate, 0, m)
+	var pruned []hnswCandidate
+	for _, c := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		good := true
+		for _, s := range selected {
+			if ix.distance(ix.nodes[c.node].vector, ix.nodes[s.node].vector) < c.dist {
+				good = false
+				break
+			}
+		}
+		if good {
+			selected = append(selected, c)
+		} else {
+			pruned = append(pruned, c)
+		}
+	}
+	for _, c := range pruned {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, c)
+	}
+
+	return selected
+}
+
+// greedy moves from ep to its closest neighbor at a level until none is closer to query
+func (ix *Index) greedy(query []float32, ep hnswCandidate, level int) hnswCandidate {
+	for changed := true; changed; {
+		changed = false
+		for _, nb := range ix.nodes[ep.node].neighbors[level] {
+			d := ix.distance(query, ix.nodes[nb].vector)
+			if d < ep.dist {
+				ep = hnswCandidate{node: nb, dist: d}
+				changed = true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer returns the ef nodes closest to query found from ep at a level, sorted by distance
+func (ix *Index) searchLayer(query []float32, ep hnswCandidate, ef, level int) []hnswCandidate {
+	visited := map[int32]struct{}{ep.node: {}}
+	candidates := &hnswHeap{candidates: []hnswCandidate{ep}}
+	results := &hnswHeap{max: true, candidates: []hnswCandidate{ep}}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && c.dist > results.candidates[0].dist {
+			break
+		}
+
+		for _, nb := range ix.nodes[c.node].neighbors[level] {
+			if _, ok := visited[nb]; ok {
+				continue
+			}
+			visited[nb] = struct{}{}
+
+			d := ix.distance(query, ix.nodes[nb].vector)
+			if results.Len() < ef || d < results.candidates[0].dist {
+				heap.Push(candidates, hnswCandidate{node: nb, dist: d})
+				heap.Push(results, hnswCandidate{node: nb, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := results.candidates
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].dist < sorted[j].dist })
+	return sorted
+}
+
+// searchHNSW returns the k closest nodes which are not deleted and match filter. The search is widened while
+// the filter leaves fewer than k of them, up to the whole graph.
+func (ix *Index) searchHNSW(query []float32, k int, filter Filter) []int32 {
+	ep := hnswCandidate{node: ix.entry, dist: ix.distance(query, ix.nodes[ix.entry].vector)}
+	for lc := ix.maxLevel; lc > 0; lc-- {
+		ep = ix.greedy(query, ep, lc)
+	}
+
+	ef := ix.hnsw.EfSearch
+	if ef < k {
+		ef = k
+	}
+
+	for {
+		var nodes []int32
+		for _, c := range ix.searchLayer(query, ep, ef, 0) {
+			node := ix.nodes[c.node]
+			if node.deleted || (filter != nil && !filter(node.id, node.metadata)) {
+				continue
+			}
+			nodes = append(nodes, c.node)
+			if len(nodes) == k {
+				return nodes
+			}
+		}
+		if ef >= len(ix.nodes) {
+			return nodes
+		}
+		ef *= 2
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,576 @@
+package uni
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Index is an in-memory vector index of embeddings with their ID and metadata. It searches them exactly by default,
+// or approximately with an HNSW graph, see WithHNSW. It is safe for concurrent use, searches run in parallel.
+//
+// Vectors are stored in float32, and all of them must have the same dimension as the first one.
+type Index struct {
+	mu  sync.RWMutex
+	err error
+
+	metric   Metric
+	provider string
+	hnsw     *withHNSWOption
+
+	dim   int
+	ids   map[string]int32
+	nodes []*indexNode
+	// deleted counts the nodes which are deleted, they are kept until the index is compacted for the HNSW graph to
+	// stay connected
+	deleted int
+
+	entry    int32
+	maxLevel int
+	rng      *rand.Rand
+}
+
+type indexNode struct {
+	id       string
+	vector   []float32
+	metadata map[string]any
+	deleted  bool
+	// neighbors by level of the HNSW graph
+	neighbors [][]int32
+}
+
+type IndexOption interface {
+	IndexOption()
+}
+
+type withMetricOption struct {
+	Metric Metric
+}
+
+func (*withMetricOption) IndexOption() {}
+
+// WithMetric sets the metric of an Index, it defaults to MetricCosine
+func WithMetric(metric Metric) *withMetricOption {
+	return &withMetricOption{Metric: metric}
+}
+
+func (providerIden) IndexOption() {}
+
+// Filter selects the entries of an Index a search may return
+type Filter func(id string, metadata map[string]any) bool
+
+// MetadataEquals returns a filter keeping the entries whose metadata has value under key. Numbers are compared as
+// float64, since the metadata of a loaded index is decoded from JSON.
+func MetadataEquals(key string, value any) Filter {
+	return func(id string, metadata map[string]any) bool {
+		v, ok := metadata[key]
+		if !ok {
+			return false
+		}
+		if a, ok := toFloat64(v); ok {
+			b, ok := toFloat64(value)
+			return ok && a == b
+		}
+		return reflect.DeepEqual(v, value)
+	}
+}
+
+func toFloat64(v any) (float64, bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float32:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+type SearchResult struct {
+	ID string
+	// Score of the metric between the query and the entry
+	Score float64
+	// Metadata is a shallow copy of the metadata of the entry
+	Metadata map[string]any
+}
+
+// NewIndex returns an empty index. A provider option, such as WithOpenAI(), selects the vectors indexed from an
+// Embedding, see UpsertEmbedding and SearchEmbedding.
+func NewIndex(opts ...IndexOption) *Index {
+	ix := &Index{
+		metric: MetricCosine,
+		ids:    map[string]int32{},
+		entry:  -1,
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for i := 0; i < len(opts); i++ {
+		switch t := opts[i].(type) {
+		default:
+			ix.err = fmt.Errorf("unknown index option %T", t)
+			return ix
+		case *withMetricOption:
+			switch t.Metric {
+			default:
+				ix.err = fmt.Errorf("unknown metric %s", t.Metric)
+				return ix
+			case MetricCosine, MetricDotProduct, MetricEuclidean, MetricManhattan:
+				ix.metric = t.Metric
+			}
+		case *withHNSWOption:
+			c := *t
+			c.setDefaults()
+			ix.hnsw = &c
+		case providerIden:
+			name, err := providerName(t)
+			if err != nil {
+				ix.err = err
+				return ix
+			}
+			ix.provider = name
+		}
+	}
+
+	return ix
+}
+
+// Len returns the number of entries of the index
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.ids)
+}
+
+// Get returns a copy of the vector of an entry and a shallow copy of its metadata
+func (ix *Index) Get(id string) (*SingleProviderEmbedding, map[string]any, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	n, ok := ix.ids[id]
+	if !ok {
+		return nil, nil, false
+	}
+	node := ix.nodes[n]
+
+	return SingleProviderEmbeddingFrom32(append([]float32(nil), node.vector...)), copyMetadata(node.metadata), true
+}
+
+// Upsert adds an entry to the index, or replaces the entry with the same id
+func (ix *Index) Upsert(id string, emb *SingleProviderEmbedding, metadata map[string]any) error {
+	if ix.err != nil {
+		return ix.err
+	}
+	if emb == nil {
+		return fmt.Errorf("We need an embedding for entry %s", id)
+	}
+
+	return ix.upsert(id, emb.Get32(), metadata)
+}
+
+// UpsertEmbedding adds the vector of the provider of the index of emb, see NewIndex
+func (ix *Index) UpsertEmbedding(id string, emb *Embedding, metadata map[string]any) error {
+	if ix.err != nil {
+		return ix.err
+	}
+	if ix.provider == "" {
+		return fmt.Errorf("We need the provider of the index to add an Embedding, see NewIndex")
+	}
+	if emb == nil {
+		return fmt.Errorf("We need an embedding for entry %s", id)
+	}
+
+	vector, err := emb.GetByProvider32(providerIden(ix.provider))
+	if err != nil {
+		return err
+	}
+
+	return ix.upsert(id, vector, metadata)
+}
+
+func (ix *Index) upsert(id string, vector []float32, metadata map[string]any) error {
+	if id == "" {
+		return fmt.Errorf("We need the ID of the entry")
+	}
+	if len(vector) == 0 {
+		return fmt.Errorf("We cannot index the empty vector of entry %s", id)
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.dim == 0 {
+		ix.dim = len(vector)
+	} else if len(vector) != ix.dim {
+		return fmt.Errorf("The index has vectors of dimension %d, we got %d for entry %s", ix.dim, len(vector), id)
+	}
+
+	ix.delete(id)
+
+	n := int32(len(ix.nodes))
+	ix.nodes = append(ix.nodes, &indexNode{
+		id:       id,
+		vector:   append([]float32(nil), vector...),
+		metadata: copyMetadata(metadata),
+	})
+	ix.ids[id] = n
+
+	if ix.hnsw != nil {
+		ix.insert(n)
+	}
+
+	ix.maybeCompact()
+
+	return nil
+}
+
+// Delete removes an entry from the index, it returns false if there is none with this id
+func (ix *Index) Delete(id string) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ok := ix.delete(id)
+	ix.maybeCompact()
+
+	return ok
+}
+
+func (ix *Index) delete(id string) bool {
+	n, ok := ix.ids[id]
+	if !ok {
+		return false
+	}
+
+	ix.nodes[n].deleted = true
+	ix.nodes[n].metadata = nil
+	delete(ix.ids, id)
+	ix.deleted++
+
+	return true
+}
+
+// maybeCompact rebuilds the index without the deleted nodes once they are the majority
+func (ix *Index) maybeCompact() {
+	if ix.deleted == 0 || ix.deleted*2 <= len(ix.nodes) {
+		return
+	}
+
+	nodes := ix.nodes
+	ix.nodes = make([]*indexNode, 0, len(nodes)-ix.deleted)
+	ix.ids = make(map[string]int32, len(nodes)-ix.deleted)
+	ix.deleted = 0
+	ix.entry = -1
+	ix.maxLevel = 0
+
+	for _, node := range nodes {
+		if node.deleted {
+			continue
+		}
+		n := int32(len(ix.nodes))
+		node.neighbors = nil
+		ix.nodes = append(ix.nodes, node)
+		ix.ids[node.id] = n
+		if ix.hnsw != nil {
+			ix.insert(n)
+		}
+	}
+}
+
+// Search returns the k entries closest to query which match filter, if any, closest first
+func (ix *Index) Search(query *SingleProviderEmbedding, k int, filter Filter) ([]SearchResult, error) {
+	if ix.err != nil {
+		return nil, ix.err
+	}
+	if query == nil {
+		return nil, fmt.Errorf("We need a query embedding")
+	}
+
+	return ix.search(query.Get32(), k, filter)
+}
+
+// SearchEmbedding searches with the vector of the provider of the index of query, see NewIndex
+func (ix *Index) SearchEmbedding(query *Embedding, k int, filter Filter) ([]SearchResult, error) {
+	if ix.err != nil {
+		return nil, ix.err
+	}
+	if ix.provider == "" {
+		return nil, fmt.Errorf("We need the provider of the index to search an Embedding, see NewIndex")
+	}
+	if query == nil {
+		return nil, fmt.Errorf("We need a query embedding")
+	}
+
+	vector, err := query.GetByProvider32(providerIden(ix.provider))
+	if err != nil {
+		return nil, err
+	}
+
+	return ix.search(vector, k, filter)
+}
+
+func (ix *Index) search(query []float32, k int, filter Filter) ([]SearchResult, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if len(ix.ids) == 0 || k <= 0 {
+		return nil, nil
+	}
+	if len(query) != ix.dim {
+		return nil, fmt.Errorf("The index has vectors of dimension %d, we got a query of dimension %d", ix.dim, len(query))
+	}
+
+	var nodes []int32
+	if ix.hnsw != nil {
+		nodes = ix.searchHNSW(query, k, filter)
+	} else {
+		matches, err := topK(ix.metric, len(ix.nodes), k, func(i int) (float64, bool, error) {
+			node := ix.nodes[i]
+			if node.deleted || (filter != nil && !filter(node.id, node.metadata)) {
+				return 0, false, nil
+			}
+			return float64(ix.score(query, node.vector)), true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			nodes = append(nodes, int32(m.Index))
+		}
+	}
+
+	results := make([]SearchResult, len(nodes))
+	for i, n := range nodes {
+		node := ix.nodes[n]
+		results[i] = SearchResult{
+			ID:       node.id,
+			Score:    float64(ix.score(query, node.vector)),
+			Metadata: copyMetadata(node.metadata),
+		}
+	}
+
+	return results, nil
+}
+
+// copyMetadata keeps the callers from modifying the metadata of an entry without holding the lock of the index
+func copyMetadata(metadata map[string]any) map[string]any {
+	if metadata == nil {
+		return nil
+	}
+	c := make(map[string]any, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
+
+// score returns the metric between two vectors of the dimension of the index
+func (ix *Index) score(a, b []float32) float32 {
+	switch ix.metric {
+	case MetricDotProduct:
+		return dot(a, b)
+	case MetricEuclidean:
+		return euclidean(a, b)
+	case MetricManhattan:
+		return manhattan(a, b)
+	}
+	return cosine(a, b)
+}
+
+// distance is lower for closer vectors whatever the metric
+func (ix *Index) distance(a, b []float32) float32 {
+	switch ix.metric {
+	case MetricDotProduct:
+		return -dot(a, b)
+	case MetricEuclidean:
+		return euclidean(a, b)
+	case MetricManhattan:
+		return manhattan(a, b)
+	}
+	return 1 - cosine(a, b)
+}
+
+const indexFormatVersion = 1
+
+type indexFile struct {
+	Version  int
+	Metric   Metric
+	Provider string
+	HNSW     *withHNSWOption
+	Dim      int
+	Entry    int32
+	MaxLevel int
+	Nodes    []indexFileNode
+}
+
+type indexFileNode struct {
+	ID     string
+	Vector []float32
+	// Metadata is encoded in JSON
+	Metadata  []byte
+	Deleted   bool
+	Neighbors [][]int32
+}
+
+// Save writes the index, its HNSW graph included. The metadata must be encodable in JSON.
+func (ix *Index) Save(w io.Writer) error {
+	if ix.err != nil {
+		return ix.err
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	file := &indexFile{
+		Version:  indexFormatVersion,
+		Metric:   ix.metric,
+		Provider: ix.provider,
+		HNSW:     ix.hnsw,
+		Dim:      ix.dim,
+		Entry:    ix.entry,
+		MaxLevel: ix.maxLevel,
+		Nodes:    make([]indexFileNode, len(ix.nodes)),
+	}
+	for i, node := range ix.nodes {
+		file.Nodes[i] = indexFileNode{
+			ID:        node.id,
+			Vector:    node.vector,
+			Deleted:   node.deleted,
+			Neighbors: node.neighbors,
+		}
+		if node.metadata != nil {
+			metadata, err := json.Marshal(node.metadata)
+			if err != nil {
+				return fmt.Errorf("could not encode the metadata of entry %s: %v", node.id, err)
+			}
+			file.Nodes[i].Metadata = metadata
+		}
+	}
+
+	return gob.NewEncoder(w).Encode(file)
+}
+
+// SaveFile writes the index to a file, see Save
+func (ix *Index) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	err = ix.Save(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// LoadIndex reads an index written by Index.Save. Numbers in the metadata are decoded as float64.
+func LoadIndex(r io.Reader) (*Index, error) {
+	file := &indexFile{}
+	err := gob.NewDecoder(r).Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode the index: %v", err)
+	}
+	if file.Version != indexFormatVersion {
+		return nil, fmt.Errorf("unsupported index format version %d", file.Version)
+	}
+
+	var opts []IndexOption
+	opts = append(opts, WithMetric(file.Metric))
+	if file.HNSW != nil {
+		opts = append(opts, file.HNSW)
+	}
+	if file.Provider != "" {
+		opts = append(opts, providerIden(file.Provider))
+	}
+	ix := NewIndex(opts...)
+	if ix.err != nil {
+		return nil, ix.err
+	}
+
+	ix.dim = file.Dim
+	ix.entry = file.Entry
+	ix.maxLevel = file.MaxLevel
+	if len(file.Nodes) == 0 && (ix.entry != -1 || ix.maxLevel != 0) {
+		return nil, fmt.Errorf("invalid index: the empty index has the entry point %d and the top level %d", ix.entry, ix.maxLevel)
+	}
+	if len(file.Nodes) > 0 && ix.dim <= 0 {
+		return nil, fmt.Errorf("invalid index: the entries have the dimension %d", ix.dim)
+	}
+	if len(file.Nodes) > 0 && ix.hnsw != nil {
+		if ix.entry < 0 || int(ix.entry) >= len(file.Nodes) {
+			return nil, fmt.Errorf("invalid index: the entry point %d is out of range", ix.entry)
+		}
+		if ix.maxLevel < 0 || ix.maxLevel >= len(file.Nodes[ix.entry].Neighbors) {
+			return nil, fmt.Errorf("invalid index: the entry point does not have the top level %d", ix.maxLevel)
+		}
+	}
+
+	for i, fn := range file.Nodes {
+		if len(fn.Vector) != ix.dim {
+			return nil, fmt.Errorf("invalid index: entry %s has dimension %d instead of %d", fn.ID, len(fn.Vector), ix.dim)
+		}
+		for level, neighbors := range fn.Neighbors {
+			for _, nb := range neighbors {
+				if nb < 0 || int(nb) >= len(file.Nodes) {
+					return nil, fmt.Errorf("invalid index: entry %s has a neighbor out of range", fn.ID)
+				}
+				if len(file.Nodes[nb].Neighbors) <= level {
+					return nil, fmt.Errorf("invalid index: entry %s has a neighbor at level %d which is not in that level", fn.ID, level)
+				}
+			}
+		}
+
+		node := &indexNode{
+			id:        fn.ID,
+			vector:    fn.Vector,
+			deleted:   fn.Deleted,
+			neighbors: fn.Neighbors,
+		}
+		if len(fn.Metadata) > 0 {
+			err = json.Unmarshal(fn.Metadata, &node.metadata)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index: could not decode the metadata of entry %s: %v", fn.ID, err)
+			}
+		}
+		ix.nodes = append(ix.nodes, node)
+
+		if node.deleted {
+			ix.deleted++
+			continue
+		}
+		if _, ok := ix.ids[fn.ID]; ok {
+			return nil, fmt.Errorf("invalid index: entry %s is duplicated", fn.ID)
+		}
+		ix.ids[fn.ID] = int32(i)
+	}
+
+	return ix, nil
+}
+
+// LoadIndexFile reads an index from a file, see LoadIndex
+func LoadIndexFile(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadIndex(bufio.NewReader(f))
+}
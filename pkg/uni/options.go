@@ -30,6 +30,8 @@ type withOpenAIOption struct {
 
 func (*withOpenAIOption) EmbedderOption() {}
 
+func (*withOpenAIOption) ChatterOption() {}
+
 type withCohereOption struct {
 	APIKey   string
 	Model    string
@@ -40,6 +42,8 @@ type withCohereOption struct {
 
 func (*withCohereOption) EmbedderOption() {}
 
+func (*withCohereOption) ChatterOption() {}
+
 type WithProviderOption interface {
 	WithProviderOption()
 }
@@ -55,3 +59,10 @@ func WithOpenAI() providerIden {
 func WithCohere() providerIden {
 	return providerIden("cohere")
 }
+
+func (providerIden) EmbedderOption() {}
+
+// WithProvider selects a registered provider by name, see RegisterEmbeddingProvider
+func WithProvider(name string) providerIden {
+	return providerIden(name)
+}
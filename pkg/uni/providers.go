@@ -0,0 +1,237 @@
+package uni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/openai"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/wcohere"
+	cohereclient "github.com/cohere-ai/cohere-go/v2/client"
+)
+
+// ErrUnknownProvider is returned for the providers which are not registered, see RegisterEmbeddingProvider
+var ErrUnknownProvider = errors.New("unknown provider")
+
+// EmbeddingProvider computes the embeddings of an Embedder or a SingleProviderEmbedder. Third-party providers
+// register themselves with RegisterEmbeddingProvider, usually in an init function, and are then selected with
+// WithProvider.
+type EmbeddingProvider interface {
+	EmbedderOption
+
+	// Name identifies the vectors of the provider in an Embedding, such as openai or cohere
+	Name() string
+	// Embed returns one vector per text, in the order of texts, which are never more than MaxBatchSize
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+	MaxBatchSize() int
+	// Dimensions of the vectors, 0 if unknown
+	Dimensions() int
+	// Price in dollars of embedding inputTokens tokens
+	Price(inputTokens int) (float64, error)
+}
+
+// Float32EmbeddingProvider is implemented by the providers which compute float32 vectors, they are stored as is
+// in an Embedding instead of being converted to float64
+type Float32EmbeddingProvider interface {
+	EmbeddingProvider
+
+	Embed32(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// ModelEmbeddingProvider is implemented by the providers which report the model computing their vectors, it is
+// recorded in the embeddings and in their serialized form
+type ModelEmbeddingProvider interface {
+	EmbeddingProvider
+
+	EmbeddingModel() string
+}
+
+var embeddingProviders = struct {
+	mu        sync.RWMutex
+	providers map[string]EmbeddingProvider
+}{
+	providers: map[string]EmbeddingProvider{},
+}
+
+func init() {
+	for _, p := range []EmbeddingProvider{
+		WithOpenAIEmbed(openai.Embedding_V3_1536, ""),
+		WithCohereEmbed(wcohere.EmbedEnglishV3, "", "search_document", ""),
+	} {
+		err := RegisterEmbeddingProvider(p)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterEmbeddingProvider adds a provider to the registry, or replaces the one registered under the same name.
+// The registered provider is used by Embedders created with WithProvider and its name can be used in Embeddings.
+func RegisterEmbeddingProvider(p EmbeddingProvider) error {
+	if p == nil || p.Name() == "" {
+		return fmt.Errorf("We need a provider with a name")
+	}
+	if p.MaxBatchSize() <= 0 {
+		return fmt.Errorf("The maximum batch size of provider %s must be positive", p.Name())
+	}
+
+	embeddingProviders.mu.Lock()
+	defer embeddingProviders.mu.Unlock()
+
+	embeddingProviders.providers[p.Name()] = p
+
+	return nil
+}
+
+// LookupEmbeddingProvider returns the provider registered under name
+func LookupEmbeddingProvider(name string) (EmbeddingProvider, error) {
+	embeddingProviders.mu.RLock()
+	defer embeddingProviders.mu.RUnlock()
+
+	p, ok := embeddingProviders.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s, it can be registered with RegisterEmbeddingProvider", ErrUnknownProvider, name)
+	}
+	return p, nil
+}
+
+// RegisteredEmbeddingProviders returns the names of the registered providers, sorted
+func RegisteredEmbeddingProviders() []string {
+	embeddingProviders.mu.RLock()
+	defer embeddingProviders.mu.RUnlock()
+
+	names := make([]string, 0, len(embeddingProviders.providers))
+	for name := range embeddingProviders.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// providerName returns the name of a registered provider
+func providerName(provider WithProviderOption) (string, error) {
+	t, ok := provider.(providerIden)
+	if !ok {
+		return "", fmt.Errorf("%w: %T", ErrUnknownProvider, provider)
+	}
+	_, err := LookupEmbeddingProvider(string(t))
+	if err != nil {
+		return "", err
+	}
+	return string(t), nil
+}
+
+// storesFloat32 tells whether the vectors of the registered provider are stored in float32
+func storesFloat32(provider WithProviderOption) (string, bool, error) {
+	t, ok := provider.(providerIden)
+	if !ok {
+		return "", false, fmt.Errorf("%w: %T", ErrUnknownProvider, provider)
+	}
+	p, err := LookupEmbeddingProvider(string(t))
+	if err != nil {
+		return "", false, err
+	}
+	_, is32 := p.(Float32EmbeddingProvider)
+	return string(t), is32, nil
+}
+
+func (o *withOpenAIOption) Name() string { return "openai" }
+
+func (o *withOpenAIOption) MaxBatchSize() int { return 50 }
+
+func (o *withOpenAIOption) EmbeddingModel() string { return string(o.Model) }
+
+func (o *withOpenAIOption) Dimensions() int {
+	switch o.Model {
+	case openai.Text_Embedding_Ada_2_8k, openai.Embedding_V3_1536:
+		return 1536
+	case openai.Embedding_V3_3072:
+		return 3072
+	}
+	return 0
+}
+
+func (o *withOpenAIOption) Price(inputTokens int) (float64, error) {
+	pricing, err := openai.GetPricing(o.Model)
+	if err != nil {
+		return 0, err
+	}
+	return float64(inputTokens) / 1000 * pricing.Prompt, nil
+}
+
+func (o *withOpenAIOption) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	vectors32, err := o.Embed32(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([][]float64, len(vectors32))
+	for i, v := range vectors32 {
+		vectors[i] = Float32ToFloat64(v)
+	}
+	return vectors, nil
+}
+
+func (o *withOpenAIOption) Embed32(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := openai.CreateEmbedding(ctx, &openai.EmbeddingRequest{
+		APIKEY: o.APIKey,
+		Model:  o.Model,
+		Input:  texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i := 0; i < len(resp.Data); i++ {
+		if resp.Data[i].Index < 0 || resp.Data[i].Index >= len(texts) {
+			return nil, fmt.Errorf("We got an embedding for an unknown text %d", resp.Data[i].Index)
+		}
+		vectors[resp.Data[i].Index] = resp.Data[i].Embedding
+	}
+	return vectors, nil
+}
+
+func (o *withCohereOption) Name() string { return "cohere" }
+
+func (o *withCohereOption) MaxBatchSize() int { return 96 }
+
+func (o *withCohereOption) EmbeddingModel() string { return o.Model }
+
+func (o *withCohereOption) Dimensions() int {
+	switch o.Model {
+	case wcohere.EmbedEnglishV2:
+		return 4096
+	case wcohere.EmbedEnglishLightV2:
+		return 1024
+	case wcohere.EmbedMultilingualV2:
+		return 768
+	case wcohere.EmbedEnglishV3, wcohere.EmbedMultilingualV3:
+		return 1024
+	}
+	return 0
+}
+
+func (o *withCohereOption) Price(inputTokens int) (float64, error) {
+	return wcohere.GetEmbedRequestPrice(inputTokens), nil
+}
+
+func (o *withCohereOption) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var client *cohereclient.Client
+	if o.APIKey != "" {
+		var err error
+		client, err = wcohere.NewClient(o.APIKey)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		client = wcohere.DefaultClient
+		if client == nil {
+			return nil, fmt.Errorf("Cohere: we did not get an apikey for this request nor is a default client initialized")
+		}
+	}
+
+	return cohereEmbed(ctx, client, o, texts)
+}
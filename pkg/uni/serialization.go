@@ -0,0 +1,601 @@
+package uni
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrDimensionMismatch is returned when decoding a vector whose dimension is not the announced one, or not the one
+// of the registered provider and model which computed it
+var ErrDimensionMismatch = errors.New("mismatched dimensions")
+
+// VectorEncoding is the precision of the vectors in the binary format of embeddings. Float16 and Int8 are lossy
+// and decoded as float32, Int8 scales each vector by its maximum absolute value.
+type VectorEncoding uint8
+
+const (
+	EncodingFloat64 VectorEncoding = iota + 1
+	EncodingFloat32
+	EncodingFloat16
+	EncodingInt8
+)
+
+func (e VectorEncoding) String() string {
+	switch e {
+	case EncodingFloat64:
+		return "float64"
+	case EncodingFloat32:
+		return "float32"
+	case EncodingFloat16:
+		return "float16"
+	case EncodingInt8:
+		return "int8"
+	}
+	return fmt.Sprintf("VectorEncoding(%d)", uint8(e))
+}
+
+func (e VectorEncoding) size() int {
+	switch e {
+	case EncodingFloat64:
+		return 8
+	case EncodingFloat32:
+		return 4
+	case EncodingFloat16:
+		return 2
+	case EncodingInt8:
+		return 1
+	}
+	return 0
+}
+
+// The binary format is the magic, the version, the number of vectors as a uvarint, then for each vector:
+// the provider and the model as a uvarint length followed by the bytes, the encoding on one byte, the dimension
+// as a uvarint, the float32 scale for EncodingInt8, and the values in little endian.
+const (
+	embeddingMagic         = "UEMB"
+	embeddingFormatVersion = 1
+)
+
+// wireVector is a vector with its metadata, as serialized
+type wireVector struct {
+	provider string
+	model    string
+	v32      []float32
+	v64      []float64
+}
+
+func (v *wireVector) dim() int {
+	if v.v64 != nil {
+		return len(v.v64)
+	}
+	return len(v.v32)
+}
+
+func (v *wireVector) nativeEncoding() VectorEncoding {
+	if v.v64 != nil {
+		return EncodingFloat64
+	}
+	return EncodingFloat32
+}
+
+func (v *wireVector) float32s() []float32 {
+	if v.v64 != nil {
+		return Float64ToFloat32(v.v64)
+	}
+	return v.v32
+}
+
+func (v *wireVector) float64s() []float64 {
+	if v.v64 != nil {
+		return v.v64
+	}
+	return Float32ToFloat64(v.v32)
+}
+
+// checkDimensions rejects a vector whose dimension is not the one of the registered provider, when the vector was
+// computed by its model
+func (v *wireVector) checkDimensions() error {
+	if v.dim() == 0 {
+		return fmt.Errorf("%w: empty vector for provider %s", ErrDimensionMismatch, v.provider)
+	}
+	if v.provider == "" || v.model == "" {
+		return nil
+	}
+
+	p, err := LookupEmbeddingProvider(v.provider)
+	if err != nil || embeddingModel(p) != v.model || p.Dimensions() == 0 {
+		return nil
+	}
+	if p.Dimensions() != v.dim() {
+		return fmt.Errorf("%w: model %s of provider %s has dimension %d, we got %d", ErrDimensionMismatch, v.model, v.provider, p.Dimensions(), v.dim())
+	}
+
+	return nil
+}
+
+func encodeBinary(vectors []wireVector, enc VectorEncoding) ([]byte, error) {
+	size := len(embeddingMagic) + 1 + binary.MaxVarintLen64
+	for _, v := range vectors {
+		size += 3*binary.MaxVarintLen64 + len(v.provider) + len(v.model) + 5 + v.dim()*8
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, embeddingMagic...)
+	buf = append(buf, embeddingFormatVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(vectors)))
+
+	for _, v := range vectors {
+		venc := enc
+		if venc == 0 {
+			venc = v.nativeEncoding()
+		}
+
+		buf = binary.AppendUvarint(buf, uint64(len(v.provider)))
+		buf = append(buf, v.provider...)
+		buf = binary.AppendUvarint(buf, uint64(len(v.model)))
+		buf = append(buf, v.model...)
+		buf = append(buf, byte(venc))
+		buf = binary.AppendUvarint(buf, uint64(v.dim()))
+
+		switch venc {
+		default:
+			return nil, fmt.Errorf("unknown vector encoding %s", venc)
+		case EncodingFloat64:
+			for _, f := range v.float64s() {
+				buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+			}
+		case EncodingFloat32:
+			for _, f := range v.float32s() {
+				buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(f))
+			}
+		case EncodingFloat16:
+			for _, f := range v.float32s() {
+				buf = binary.LittleEndian.AppendUint16(buf, float32ToFloat16(f))
+			}
+		case EncodingInt8:
+			values := v.float32s()
+			var maxAbs float32
+			for _, f := range values {
+				if a := float32(math.Abs(float64(f))); a > maxAbs {
+					maxAbs = a
+				}
+			}
+			scale := maxAbs / 127
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(scale))
+			for _, f := range values {
+				var q float64
+				if scale > 0 {
+					q = math.Max(-127, math.Min(127, math.Round(float64(f/scale))))
+				}
+				buf = append(buf, byte(int8(q)))
+			}
+		}
+	}
+
+	return buf, nil
+}
+
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("invalid embedding: truncated or invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) {
+		r.err = fmt.Errorf("invalid embedding: truncated data")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *binaryReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *binaryReader) float32() float32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes(r.uvarint()))
+}
+
+func decodeBinary(data []byte) ([]wireVector, error) {
+	if len(data) < len(embeddingMagic)+1 || string(data[:len(embeddingMagic)]) != embeddingMagic {
+		return nil, fmt.Errorf("invalid embedding: not in the binary format of embeddings")
+	}
+	if data[len(embeddingMagic)] != embeddingFormatVersion {
+		return nil, fmt.Errorf("unsupported embedding format version %d", data[len(embeddingMagic)])
+	}
+
+	r := &binaryReader{data: data[len(embeddingMagic)+1:]}
+	count := r.uvarint()
+	if count > uint64(len(r.data)) {
+		return nil, fmt.Errorf("invalid embedding: %d vectors announced in %d bytes", count, len(r.data))
+	}
+
+	vectors := make([]wireVector, 0, count)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		v := wireVector{
+			provider: r.string(),
+			model:    r.string(),
+		}
+		enc := VectorEncoding(r.byte())
+		if r.err != nil {
+			break
+		}
+		if enc.size() == 0 {
+			return nil, fmt.Errorf("invalid embedding: unknown vector encoding %d", uint8(enc))
+		}
+		dim := r.uvarint()
+
+		var scale float32
+		if enc == EncodingInt8 {
+			scale = r.float32()
+		}
+		if r.err == nil && dim > uint64(len(r.data))/uint64(enc.size()) {
+			return nil, fmt.Errorf("%w: vector of provider %s announces dimension %d for %d bytes", ErrDimensionMismatch, v.provider, dim, len(r.data))
+		}
+		raw := r.bytes(dim * uint64(enc.size()))
+		if r.err != nil {
+			break
+		}
+
+		switch enc {
+		case EncodingFloat64:
+			v.v64 = make([]float64, dim)
+			for j := range v.v64 {
+				v.v64[j] = math.Float64frombits(binary.LittleEndian.Uint64(raw[j*8:]))
+			}
+		case EncodingFloat32:
+			v.v32 = make([]float32, dim)
+			for j := range v.v32 {
+				v.v32[j] = math.Float32frombits(binary.LittleEndian.Uint32(raw[j*4:]))
+			}
+		case EncodingFloat16:
+			v.v32 = make([]float32, dim)
+			for j := range v.v32 {
+				v.v32[j] = float16ToFloat32(binary.LittleEndian.Uint16(raw[j*2:]))
+			}
+		case EncodingInt8:
+			v.v32 = make([]float32, dim)
+			for j := range v.v32 {
+				v.v32[j] = float32(int8(raw[j])) * scale
+			}
+		}
+
+		err := v.checkDimensions()
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, v)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("invalid embedding: %d trailing bytes", len(r.data))
+	}
+
+	return vectors, nil
+}
+
+type jsonEmbedding struct {
+	Version int          `json:"version"`
+	Vectors []jsonVector `json:"vectors"`
+}
+
+type jsonVector struct {
+	Provider   string          `json:"provider,omitempty"`
+	Model      string          `json:"model,omitempty"`
+	Dimensions int             `json:"dimensions"`
+	Precision  string          `json:"precision"`
+	Vector     json.RawMessage `json:"vector"`
+}
+
+func encodeJSON(vectors []wireVector) ([]byte, error) {
+	ret := jsonEmbedding{
+		Version: embeddingFormatVersion,
+		Vectors: make([]jsonVector, len(vectors)),
+	}
+
+	for i, v := range vectors {
+		var raw []byte
+		var err error
+		if v.v64 != nil {
+			raw, err = json.Marshal(v.v64)
+		} else {
+			raw, err = json.Marshal(v.v32)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Vectors[i] = jsonVector{
+			Provider:   v.provider,
+			Model:      v.model,
+			Dimensions: v.dim(),
+			Precision:  v.nativeEncoding().String(),
+			Vector:     raw,
+		}
+	}
+
+	return json.Marshal(ret)
+}
+
+func decodeJSON(data []byte) ([]wireVector, error) {
+	var in jsonEmbedding
+	err := json.Unmarshal(data, &in)
+	if err != nil {
+		return nil, err
+	}
+	if in.Version != embeddingFormatVersion {
+		return nil, fmt.Errorf("unsupported embedding format version %d", in.Version)
+	}
+
+	vectors := make([]wireVector, len(in.Vectors))
+	for i, jv := range in.Vectors {
+		v := wireVector{
+			provider: jv.Provider,
+			model:    jv.Model,
+		}
+
+		switch jv.Precision {
+		default:
+			return nil, fmt.Errorf("invalid embedding: unsupported precision %q in JSON", jv.Precision)
+		case EncodingFloat64.String():
+			err = json.Unmarshal(jv.Vector, &v.v64)
+		case EncodingFloat32.String():
+			err = json.Unmarshal(jv.Vector, &v.v32)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid embedding: could not decode the vector of provider %s: %v", jv.Provider, err)
+		}
+
+		if v.dim() != jv.Dimensions {
+			return nil, fmt.Errorf("%w: vector of provider %s announces dimension %d and has %d values", ErrDimensionMismatch, jv.Provider, jv.Dimensions, v.dim())
+		}
+		err = v.checkDimensions()
+		if err != nil {
+			return nil, err
+		}
+
+		vectors[i] = v
+	}
+
+	return vectors, nil
+}
+
+// wireVectors returns the vectors of the embedding sorted by provider, for the output to be deterministic
+func (emb *Embedding) wireVectors() []wireVector {
+	var vectors []wireVector
+	for name, v := range emb.byprovider32 {
+		vectors = append(vectors, wireVector{provider: name, model: emb.models[name], v32: v})
+	}
+	for name, v := range emb.byprovider64 {
+		vectors = append(vectors, wireVector{provider: name, model: emb.models[name], v64: v})
+	}
+	sort.Slice(vectors, func(i, j int) bool { return vectors[i].provider < vectors[j].provider })
+	return vectors
+}
+
+// setWireVectors replaces the vectors of the embedding. They are stored in the precision of their provider if it
+// is registered, see SetByProvider, and in the precision they were decoded in otherwise.
+func (emb *Embedding) setWireVectors(vectors []wireVector) error {
+	ret := NewEmbedding()
+
+	for _, v := range vectors {
+		if v.provider == "" {
+			return fmt.Errorf("We need the provider of each vector of an Embedding")
+		}
+		if _, ok := ret.models[v.provider]; ok {
+			return fmt.Errorf("invalid embedding: duplicated provider %s", v.provider)
+		}
+
+		_, is32, err := storesFloat32(providerIden(v.provider))
+		switch {
+		case errors.Is(err, ErrUnknownProvider):
+			is32 = v.v64 == nil
+		case err != nil:
+			return err
+		}
+		if is32 {
+			ret.byprovider32[v.provider] = v.float32s()
+		} else {
+			ret.byprovider64[v.provider] = v.float64s()
+		}
+		ret.models[v.provider] = v.model
+	}
+
+	for name, model := range ret.models {
+		if model == "" {
+			delete(ret.models, name)
+		}
+	}
+
+	*emb = *ret
+	return nil
+}
+
+func (s *SingleProviderEmbedding) wireVectors() []wireVector {
+	if len(s.v32) == 0 && len(s.v64) == 0 {
+		return nil
+	}
+	v := wireVector{provider: s.provider, model: s.model}
+	if len(s.v32) > 0 {
+		v.v32 = s.v32
+	} else {
+		v.v64 = s.v64
+	}
+	return []wireVector{v}
+}
+
+func (s *SingleProviderEmbedding) setWireVectors(vectors []wireVector) error {
+	if len(vectors) > 1 {
+		return fmt.Errorf("We cannot decode %d vectors into a SingleProviderEmbedding", len(vectors))
+	}
+
+	*s = SingleProviderEmbedding{}
+	if len(vectors) == 1 {
+		s.provider = vectors[0].provider
+		s.model = vectors[0].model
+		s.v32 = vectors[0].v32
+		s.v64 = vectors[0].v64
+	}
+	return nil
+}
+
+// MarshalBinary encodes the embedding in its precision with its providers and models. Errors are not encoded.
+func (emb *Embedding) MarshalBinary() ([]byte, error) {
+	return encodeBinary(emb.wireVectors(), 0)
+}
+
+// MarshalBinaryAs encodes the embedding with the given encoding, such as EncodingFloat16 for half the size of
+// float32 vectors
+func (emb *Embedding) MarshalBinaryAs(enc VectorEncoding) ([]byte, error) {
+	return encodeBinary(emb.wireVectors(), enc)
+}
+
+func (emb *Embedding) UnmarshalBinary(data []byte) error {
+	vectors, err := decodeBinary(data)
+	if err != nil {
+		return err
+	}
+	return emb.setWireVectors(vectors)
+}
+
+// MarshalJSON encodes the embedding in its precision with its providers and models. Errors are not encoded.
+func (emb *Embedding) MarshalJSON() ([]byte, error) {
+	return encodeJSON(emb.wireVectors())
+}
+
+func (emb *Embedding) UnmarshalJSON(data []byte) error {
+	vectors, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	return emb.setWireVectors(vectors)
+}
+
+// MarshalBinary encodes the vector in its precision with its provider and model, when known
+func (s *SingleProviderEmbedding) MarshalBinary() ([]byte, error) {
+	return encodeBinary(s.wireVectors(), 0)
+}
+
+// MarshalBinaryAs encodes the vector with the given encoding, such as EncodingInt8 for a quarter of the size of a
+// float32 vector
+func (s *SingleProviderEmbedding) MarshalBinaryAs(enc VectorEncoding) ([]byte, error) {
+	return encodeBinary(s.wireVectors(), enc)
+}
+
+func (s *SingleProviderEmbedding) UnmarshalBinary(data []byte) error {
+	vectors, err := decodeBinary(data)
+	if err != nil {
+		return err
+	}
+	return s.setWireVectors(vectors)
+}
+
+// MarshalJSON encodes the vector in its precision with its provider and model, when known
+func (s *SingleProviderEmbedding) MarshalJSON() ([]byte, error) {
+	return encodeJSON(s.wireVectors())
+}
+
+func (s *SingleProviderEmbedding) UnmarshalJSON(data []byte) error {
+	vectors, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	return s.setWireVectors(vectors)
+}
+
+// float32ToFloat16 converts to IEEE 754 half precision, rounding to the nearest even
+func float32ToFloat16(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int32(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+
+	switch {
+	case b&0x7fffffff > 0x7f800000:
+		return sign | 0x7e00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp < -10:
+		return sign
+	case exp <= 0:
+		// subnormal
+		mant |= 0x800000
+		shift := uint32(14 - exp)
+		m := mant >> shift
+		rem := mant & (1<<shift - 1)
+		half := uint32(1) << (shift - 1)
+		if rem > half || (rem == half && m&1 == 1) {
+			m++
+		}
+		return sign | uint16(m)
+	}
+
+	h := uint32(exp)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && h&1 == 1) {
+		// may carry into the exponent, up to infinity
+		h++
+	}
+	return sign | uint16(h)
+}
+
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch {
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// subnormal, normalized in float32
+		e := int32(1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		mant &= 0x3ff
+		return math.Float32frombits(sign | uint32(e-15+127)<<23 | mant<<13)
+	}
+
+	return math.Float32frombits(sign | (exp-15+127)<<23 | mant<<13)
+}
@@ -0,0 +1,410 @@
+package uni
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Float is the precision of the vectors of embeddings
+type Float interface {
+	~float32 | ~float64
+}
+
+type Metric string
+
+const (
+	// MetricCosine is the cosine similarity, higher is closer
+	MetricCosine Metric = "cosine"
+	// MetricDotProduct is the dot product, higher is closer. It equals the cosine similarity for normalized vectors.
+	MetricDotProduct Metric = "dot_product"
+	// MetricEuclidean is the Euclidean distance, lower is closer
+	MetricEuclidean Metric = "euclidean"
+	// MetricManhattan is the Manhattan distance, lower is closer
+	MetricManhattan Metric = "manhattan"
+)
+
+// HigherIsCloser tells whether the metric is a similarity rather than a distance
+func (m Metric) HigherIsCloser() bool {
+	return m == MetricCosine || m == MetricDotProduct
+}
+
+// Closer tells whether score a is closer than score b for the metric
+func (m Metric) Closer(a, b float64) bool {
+	if m.HigherIsCloser() {
+		return a > b
+	}
+	return a < b
+}
+
+func DotProduct[F Float](a, b []F) (F, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("We need vectors of the same length, we got %d and %d", len(a), len(b))
+	}
+	return dot(a, b), nil
+}
+
+// CosineSimilarity is 0 if one of the vectors is zero
+func CosineSimilarity[F Float](a, b []F) (F, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("We need vectors of the same length, we got %d and %d", len(a), len(b))
+	}
+	return cosine(a, b), nil
+}
+
+func EuclideanDistance[F Float](a, b []F) (F, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("We need vectors of the same length, we got %d and %d", len(a), len(b))
+	}
+	return euclidean(a, b), nil
+}
+
+func ManhattanDistance[F Float](a, b []F) (F, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("We need vectors of the same length, we got %d and %d", len(a), len(b))
+	}
+	return manhattan(a, b), nil
+}
+
+// Normalize returns a copy of v with a norm of 1, or a copy of v if it is zero
+func Normalize[F Float](v []F) []F {
+	ret := make([]F, len(v))
+	copy(ret, v)
+
+	norm := F(math.Sqrt(float64(dot(v, v))))
+	if norm == 0 {
+		return ret
+	}
+	for i := range ret {
+		ret[i] /= norm
+	}
+	return ret
+}
+
+// Compare returns the score of the metric between two vectors of the same length
+func Compare[F Float](metric Metric, a, b []F) (float64, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("We need vectors of the same length, we got %d and %d", len(a), len(b))
+	}
+	switch metric {
+	case MetricCosine:
+		return float64(cosine(a, b)), nil
+	case MetricDotProduct:
+		return float64(dot(a, b)), nil
+	case MetricEuclidean:
+		return float64(euclidean(a, b)), nil
+	case MetricManhattan:
+		return float64(manhattan(a, b)), nil
+	}
+	return 0, fmt.Errorf("unknown metric %s", metric)
+}
+
+// The loops below are unrolled with independent accumulators for the compiler to keep them in registers and the
+// processor to pipeline them, and b is resliced to the length of a to remove the bounds checks.
+
+func dot[F Float](a, b []F) F {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 F
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+func cosine[F Float](a, b []F) F {
+	b = b[:len(a)]
+	var d0, d1, na0, na1, nb0, nb1 F
+	i := 0
+	for ; i+2 <= len(a); i += 2 {
+		d0 += a[i] * b[i]
+		na0 += a[i] * a[i]
+		nb0 += b[i] * b[i]
+		d1 += a[i+1] * b[i+1]
+		na1 += a[i+1] * a[i+1]
+		nb1 += b[i+1] * b[i+1]
+	}
+	for ; i < len(a); i++ {
+		d0 += a[i] * b[i]
+		na0 += a[i] * a[i]
+		nb0 += b[i] * b[i]
+	}
+	na, nb := na0+na1, nb0+nb1
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return (d0 + d1) / F(math.Sqrt(float64(na))*math.Sqrt(float64(nb)))
+}
+
+func euclidean[F Float](a, b []F) F {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 F
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		d0 := a[i] - b[i]
+		d1 := a[i+1] - b[i+1]
+		d2 := a[i+2] - b[i+2]
+		d3 := a[i+3] - b[i+3]
+		s0 += d0 * d0
+		s1 += d1 * d1
+		s2 += d2 * d2
+		s3 += d3 * d3
+	}
+	for ; i < len(a); i++ {
+		d := a[i] - b[i]
+		s0 += d * d
+	}
+	return F(math.Sqrt(float64(s0 + s1 + s2 + s3)))
+}
+
+func manhattan[F Float](a, b []F) F {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 F
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += abs(a[i] - b[i])
+		s1 += abs(a[i+1] - b[i+1])
+		s2 += abs(a[i+2] - b[i+2])
+		s3 += abs(a[i+3] - b[i+3])
+	}
+	for ; i < len(a); i++ {
+		s0 += abs(a[i] - b[i])
+	}
+	return s0 + s1 + s2 + s3
+}
+
+func abs[F Float](x F) F {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// compareVectors compares in float32 when both vectors are float32, and in float64 otherwise
+func compareVectors(metric Metric, a32 []float32, a64 []float64, b32 []float32, b64 []float64) (float64, error) {
+	if len(a32) > 0 && len(b32) > 0 {
+		return Compare(metric, a32, b32)
+	}
+	if len(a64) == 0 {
+		a64 = Float32ToFloat64(a32)
+	}
+	if len(b64) == 0 {
+		b64 = Float32ToFloat64(b32)
+	}
+	if len(a64) == 0 || len(b64) == 0 {
+		return 0, fmt.Errorf("We cannot compare an empty embedding")
+	}
+	return Compare(metric, a64, b64)
+}
+
+// Compare returns the score of the metric between the two embeddings
+func (s *SingleProviderEmbedding) Compare(metric Metric, other *SingleProviderEmbedding) (float64, error) {
+	return compareVectors(metric, s.v32, s.v64, other.v32, other.v64)
+}
+
+func (s *SingleProviderEmbedding) CosineSimilarity(other *SingleProviderEmbedding) (float64, error) {
+	return s.Compare(MetricCosine, other)
+}
+
+func (s *SingleProviderEmbedding) DotProduct(other *SingleProviderEmbedding) (float64, error) {
+	return s.Compare(MetricDotProduct, other)
+}
+
+func (s *SingleProviderEmbedding) EuclideanDistance(other *SingleProviderEmbedding) (float64, error) {
+	return s.Compare(MetricEuclidean, other)
+}
+
+func (s *SingleProviderEmbedding) ManhattanDistance(other *SingleProviderEmbedding) (float64, error) {
+	return s.Compare(MetricManhattan, other)
+}
+
+// Normalized returns a copy of the embedding with a norm of 1, in the same precision
+func (s *SingleProviderEmbedding) Normalized() *SingleProviderEmbedding {
+	if len(s.v32) > 0 {
+		return SingleProviderEmbeddingFrom32(Normalize(s.v32))
+	}
+	return SingleProviderEmbeddingFrom(Normalize(s.v64))
+}
+
+// CompareByProvider returns the score of the metric between the vectors of provider of the two embeddings
+func (emb *Embedding) CompareByProvider(metric Metric, other *Embedding, provider WithProviderOption) (float64, error) {
+	name, err := providerName(provider)
+	if err != nil {
+		return 0, err
+	}
+	if len(emb.byprovider32[name]) == 0 && len(emb.byprovider64[name]) == 0 {
+		return 0, fmt.Errorf("This embedding does not contain provider %s", name)
+	}
+	if len(other.byprovider32[name]) == 0 && len(other.byprovider64[name]) == 0 {
+		return 0, fmt.Errorf("The other embedding does not contain provider %s", name)
+	}
+	return compareVectors(metric, emb.byprovider32[name], emb.byprovider64[name], other.byprovider32[name], other.byprovider64[name])
+}
+
+func (emb *Embedding) CosineSimilarity(other *Embedding, provider WithProviderOption) (float64, error) {
+	return emb.CompareByProvider(MetricCosine, other, provider)
+}
+
+func (emb *Embedding) DotProduct(other *Embedding, provider WithProviderOption) (float64, error) {
+	return emb.CompareByProvider(MetricDotProduct, other, provider)
+}
+
+func (emb *Embedding) EuclideanDistance(other *Embedding, provider WithProviderOption) (float64, error) {
+	return emb.CompareByProvider(MetricEuclidean, other, provider)
+}
+
+func (emb *Embedding) ManhattanDistance(other *Embedding, provider WithProviderOption) (float64, error) {
+	return emb.CompareByProvider(MetricManhattan, other, provider)
+}
+
+// Normalized returns a copy of the embedding whose vectors have a norm of 1, in the same precision
+func (emb *Embedding) Normalized() *Embedding {
+	ret := NewEmbedding()
+	for name, v := range emb.byprovider32 {
+		ret.byprovider32[name] = Normalize(v)
+	}
+	for name, v := range emb.byprovider64 {
+		ret.byprovider64[name] = Normalize(v)
+	}
+	return ret
+}
+
+// WeightedCompare returns the weighted mean of the scores of the metric for the providers of weights, or for all the
+// providers the two embeddings have in common with the same weight if weights is empty
+func (emb *Embedding) WeightedCompare(metric Metric, other *Embedding, weights map[WithProviderOption]float64) (float64, error) {
+	if len(weights) == 0 {
+		weights = map[WithProviderOption]float64{}
+		for _, name := range emb.providerNames() {
+			if len(other.byprovider32[name]) > 0 || len(other.byprovider64[name]) > 0 {
+				weights[providerIden(name)] = 1
+			}
+		}
+		if len(weights) == 0 {
+			return 0, fmt.Errorf("The embeddings have no provider in common")
+		}
+	}
+
+	var score, total float64
+	for provider, weight := range weights {
+		if weight < 0 {
+			return 0, fmt.Errorf("The weights cannot be negative")
+		}
+		if weight == 0 {
+			continue
+		}
+		s, err := emb.CompareByProvider(metric, other, provider)
+		if err != nil {
+			return 0, err
+		}
+		score += weight * s
+		total += weight
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("We need at least one positive weight")
+	}
+
+	return score / total, nil
+}
+
+func (emb *Embedding) providerNames() []string {
+	var names []string
+	for name := range emb.byprovider32 {
+		names = append(names, name)
+	}
+	for name := range emb.byprovider64 {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Match is a candidate of a top-k search, Index is its position in the candidates
+type Match struct {
+	Index int
+	Score float64
+}
+
+// TopK returns the k candidates closest to query for the metric, closest first. Nil candidates are skipped.
+func TopK(metric Metric, query *SingleProviderEmbedding, candidates []*SingleProviderEmbedding, k int) ([]Match, error) {
+	return topK(metric, len(candidates), k, func(i int) (float64, bool, error) {
+		if candidates[i] == nil {
+			return 0, false, nil
+		}
+		score, err := query.Compare(metric, candidates[i])
+		return score, true, err
+	})
+}
+
+// TopKEmbeddings returns the k candidates closest to query for the weighted metric, see Embedding.WeightedCompare.
+// Nil candidates are skipped.
+func TopKEmbeddings(metric Metric, query *Embedding, candidates []*Embedding, k int, weights map[WithProviderOption]float64) ([]Match, error) {
+	return topK(metric, len(candidates), k, func(i int) (float64, bool, error) {
+		if candidates[i] == nil {
+			return 0, false, nil
+		}
+		score, err := query.WeightedCompare(metric, candidates[i], weights)
+		return score, true, err
+	})
+}
+
+func topK(metric Metric, n, k int, score func(i int) (float64, bool, error)) ([]Match, error) {
+	if k <= 0 {
+		return nil, nil
+	}
+
+	// The heap keeps the k closest matches with the farthest one on top
+	h := &matchHeap{metric: metric}
+	for i := 0; i < n; i++ {
+		s, ok, err := score(i)
+		if err != nil {
+			return nil, fmt.Errorf("candidate %d: %v", i, err)
+		}
+		if !ok {
+			continue
+		}
+		if h.Len() < k {
+			heap.Push(h, Match{Index: i, Score: s})
+		} else if metric.Closer(s, h.matches[0].Score) {
+			h.matches[0] = Match{Index: i, Score: s}
+			heap.Fix(h, 0)
+		}
+	}
+
+	matches := h.matches
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].Index < matches[j].Index
+		}
+		return metric.Closer(matches[i].Score, matches[j].Score)
+	})
+
+	return matches, nil
+}
+
+type matchHeap struct {
+	metric  Metric
+	matches []Match
+}
+
+func (h *matchHeap) Len() int { return len(h.matches) }
+
+func (h *matchHeap) Less(i, j int) bool {
+	return h.metric.Closer(h.matches[j].Score, h.matches[i].Score)
+}
+
+func (h *matchHeap) Swap(i, j int) { h.matches[i], h.matches[j] = h.matches[j], h.matches[i] }
+
+func (h *matchHeap) Push(x any) { h.matches = append(h.matches, x.(Match)) }
+
+func (h *matchHeap) Pop() any {
+	m := h.matches[len(h.matches)-1]
+	h.matches = h.matches[:len(h.matches)-1]
+	return m
+}
@@ -0,0 +1,456 @@
+// Package usage keeps a ledger of the tokens and cost of the calls made to the AI providers, and enforces budgets.
+//
+// A Tracker is attached to a context with NewContext, or to an openai.Client with openai.WithUsageTracker. Every
+// successful call made with that context or client is then recorded, with the tags set on the context by WithTags.
+package usage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned before a request which would exceed a hard budget is sent
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// DefaultMaxRecords is the number of records a Tracker keeps in its ledger unless WithMaxRecords says otherwise
+const DefaultMaxRecords = 100000
+
+// Record is the usage of one call. The APIKey is masked, only its last 4 characters are kept.
+type Record struct {
+	Time         time.Time         `json:"time"`
+	Provider     string            `json:"provider"`
+	Operation    string            `json:"operation,omitempty"`
+	Model        string            `json:"model,omitempty"`
+	APIKey       string            `json:"api_key,omitempty"`
+	InputTokens  int               `json:"input_tokens"`
+	OutputTokens int               `json:"output_tokens"`
+	Cost         float64           `json:"cost"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+// Budget limits the cost of the calls matching all its filters, an empty filter matches every call
+type Budget struct {
+	Name string `json:"name"`
+	// Limit in dollars
+	Limit float64 `json:"limit"`
+	// A soft budget only warns when a request would exceed it, a hard one refuses the request with ErrBudgetExceeded
+	Soft bool `json:"soft,omitempty"`
+
+	Provider string            `json:"provider,omitempty"`
+	Model    string            `json:"model,omitempty"`
+	APIKey   string            `json:"api_key,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+type BudgetStatus struct {
+	Budget Budget  `json:"budget"`
+	Spent  float64 `json:"spent"`
+}
+
+// Summary adds up the records of a provider, model and api key
+type Summary struct {
+	Provider     string  `json:"provider"`
+	Model        string  `json:"model"`
+	APIKey       string  `json:"api_key,omitempty"`
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+// Tracker records the usage of calls and enforces budgets, it is safe for concurrent use. Budgets are checked
+// before each request against its estimated cost, so concurrent requests may still exceed them slightly.
+//
+// The ledger only keeps the last records, see WithMaxRecords. The summary, the total cost and the budgets
+// still count the calls whose records were dropped.
+type Tracker struct {
+	mu sync.Mutex
+	// records is a ring buffer once it holds maxRecords records, oldest is the index of the oldest one
+	records    []Record
+	oldest     int
+	maxRecords int
+
+	summaries map[summaryKey]*Summary
+	totalCost float64
+	budgets   []*BudgetStatus
+
+	logger      *slog.Logger
+	onSoftLimit func(status BudgetStatus, estimate float64)
+}
+
+type TrackerOption interface {
+	TrackerOption()
+}
+
+type withBudgetOption struct {
+	Budget Budget
+}
+
+func (*withBudgetOption) TrackerOption() {}
+
+func WithBudget(budget Budget) *withBudgetOption {
+	return &withBudgetOption{Budget: budget}
+}
+
+type withMaxRecordsOption struct {
+	MaxRecords int
+}
+
+func (*withMaxRecordsOption) TrackerOption() {}
+
+// WithMaxRecords sets how many records the ledger keeps, the oldest ones are dropped first. DefaultMaxRecords is
+// used otherwise, and a negative value keeps no record at all, only the summary, total cost and budgets.
+func WithMaxRecords(maxRecords int) *withMaxRecordsOption {
+	return &withMaxRecordsOption{MaxRecords: maxRecords}
+}
+
+type withLoggerOption struct {
+	Logger *slog.Logger
+}
+
+func (*withLoggerOption) TrackerOption() {}
+
+// WithLogger sets the logger of the soft budget warnings, slog.Default() is used otherwise
+func WithLogger(logger *slog.Logger) *withLoggerOption {
+	return &withLoggerOption{Logger: logger}
+}
+
+type withSoftLimitHandlerOption struct {
+	Handler func(status BudgetStatus, estimate float64)
+}
+
+func (*withSoftLimitHandlerOption) TrackerOption() {}
+
+// WithSoftLimitHandler sets a callback called when a request would exceed a soft budget, before it is sent
+func WithSoftLimitHandler(handler func(status BudgetStatus, estimate float64)) *withSoftLimitHandlerOption {
+	return &withSoftLimitHandlerOption{Handler: handler}
+}
+
+func NewTracker(opts ...TrackerOption) (*Tracker, error) {
+	t := &Tracker{
+		maxRecords: DefaultMaxRecords,
+		summaries:  map[summaryKey]*Summary{},
+	}
+
+	for i := 0; i < len(opts); i++ {
+		switch o := opts[i].(type) {
+		default:
+			return nil, fmt.Errorf("unsupported tracker option %T", o)
+		case *withBudgetOption:
+			err := t.AddBudget(o.Budget)
+			if err != nil {
+				return nil, err
+			}
+		case *withMaxRecordsOption:
+			if o.MaxRecords == 0 {
+				return nil, fmt.Errorf("we need a positive number of records, or a negative one to keep none")
+			}
+			t.maxRecords = o.MaxRecords
+		case *withLoggerOption:
+			t.logger = o.Logger
+		case *withSoftLimitHandlerOption:
+			t.onSoftLimit = o.Handler
+		}
+	}
+
+	return t, nil
+}
+
+// AddBudget starts enforcing budget, the calls still in the ledger count toward it
+func (t *Tracker) AddBudget(budget Budget) error {
+	if budget.Limit <= 0 {
+		return fmt.Errorf("we need a positive limit for budget %s", budget.Name)
+	}
+	budget.APIKey = MaskAPIKey(budget.APIKey)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := &BudgetStatus{Budget: budget}
+	for i := range t.records {
+		if budget.matches(&t.records[i]) {
+			status.Spent += t.records[i].Cost
+		}
+	}
+	t.budgets = append(t.budgets, status)
+
+	return nil
+}
+
+// Check returns an error wrapping ErrBudgetExceeded if a call with the estimated usage would exceed a hard budget.
+// The tags of ctx are added to the estimate.
+func (t *Tracker) Check(ctx context.Context, estimate Record) error {
+	estimate.APIKey = MaskAPIKey(estimate.APIKey)
+	estimate.Tags = mergeTags(TagsFromContext(ctx), estimate.Tags)
+
+	t.mu.Lock()
+	var soft []BudgetStatus
+	for _, status := range t.budgets {
+		if !status.Budget.matches(&estimate) || status.Spent+estimate.Cost <= status.Budget.Limit {
+			continue
+		}
+		if !status.Budget.Soft {
+			t.mu.Unlock()
+			return fmt.Errorf("%w: %s has %f dollars left and the request is estimated to cost %f", ErrBudgetExceeded,
+				status.Budget.Name, status.Budget.Limit-status.Spent, estimate.Cost)
+		}
+		soft = append(soft, *status)
+	}
+	t.mu.Unlock()
+
+	for _, status := range soft {
+		t.log().Warn("the request exceeds a soft budget", "budget", status.Budget.Name, "limit", status.Budget.Limit,
+			"spent", status.Spent, "estimate", estimate.Cost, "provider", estimate.Provider, "model", estimate.Model)
+		if t.onSoftLimit != nil {
+			t.onSoftLimit(status, estimate.Cost)
+		}
+	}
+
+	return nil
+}
+
+// Record adds the usage of a call to the ledger, with the tags of ctx. Time defaults to now.
+func (t *Tracker) Record(ctx context.Context, record Record) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.APIKey = MaskAPIKey(record.APIKey)
+	record.Tags = mergeTags(TagsFromContext(ctx), record.Tags)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case t.maxRecords < 0:
+	case len(t.records) < t.maxRecords:
+		t.records = append(t.records, record)
+	default:
+		t.records[t.oldest] = record
+		t.oldest = (t.oldest + 1) % len(t.records)
+	}
+
+	k := summaryKey{record.Provider, record.Model, record.APIKey}
+	summary, ok := t.summaries[k]
+	if !ok {
+		summary = &Summary{Provider: record.Provider, Model: record.Model, APIKey: record.APIKey}
+		t.summaries[k] = summary
+	}
+	summary.Requests++
+	summary.InputTokens += record.InputTokens
+	summary.OutputTokens += record.OutputTokens
+	summary.Cost += record.Cost
+	t.totalCost += record.Cost
+
+	for _, status := range t.budgets {
+		if status.Budget.matches(&record) {
+			status.Spent += record.Cost
+		}
+	}
+}
+
+// Records returns a copy of the ledger, oldest first
+func (t *Tracker) Records() []Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	records := make([]Record, 0, len(t.records))
+	records = append(records, t.records[t.oldest:]...)
+	return append(records, t.records[:t.oldest]...)
+}
+
+func (t *Tracker) Budgets() []BudgetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := make([]BudgetStatus, len(t.budgets))
+	for i, status := range t.budgets {
+		statuses[i] = *status
+	}
+	return statuses
+}
+
+// TotalCost returns the cost of all the calls recorded, including those dropped from the ledger
+func (t *Tracker) TotalCost() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.totalCost
+}
+
+// Summary adds up the calls recorded by provider, model and api key, sorted in that order. It includes the calls
+// dropped from the ledger.
+func (t *Tracker) Summary() []Summary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	summaries := make([]Summary, 0, len(t.summaries))
+	for _, s := range t.summaries {
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.APIKey < b.APIKey
+	})
+
+	return summaries
+}
+
+// Reset clears the ledger and what was spent on the budgets
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.records = nil
+	t.oldest = 0
+	t.summaries = map[summaryKey]*Summary{}
+	t.totalCost = 0
+	for _, status := range t.budgets {
+		status.Spent = 0
+	}
+}
+
+// WriteCSV writes one row per record, the tags are encoded as key=value pairs separated by semicolons
+func (t *Tracker) WriteCSV(w io.Writer) error {
+	records := t.Records()
+
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"time", "provider", "operation", "model", "api_key", "input_tokens", "output_tokens", "cost", "tags"})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		tags := make([]string, 0, len(r.Tags))
+		for k, v := range r.Tags {
+			tags = append(tags, k+"="+v)
+		}
+		sort.Strings(tags)
+
+		err = cw.Write([]string{
+			r.Time.UTC().Format(time.RFC3339Nano),
+			r.Provider,
+			r.Operation,
+			r.Model,
+			r.APIKey,
+			strconv.Itoa(r.InputTokens),
+			strconv.Itoa(r.OutputTokens),
+			strconv.FormatFloat(r.Cost, 'f', -1, 64),
+			strings.Join(tags, ";"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the records, the summary and the budgets as a JSON object
+func (t *Tracker) WriteJSON(w io.Writer) error {
+	report := struct {
+		Records   []Record       `json:"records"`
+		Summary   []Summary      `json:"summary"`
+		Budgets   []BudgetStatus `json:"budgets,omitempty"`
+		TotalCost float64        `json:"total_cost"`
+	}{
+		Records:   t.Records(),
+		Summary:   t.Summary(),
+		Budgets:   t.Budgets(),
+		TotalCost: t.TotalCost(),
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func (t *Tracker) log() *slog.Logger {
+	if t.logger != nil {
+		return t.logger
+	}
+	return slog.Default()
+}
+
+func (b *Budget) matches(r *Record) bool {
+	if b.Provider != "" && b.Provider != r.Provider {
+		return false
+	}
+	if b.Model != "" && b.Model != r.Model {
+		return false
+	}
+	if b.APIKey != "" && b.APIKey != r.APIKey {
+		return false
+	}
+	for k, v := range b.Tags {
+		if r.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// MaskAPIKey keeps the last 4 characters of apikey, enough to tell keys apart in reports
+func MaskAPIKey(apikey string) string {
+	if len(apikey) <= 4 || strings.HasPrefix(apikey, "...") {
+		return apikey
+	}
+	return "..." + apikey[len(apikey)-4:]
+}
+
+type summaryKey struct{ provider, model, apikey string }
+
+type trackerKey struct{}
+type tagsKey struct{}
+
+// NewContext returns a copy of ctx carrying tracker, the calls made with it are recorded in tracker
+func NewContext(ctx context.Context, tracker *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, tracker)
+}
+
+// FromContext returns the tracker of ctx, or nil
+func FromContext(ctx context.Context) *Tracker {
+	tracker, _ := ctx.Value(trackerKey{}).(*Tracker)
+	return tracker
+}
+
+// WithTags returns a copy of ctx carrying tags, added to those already carried, for example a user or feature name
+func WithTags(ctx context.Context, tags map[string]string) context.Context {
+	return context.WithValue(ctx, tagsKey{}, mergeTags(TagsFromContext(ctx), tags))
+}
+
+func TagsFromContext(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(tagsKey{}).(map[string]string)
+	return tags
+}
+
+// mergeTags always returns a new map, so that the maps of the caller and of the ledger are never shared
+func mergeTags(base, extra map[string]string) map[string]string {
+	if len(base) == 0 && len(extra) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
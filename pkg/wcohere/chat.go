@@ -0,0 +1,154 @@
+package wcohere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
+)
+
+// ChatURL is the v1 chat endpoint, the chat of the cohere client does not support tools nor report the billed tokens
+var ChatURL = "https://api.cohere.com/v1/chat"
+
+type ChatRole string
+
+const (
+	ChatRoleUser    ChatRole = "USER"
+	ChatRoleChatbot ChatRole = "CHATBOT"
+	ChatRoleSystem  ChatRole = "SYSTEM"
+	ChatRoleTool    ChatRole = "TOOL"
+)
+
+type ChatRequest struct {
+	Model string `json:"model,omitempty"`
+	// Message is empty when ToolResults are sent
+	Message     string        `json:"message"`
+	Preamble    string        `json:"preamble,omitempty"`
+	ChatHistory []ChatMessage `json:"chat_history,omitempty"`
+
+	Temperature      float64  `json:"temperature,omitempty"`
+	MaxTokens        int      `json:"max_tokens,omitempty"`
+	P                float64  `json:"p,omitempty"`
+	StopSequences    []string `json:"stop_sequences,omitempty"`
+	FrequencyPenalty float64  `json:"frequency_penalty,omitempty"`
+	PresencePenalty  float64  `json:"presence_penalty,omitempty"`
+
+	Tools       []Tool       `json:"tools,omitempty"`
+	ToolResults []ToolResult `json:"tool_results,omitempty"`
+}
+
+type ChatMessage struct {
+	Role        ChatRole     `json:"role"`
+	Message     string       `json:"message,omitempty"`
+	ToolCalls   []ToolCall   `json:"tool_calls,omitempty"`
+	ToolResults []ToolResult `json:"tool_results,omitempty"`
+}
+
+type Tool struct {
+	Name                 string                             `json:"name"`
+	Description          string                             `json:"description"`
+	ParameterDefinitions map[string]ToolParameterDefinition `json:"parameter_definitions,omitempty"`
+}
+
+type ToolParameterDefinition struct {
+	Description string `json:"description,omitempty"`
+	// Type is a python type, such as str, int, float, bool, list or dict
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+type ToolCall struct {
+	Name       string                 `json:"name"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+type ToolResult struct {
+	Call    ToolCall                 `json:"call"`
+	Outputs []map[string]interface{} `json:"outputs"`
+}
+
+type ChatResponse struct {
+	Text         string     `json:"text"`
+	GenerationID string     `json:"generation_id"`
+	FinishReason string     `json:"finish_reason"`
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
+	Meta         *ChatMeta  `json:"meta,omitempty"`
+}
+
+type ChatMeta struct {
+	BilledUnits *BilledUnits `json:"billed_units,omitempty"`
+}
+
+type BilledUnits struct {
+	InputTokens  float64 `json:"input_tokens"`
+	OutputTokens float64 `json:"output_tokens"`
+}
+
+// Chat sends a chat request with apikey, or with the api key of the default client if it is empty. Rate limits,
+// server errors and timeouts are retried in the background, like the requests of the openai package.
+func Chat(ctx context.Context, apikey string, req *ChatRequest) (*ChatResponse, error) {
+	if apikey == "" {
+		apikey = defaultAPIKey
+	}
+	if apikey == "" {
+		return nil, fmt.Errorf("Cohere: we did not get an apikey for this request nor is a default client initialized")
+	}
+
+	resp := &ChatResponse{}
+	err := retrier.Request(ctx, &requests.RetryableRequest{
+		Method:   "POST",
+		URL:      ChatURL,
+		Body:     req,
+		Response: resp,
+		Headers: http.Header{
+			"Authorization": []string{"Bearer " + apikey},
+		},
+		HTTPTimeout: httpTimeout,
+		ParseErrBody: func(body []byte, err error, statusCode int, header http.Header, r *requests.RetryableRequest) error {
+			if err != nil {
+				return &StatusError{StatusCode: statusCode, Message: err.Error()}
+			}
+			apierr := &struct {
+				Message string `json:"message"`
+			}{}
+			if json.Unmarshal(body, apierr) == nil && apierr.Message != "" {
+				return &StatusError{StatusCode: statusCode, Message: apierr.Message}
+			}
+			return &StatusError{StatusCode: statusCode, Message: string(body)}
+		},
+		IsErrorFatal: isErrorFatal,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// StatusError is returned by Chat when the API answers with an error status code
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Cohere: status %d: %s", e.StatusCode, e.Message)
+}
+
+// isErrorFatal retries rate limits, server errors and transport errors such as timeouts
+func isErrorFatal(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	switch statusErr.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+
+	return statusErr.StatusCode >= 400 && statusErr.StatusCode < 500
+}
@@ -1,13 +1,30 @@
 package wcohere
 
 import (
+	"time"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
 	cohereclient "github.com/cohere-ai/cohere-go/v2/client"
 )
 
 var DefaultClient *cohereclient.Client
 
+// defaultAPIKey is used by Chat when no api key is given
+var defaultAPIKey string
+
+// retrier retries the failed requests of Chat, with the same policy as the openai package
+var retrier *requests.RequestRetrier
+
+const httpTimeout = 300 * time.Second
+
+func init() {
+	retrier = requests.NewRequestRetrier(30*time.Second, 7, 2)
+	retrier.Run()
+}
+
 func InitDefaultClient(defaultApiKey string) error {
 	DefaultClient = cohereclient.NewClient(cohereclient.WithToken(defaultApiKey))
+	defaultAPIKey = defaultApiKey
 
 	return nil
 }
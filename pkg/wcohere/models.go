@@ -14,6 +14,17 @@ const (
 	Command string = "command"
 )
 
+// Chat models
+const (
+	// CommandR is a conversational model for retrieval augmented generation and tool use.
+	// Max Tokens: 128k. Endpoint: Co.chat()
+	CommandR string = "command-r"
+
+	// CommandRPlus is a larger version of CommandR for complex tasks and multi-step tool use.
+	// Max Tokens: 128k. Endpoint: Co.chat()
+	CommandRPlus string = "command-r-plus"
+)
+
 // Generation models
 const (
 	// BaseLight is a smaller, faster version of Base.
@@ -44,6 +55,16 @@ const (
 	// Max Tokens: 256. Similarity Metric: Dot Product Similarity.
 	// Endpoints: Co.Classify(), Co.Embed(), Co.Detect_language(), Co.Tokenize(), Co.Detokenize()
 	EmbedMultilingualV2 string = "embed-multilingual-v2.0"
+
+	// EmbedEnglishV3 needs an input type. It supports English only.
+	// Dimensions: 1024. Similarity Metric: Cosine Similarity.
+	// Endpoint: Co.Embed()
+	EmbedEnglishV3 string = "embed-english-v3.0"
+
+	// EmbedMultilingualV3 needs an input type.
+	// Dimensions: 1024. Similarity Metric: Cosine Similarity.
+	// Endpoint: Co.Embed()
+	EmbedMultilingualV3 string = "embed-multilingual-v3.0"
 )
 
 // Rerank models
@@ -18,6 +18,25 @@ func GetGenerateRequestPrice(numTokens int, model string) (float64, error) {
 
 }
 
+// GetChatRequestPrice returns the price of a chat request in dollars from the billed input and output tokens
+func GetChatRequestPrice(model string, inputTokens, outputTokens int) (float64, error) {
+	var input, output float64
+	switch model {
+	case CommandRPlus:
+		input, output = 0.0000025, 0.00001
+	case CommandR:
+		input, output = 0.00000015, 0.0000006
+	case Command:
+		input, output = 0.000001, 0.000002
+	case CommandLight:
+		input, output = 0.0000003, 0.0000006
+	default:
+		return 0, fmt.Errorf("We do not know the price of the chat model %s", model)
+	}
+
+	return float64(inputTokens)*input + float64(outputTokens)*output, nil
+}
+
 func GetSummarizeRequestPrice(numTokens int) float64 {
 	return float64(numTokens) * 0.000015
 }
@@ -1,6 +1,7 @@
 package wikipedia
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strconv"
@@ -50,7 +51,7 @@ func NewWikipediaClient() (*WikipediaAPIClient, error) {
 }
 
 // GetPrefixResults retrieves a list of Wikipedia pages based on a query string
-func (wk *WikipediaAPIClient) GetPrefixResults(pfx string, limit int) ([]WikipediaPage, error) {
+func (wk *WikipediaAPIClient) GetPrefixResults(ctx context.Context, pfx string, limit int) ([]WikipediaPage, error) {
 	if limit == 0 {
 		limit = 50
 	}
@@ -65,7 +66,7 @@ func (wk *WikipediaAPIClient) GetPrefixResults(pfx string, limit int) ([]Wikiped
 		"gpslimit":     {strconv.Itoa(limit)},
 	}
 
-	res, err := wk.w.Query(f)
+	res, err := wk.w.Query(ctx, f)
 	if err != nil {
 		return nil, err
 	}
@@ -83,13 +84,13 @@ func (wk *WikipediaAPIClient) GetPrefixResults(pfx string, limit int) ([]Wikiped
 }
 
 // GetExtracts retrieves the extracts for a given list of titles.
-func (wk *WikipediaAPIClient) GetExtracts(titles []string) ([]WikipediaPageFull, error) {
+func (wk *WikipediaAPIClient) GetExtracts(ctx context.Context, titles []string) ([]WikipediaPageFull, error) {
 	f := url.Values{
 		"action": {"query"},
 		"prop":   {"extracts"},
 		"titles": {strings.Join(titles[:], "|")},
 	}
-	res, err := wk.w.Query(f)
+	res, err := wk.w.Query(ctx, f)
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +111,7 @@ func (wk *WikipediaAPIClient) GetExtracts(titles []string) ([]WikipediaPageFull,
 }
 
 // GetCategories retrieves the categories associated with a specified Wikipedia article.
-func (wk *WikipediaAPIClient) GetCategories(pageid int) (WikipediaPageFull, error) {
+func (wk *WikipediaAPIClient) GetCategories(ctx context.Context, pageid int) (WikipediaPageFull, error) {
 	var value WikipediaPageFull
 
 	f := url.Values{
@@ -119,7 +120,7 @@ func (wk *WikipediaAPIClient) GetCategories(pageid int) (WikipediaPageFull, erro
 		"prop":   {"categories"},
 	}
 
-	res, err := wk.w.Query(f)
+	res, err := wk.w.Query(ctx, f)
 	if err != nil {
 		return value, err
 	}
@@ -137,7 +138,7 @@ func (wk *WikipediaAPIClient) GetCategories(pageid int) (WikipediaPageFull, erro
 }
 
 // GetSections retrieves the sections within a specified Wikipedia article.
-func (wk *WikipediaAPIClient) GetSections(pageid int) (WikipediaPageFull, error) {
+func (wk *WikipediaAPIClient) GetSections(ctx context.Context, pageid int) (WikipediaPageFull, error) {
 	var value WikipediaPageFull
 
 	f := url.Values{
@@ -146,7 +147,7 @@ func (wk *WikipediaAPIClient) GetSections(pageid int) (WikipediaPageFull, error)
 		"prop":   {"sections"},
 	}
 
-	res, err := wk.w.Query(f)
+	res, err := wk.w.Query(ctx, f)
 	if err != nil {
 		return value, err
 	}
@@ -1,11 +1,14 @@
 package wikipedia
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/arthurweinmann/go-ai-sdk/pkg/telemetry"
 )
 
 // A Wikimedia API response
@@ -123,29 +126,42 @@ type Wikimedia struct {
 	url string
 }
 
-func (w *Wikimedia) get(url string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func (w *Wikimedia) get(ctx context.Context, url string) (*http.Response, error) {
+	ctx, span := telemetry.StartHTTP(ctx, "wikipedia", "GET", url)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
+		span.End(0, err)
 		return nil, err
 	}
 	if w.UserAgent != "" {
 		req.Header.Add("User-Agent", w.UserAgent)
 	}
+
+	client := http.DefaultClient
 	if w.Client != nil {
-		return w.Client.Do(req)
+		client = w.Client
 	}
-	return http.DefaultClient.Do(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		span.End(0, err)
+		return nil, err
+	}
+
+	span.End(resp.StatusCode, nil)
+	return resp, nil
 }
 
 // Queries the Wikimedia API using the specified values, and returns an
 // ApiResponse. See http://en.wikipedia.org/w/api.php for a reference.
-func (w *Wikimedia) Query(vals url.Values) (*ApiResponse, error) {
+func (w *Wikimedia) Query(ctx context.Context, vals url.Values) (*ApiResponse, error) {
 	vals["format"] = []string{"json"}
 	if w.url == "" {
 		w.url = w.Url.String()
 	}
 	u := fmt.Sprintf("%s?%s", w.url, vals.Encode())
-	res, err := w.get(u)
+	res, err := w.get(ctx, u)
 	if err != nil {
 		return nil, err
 	}
@@ -153,6 +169,9 @@ func (w *Wikimedia) Query(vals url.Values) (*ApiResponse, error) {
 
 	var api ApiResponse
 	err = json.NewDecoder(res.Body).Decode(&api)
+	if err != nil {
+		return nil, err
+	}
 	if w.StripHtml {
 		api.StripHtml()
 	}
@@ -1,14 +1,41 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/arthurweinmann/go-ai-sdk/pkg/openai"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/openai/tokenizer"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/requests"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/uni"
+	"github.com/arthurweinmann/go-ai-sdk/pkg/usage"
 	"github.com/arthurweinmann/go-ai-sdk/pkg/wcohere"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestSetup(t *testing.T) {
@@ -77,7 +104,7 @@ func TestCreateChatCompletion(t *testing.T) {
 		}},
 	}
 
-	resp, err := openai.CreateChatCompletion(req)
+	resp, err := openai.CreateChatCompletion(context.Background(), req)
 	if err != nil {
 		t.Fatal("Error:", err)
 		return
@@ -95,7 +122,7 @@ func TestCreateChatCompletion(t *testing.T) {
 	})
 
 	// Make another request with the updated messages
-	resp, err = openai.CreateChatCompletion(req)
+	resp, err = openai.CreateChatCompletion(context.Background(), req)
 	if err != nil {
 		t.Fatal("Error:", err)
 		return
@@ -108,3 +135,2147 @@ func TestCreateChatCompletion(t *testing.T) {
 		t.Fatal("unexpected response")
 	}
 }
+
+func TestCreateChatCompletionStream(t *testing.T) {
+	stream, err := openai.CreateChatCompletionStream(context.Background(), &openai.ChatCompletionRequest{
+		Model: openai.GPT3_5_turbo_4k,
+		Messages: []openai.ChatCompletionMessage{{
+			Role:    "user",
+			Content: "Count from 1 to 5, separated by commas",
+		}},
+	})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	defer stream.Close()
+
+	var content string
+	var chunks int
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		for _, c := range chunk.Choices {
+			content += c.Delta.Content
+		}
+		chunks++
+	}
+
+	resp := stream.Response()
+	if chunks < 2 {
+		t.Fatalf("we expected the completion to be streamed in multiple chunks, got %d", chunks)
+	}
+	if resp.Choices[0].Message.Content != content {
+		t.Fatalf("the assembled response %q does not match the streamed content %q", resp.Choices[0].Message.Content, content)
+	}
+	if resp.Usage.TotalTokens == 0 || resp.Price == 0 {
+		t.Fatalf("we expected the usage and price to be reported at the end of the stream")
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	// Reference tokens of tiktoken, given by its regular expressions and byte pair merges
+	for _, tc := range []struct {
+		text     string
+		expected map[string][]int
+	}{
+		{"hello world", map[string][]int{
+			tokenizer.Cl100kBase: {15339, 1917},
+			tokenizer.O200kBase:  {24912, 2375},
+			tokenizer.P50kBase:   {31373, 995},
+		}},
+		// Long words, this one and the next are examples of the openai cookbook
+		{"antidisestablishmentarianism", map[string][]int{
+			tokenizer.Cl100kBase: {519, 85342, 34500, 479, 8997, 2191},
+			tokenizer.O200kBase:  {493, 129901, 376, 160388, 21203, 2367},
+			tokenizer.P50kBase:   {415, 29207, 44390, 3699, 1042},
+		}},
+		{"お誕生日おめでとう", map[string][]int{
+			tokenizer.Cl100kBase: {33334, 45918, 243, 21990, 9080, 33334, 62004, 16556, 78699},
+			tokenizer.O200kBase:  {8930, 9697, 243, 128225, 8930, 17693, 4344, 48669},
+			tokenizer.P50kBase:   {2515, 232, 45739, 243, 37955, 33768, 98, 2515, 232, 1792, 223, 30640, 30201, 29557},
+		}},
+		// Non-ASCII text, split in the middle of multi-byte characters
+		{"Ünïcödé café, Привет мир! 你好，世界 🤖", map[string][]int{
+			tokenizer.Cl100kBase: {53591, 77, 38672, 66, 3029, 67, 978, 53050, 11, 80584, 28089, 8341, 11562, 78746, 0, 220, 57668, 53901, 3922, 3574, 244, 98220, 11410, 97, 244},
+			tokenizer.O200kBase:  {8858, 77, 191375, 43369, 377, 30469, 11, 14917, 131903, 37934, 0, 220, 177519, 979, 28428, 93643, 244},
+			tokenizer.P50kBase:   {127, 250, 77, 26884, 66, 9101, 67, 2634, 40304, 11, 12466, 253, 21169, 18849, 38857, 16843, 20375, 12466, 120, 18849, 21169, 0, 220, 19526, 254, 25001, 121, 171, 120, 234, 10310, 244, 45911, 234, 12520, 97, 244},
+		}},
+		{"pneumonoultramicroscopicsilicovolcanoconiosis", map[string][]int{
+			tokenizer.Cl100kBase: {79, 818, 372, 263, 11206, 99040, 2823, 2445, 454, 1233, 321, 292, 869, 337, 69377, 444, 91260},
+			tokenizer.O200kBase:  {79, 611, 394, 263, 9826, 371, 26169, 2199, 47750, 1541, 112176, 47186, 6929, 29452, 156038},
+			tokenizer.P50kBase:   {79, 25668, 261, 25955, 859, 2500, 1416, 404, 873, 41896, 709, 349, 5171, 36221, 42960},
+		}},
+		// Whitespace runs, the last space of a run goes with the next word
+		{"a    b\t\t c  \n\n\n   d   ", map[string][]int{
+			tokenizer.Cl100kBase: {64, 262, 293, 298, 272, 80326, 256, 294, 262},
+			tokenizer.O200kBase:  {64, 271, 287, 335, 274, 145331, 256, 272, 271},
+			tokenizer.P50kBase:   {64, 50258, 275, 197, 197, 269, 50257, 628, 198, 50257, 288, 50258},
+		}},
+		// Contractions, case insensitive for cl100k_base and o200k_base
+		{"I'm sure they'll say it's DONE'S", map[string][]int{
+			tokenizer.Cl100kBase: {40, 2846, 2771, 814, 3358, 2019, 433, 596, 55785, 13575},
+			tokenizer.O200kBase:  {15390, 3239, 57956, 2891, 4275, 113799, 31233},
+			tokenizer.P50kBase:   {40, 1101, 1654, 484, 1183, 910, 340, 338, 360, 11651, 6, 50},
+		}},
+		// Numbers are split in groups of 3 digits for cl100k_base and o200k_base
+		{"1234567 3.14", map[string][]int{
+			tokenizer.Cl100kBase: {4513, 10961, 22, 220, 18, 13, 975},
+			tokenizer.O200kBase:  {7633, 19354, 22, 220, 18, 13, 1265},
+			tokenizer.P50kBase:   {10163, 2231, 3134, 513, 13, 1415},
+		}},
+	} {
+		for name, expected := range tc.expected {
+			enc, err := tokenizer.Get(name)
+			if err != nil {
+				t.Fatal("Error:", err)
+			}
+
+			tokens := enc.Encode(tc.text)
+			if fmt.Sprint(tokens) != fmt.Sprint(expected) {
+				t.Fatalf("%s: %q: we got tokens %v instead of %v", name, tc.text, tokens, expected)
+			}
+			if n := enc.Count(tc.text); n != len(expected) {
+				t.Fatalf("%s: %q: we counted %d tokens instead of %d", name, tc.text, n, len(expected))
+			}
+
+			text, err := enc.Decode(tokens)
+			if err != nil {
+				t.Fatal("Error:", err)
+			}
+			if text != tc.text {
+				t.Fatalf("%s: we decoded %q instead of %q", name, text, tc.text)
+			}
+		}
+	}
+
+	for _, name := range []string{tokenizer.Cl100kBase, tokenizer.O200kBase, tokenizer.P50kBase} {
+		enc, err := tokenizer.Get(name)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+
+		// Example from the openai cookbook
+		if n := enc.Count("tiktoken is great!"); n != 6 {
+			t.Fatalf("%s: we counted %d tokens instead of 6", name, n)
+		}
+	}
+}
+
+func TestTelemetry(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"chatcmpl-1","model":"gpt-4-0613","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`)
+	}))
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	resp, err := client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+		Model: openai.GPT4_8k,
+		Messages: []openai.ChatCompletionMessage{{
+			Role:    "user",
+			Content: "Hello!",
+		}},
+	})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("we expected 1 span, got %d", len(spans))
+	}
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["gen_ai.system"].AsString() != "openai" || attrs["gen_ai.request.model"].AsString() != string(openai.GPT4_8k) ||
+		attrs["gen_ai.usage.input_tokens"].AsInt64() != 10 || attrs["gen_ai.usage.output_tokens"].AsInt64() != 2 ||
+		attrs["gen_ai.usage.cost"].AsFloat64() != resp.Price || fmt.Sprint(attrs["gen_ai.response.finish_reasons"].AsStringSlice()) != "[stop]" {
+		t.Fatalf("unexpected span attributes: %v", spans[0].Attributes)
+	}
+
+	var rm metricdata.ResourceMetrics
+	err = reader.Collect(context.Background(), &rm)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	found := map[string]bool{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			found[m.Name] = true
+		}
+	}
+	for _, name := range []string{"gen_ai.client.operation.duration", "gen_ai.client.token.usage", "gen_ai.client.cost"} {
+		if !found[name] {
+			t.Fatalf("metric %s was not recorded", name)
+		}
+	}
+}
+
+func TestValidateFineTuningFileWithTools(t *testing.T) {
+	example := `{"messages":[{"role":"user","content":"What is the weather in Paris?"},` +
+		`{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_current_weather","arguments":"{\"location\":\"Paris\"}"}}]}],` +
+		`"tools":[{"type":"function","function":{"name":"get_current_weather","description":"Get the current weather",` +
+		`"parameters":{"type":"object","properties":{"location":{"type":"string","description":"The city"}},"required":["location"]}}}]}`
+
+	var file strings.Builder
+	for i := 0; i < 10; i++ {
+		file.WriteString(example + "\n")
+	}
+
+	report, err := openai.ValidateFineTuningFile(strings.NewReader(file.String()), openai.GPT4o_Mini_128k, 3)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if !report.Valid() || len(report.Warnings) > 0 {
+		t.Fatalf("unexpected issues: %v %v", report.Errors, report.Warnings)
+	}
+	if report.Examples != 10 || report.TotalTokens == 0 || report.EstimatedPrice == 0 {
+		t.Fatalf("the tokens of the examples were not counted: %+v", report)
+	}
+
+	// A fine-tuned model is trained further at the price of its base model
+	fineTuned := openai.Model("ft:" + string(openai.GPT4o_Mini_128k) + ":my-org:custom:abc123")
+	ftReport, err := openai.ValidateFineTuningFile(strings.NewReader(file.String()), fineTuned, 3)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ftReport.EstimatedPrice != report.EstimatedPrice {
+		t.Fatalf("we expected the training price of the base model, got %f instead of %f", ftReport.EstimatedPrice, report.EstimatedPrice)
+	}
+
+	var decoded openai.FineTuningExample
+	err = json.Unmarshal([]byte(example), &decoded)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	schema, ok := decoded.Tools[0].Function.Parameters.(*openai.JSONSchema)
+	if !ok || schema.Properties["location"].Type != "string" {
+		t.Fatalf("the parameters were not decoded into a JSONSchema: %#v", decoded.Tools[0].Function.Parameters)
+	}
+}
+
+func TestRateLimiterUnknownLimits(t *testing.T) {
+	limiter := openai.NewRateLimiter()
+
+	// Requests sent before the limits are known are not counted against them
+	for i := 0; i < 50; i++ {
+		err := limiter.Wait(context.Background(), "key", openai.GPT4_8k, 2000)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("X-Ratelimit-Limit-Requests", "500")
+	header.Set("X-Ratelimit-Limit-Tokens", "30000")
+	header.Set("X-Ratelimit-Remaining-Requests", "499")
+	header.Set("X-Ratelimit-Remaining-Tokens", "28000")
+	limiter.Update("key", openai.GPT4_8k, http.StatusOK, header)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := limiter.Wait(ctx, "key", openai.GPT4_8k, 2000)
+	if err != nil {
+		t.Fatalf("we should not wait with 28000 tokens remaining: %v", err)
+	}
+
+	// The remaining capacity reported by the API lowers ours
+	header.Set("X-Ratelimit-Remaining-Tokens", "0")
+	limiter.Update("key", openai.GPT4_8k, http.StatusOK, header)
+	err = limiter.Wait(ctx, "key", openai.GPT4_8k, 2000)
+	if !errors.Is(err, openai.Err429) {
+		t.Fatalf("we expected Err429, got %v", err)
+	}
+}
+
+func TestRateLimiterRemainingHeaders(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("X-Ratelimit-Limit-Requests", "100")
+		w.Header().Set("X-Ratelimit-Limit-Tokens", "1000")
+		w.Header().Set("X-Ratelimit-Remaining-Requests", "99")
+		w.Header().Set("X-Ratelimit-Remaining-Tokens", "0")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","model":"gpt-4-0613","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`)
+	}))
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL), openai.WithRateLimiter(openai.NewRateLimiter()))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	defer client.Close()
+
+	req := &openai.ChatCompletionRequest{
+		Model:     openai.GPT4_8k,
+		MaxTokens: 100,
+		Messages: []openai.ChatCompletionMessage{{
+			Role:    "user",
+			Content: "Hello!",
+		}},
+	}
+
+	_, err = client.CreateChatCompletion(context.Background(), req)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	// No tokens remain for the next minute, so the next request cannot be sent before its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = client.CreateChatCompletion(ctx, req)
+	if !errors.Is(err, openai.Err429) {
+		t.Fatalf("we expected Err429, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("the rate limited request should not reach the server, it got %d requests", n)
+	}
+}
+
+func TestChatCompletionStreamErrors(t *testing.T) {
+	chunk := `data: {"id":"chatcmpl-1","model":"gpt-4-0613","choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"}}]}` + "\n\n"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/slow/"):
+			// Longer than the HTTP timeout of the client, which only applies to the headers of a stream
+			for i := 0; i < 5; i++ {
+				fmt.Fprint(w, chunk)
+				w.(http.Flusher).Flush()
+				time.Sleep(100 * time.Millisecond)
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		default:
+			// The connection is cut before [DONE]
+			fmt.Fprint(w, chunk)
+		}
+	}))
+	defer srv.Close()
+
+	for _, c := range []string{"broken", "slow"} {
+		client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL+"/"+c+"/"), openai.WithHTTPTimeout(200*time.Millisecond))
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+
+		stream, err := client.CreateChatCompletionStream(context.Background(), &openai.ChatCompletionRequest{
+			Model: openai.GPT4_8k,
+			Messages: []openai.ChatCompletionMessage{{
+				Role:    "user",
+				Content: "Hello!",
+			}},
+		})
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+
+		var chunks int
+		for {
+			_, err = stream.Recv()
+			if err != nil {
+				break
+			}
+			chunks++
+		}
+
+		switch c {
+		case "broken":
+			if err == io.EOF {
+				t.Fatalf("a broken stream must not end with io.EOF")
+			}
+			_, again := stream.Recv()
+			if again != err {
+				t.Fatalf("we expected the error %v again, got %v", err, again)
+			}
+		case "slow":
+			if err != io.EOF || chunks != 5 {
+				t.Fatalf("we expected 5 chunks and io.EOF, got %d chunks and %v", chunks, err)
+			}
+		}
+		stream.Close()
+	}
+}
+
+func TestChatCompletionStreamInvalidIndexes(t *testing.T) {
+	chunks := map[string]string{
+		"negative-choice": `{"id":"chatcmpl-1","choices":[{"index":-1,"delta":{"content":"Hi"}}]}`,
+		"huge-choice":     `{"id":"chatcmpl-1","choices":[{"index":1000000000,"delta":{"content":"Hi"}}]}`,
+		"negative-tool":   `{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":-1,"id":"call_1"}]}}]}`,
+		"huge-tool":       `{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1000000000,"id":"call_1"}]}}]}`,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		name := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")[0]
+		fmt.Fprint(w, "data: "+chunks[name]+"\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	for name := range chunks {
+		client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL+"/"+name+"/"))
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+
+		stream, err := client.CreateChatCompletionStream(context.Background(), &openai.ChatCompletionRequest{
+			Model:    openai.GPT4_8k,
+			Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hello!"}},
+		})
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+
+		_, err = stream.Recv()
+		if err == nil || err == io.EOF {
+			t.Fatalf("%s: we expected the stream to fail, got %v", name, err)
+		}
+		stream.Close()
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error":{"message":"server error","type":"server_error"}}`)
+	}))
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL), openai.WithRetryPolicy(time.Minute, 3, 2))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.Close()
+	}()
+
+	_, err = client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+		Model: openai.GPT4_8k,
+		Messages: []openai.ChatCompletionMessage{{
+			Role:    "user",
+			Content: "Hello!",
+		}},
+	})
+	if !errors.Is(err, requests.ErrRetrierStopped) {
+		t.Fatalf("we expected ErrRetrierStopped, got %v", err)
+	}
+}
+
+func TestTranscriptionResponseFormat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f := r.FormValue("response_format"); f != "verbose_json" {
+			t.Errorf("we expected the verbose_json response format for the duration, the server got %q", f)
+		}
+		fmt.Fprint(w, `{"task":"transcribe","language":"english","duration":60,"text":"Hello world","segments":[{"id":0,"start":0,"end":60,"text":"Hello world"}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	for _, format := range []openai.AudioResponseFormat{"", openai.AudioResponseFormatJSON, openai.AudioResponseFormatText} {
+		resp, err := client.CreateTranscription(context.Background(), &openai.TranscriptionRequest{
+			File:           strings.NewReader("audio"),
+			FileName:       "audio.mp3",
+			ResponseFormat: format,
+		})
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if resp.Text != "Hello world" || resp.Duration != 60 || resp.Price != 60*0.0001 || resp.Segments != nil || resp.Language != "" {
+			t.Fatalf("%s: unexpected response %+v", format, resp)
+		}
+	}
+}
+
+func TestUsageTrackerMaxRecords(t *testing.T) {
+	tracker, err := usage.NewTracker(usage.WithMaxRecords(2))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	tags := map[string]string{"user": "42"}
+	for i := 1; i <= 3; i++ {
+		tracker.Record(context.Background(), usage.Record{Provider: "openai", Model: "gpt-4", InputTokens: i, Cost: float64(i), Tags: tags})
+	}
+	tags["user"] = "43"
+
+	records := tracker.Records()
+	if len(records) != 2 || records[0].InputTokens != 2 || records[1].InputTokens != 3 {
+		t.Fatalf("we expected the last 2 records, oldest first, got %+v", records)
+	}
+	if records[0].Tags["user"] != "42" {
+		t.Fatalf("the record tags must be a copy of the caller ones, got %v", records[0].Tags)
+	}
+
+	summary := tracker.Summary()
+	if tracker.TotalCost() != 6 || len(summary) != 1 || summary[0].Requests != 3 || summary[0].InputTokens != 6 {
+		t.Fatalf("we expected the dropped records to still be counted, got %v and %+v", tracker.TotalCost(), summary)
+	}
+
+	tracker, err = usage.NewTracker(usage.WithMaxRecords(-1))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	tracker.Record(context.Background(), usage.Record{Provider: "openai", Model: "gpt-4", Cost: 1})
+	if len(tracker.Records()) != 0 || tracker.TotalCost() != 1 {
+		t.Fatalf("we expected no record to be kept, only the total cost")
+	}
+
+	ctx := usage.WithTags(context.Background(), map[string]string{"plan": "free"})
+	tracker, err = usage.NewTracker()
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	tracker.Record(ctx, usage.Record{Provider: "openai", Model: "gpt-4"})
+	tracker.Records()[0].Tags["plan"] = "paid"
+	if usage.TagsFromContext(ctx)["plan"] != "free" {
+		t.Fatalf("the records must not share their tags with the context")
+	}
+}
+
+func newTestRetryableRequest(url string) *requests.RetryableRequest {
+	return &requests.RetryableRequest{
+		Method:   "GET",
+		URL:      url,
+		Response: &map[string]any{},
+		ParseErrBody: func(body []byte, err error, statusCode int, header http.Header, r *requests.RetryableRequest) error {
+			return fmt.Errorf("status %d: %s", statusCode, body)
+		},
+		IsErrorFatal: func(error) bool { return false },
+	}
+}
+
+func TestRequestRetrierRetryAfter(t *testing.T) {
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+
+	retrier := requests.NewRequestRetrier(10*time.Millisecond, 3, 2)
+	retrier.Run()
+	defer retrier.Stop()
+
+	r := newTestRetryableRequest(srv.URL)
+	start := time.Now()
+	err := retrier.Request(context.Background(), r)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 2*time.Second {
+		t.Fatalf("we expected the retry to wait for the Retry-After header, it took %v", elapsed)
+	}
+	if r.Attempts() != 2 {
+		t.Fatalf("we expected 2 attempts, got %d", r.Attempts())
+	}
+}
+
+func TestRequestRetrierTimerHeap(t *testing.T) {
+	var slowCalls, fastCalls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			if slowCalls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "2")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/fast":
+			if fastCalls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+
+	// A single retry at a time, the fast request must not wait for the slow one queued before it
+	retrier := requests.NewRequestRetrier(50*time.Millisecond, 3, 2, requests.WithMaxConcurrentRetries(1))
+	retrier.Run()
+	defer retrier.Stop()
+
+	slowDone := make(chan time.Time, 1)
+	go func() {
+		err := retrier.Request(context.Background(), newTestRetryableRequest(srv.URL+"/slow"))
+		if err != nil {
+			t.Error("Error:", err)
+		}
+		slowDone <- time.Now()
+	}()
+	for slowCalls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	err := retrier.Request(context.Background(), newTestRetryableRequest(srv.URL+"/fast"))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	fastDone := time.Now()
+
+	if slow := <-slowDone; !fastDone.Before(slow.Add(-time.Second)) {
+		t.Fatalf("we expected the fast request to be retried long before the slow one")
+	}
+}
+
+func TestRequestRetrierCancelInFlight(t *testing.T) {
+	var calls atomic.Int64
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		close(inFlight)
+		<-release
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	var afterResponse atomic.Int64
+	retrier := requests.NewRequestRetrier(10*time.Millisecond, 3, 2)
+	retrier.Run()
+	defer retrier.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-inFlight
+		cancel()
+	}()
+
+	r := newTestRetryableRequest(srv.URL)
+	r.Hooks = &requests.Hooks{
+		AfterResponse: func(ctx context.Context, info *requests.RequestInfo) {
+			afterResponse.Add(1)
+		},
+	}
+	err := retrier.Request(ctx, r)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("we expected context.Canceled, got %v", err)
+	}
+
+	// The abandoned attempt still finishes in the background, without being retried
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Attempts() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if r.Attempts() != 2 || afterResponse.Load() != 2 {
+		t.Fatalf("we expected 2 attempts, got %d", r.Attempts())
+	}
+}
+
+func randomVectors(rng *rand.Rand, n, dim int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = float32(rng.NormFloat64())
+		}
+	}
+	return vectors
+}
+
+func TestIndexHNSWRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vectors := randomVectors(rng, 1000, 32)
+
+	exact := uni.NewIndex()
+	approx := uni.NewIndex(uni.WithHNSW(0, 0, 0))
+	for i, v := range vectors {
+		id := fmt.Sprint(i)
+		metadata := map[string]any{"even": i%2 == 0}
+		if err := exact.Upsert(id, uni.SingleProviderEmbeddingFrom32(v), metadata); err != nil {
+			t.Fatal("Error:", err)
+		}
+		if err := approx.Upsert(id, uni.SingleProviderEmbeddingFrom32(v), metadata); err != nil {
+			t.Fatal("Error:", err)
+		}
+	}
+
+	const k = 10
+	var found, total int
+	for _, q := range randomVectors(rng, 100, 32) {
+		query := uni.SingleProviderEmbeddingFrom32(q)
+		want, err := exact.Search(query, k, nil)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		got, err := approx.Search(query, k, nil)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if len(want) != k || len(got) != k {
+			t.Fatalf("we expected %d results, got %d and %d", k, len(want), len(got))
+		}
+		for i := 1; i < k; i++ {
+			if want[i].Score > want[i-1].Score {
+				t.Fatalf("the exact results are not sorted by score")
+			}
+		}
+
+		ids := map[string]bool{}
+		for _, r := range want {
+			ids[r.ID] = true
+		}
+		for _, r := range got {
+			if ids[r.ID] {
+				found++
+			}
+		}
+		total += k
+
+		filtered, err := approx.Search(query, k, uni.MetadataEquals("even", true))
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if len(filtered) != k {
+			t.Fatalf("we expected %d filtered results, got %d", k, len(filtered))
+		}
+		for _, r := range filtered {
+			if r.Metadata["even"] != true {
+				t.Fatalf("the filter let entry %s through", r.ID)
+			}
+		}
+	}
+
+	if recall := float64(found) / float64(total); recall < 0.99 {
+		t.Fatalf("the recall of the HNSW index is %f", recall)
+	}
+}
+
+func TestIndexMetadataCopies(t *testing.T) {
+	ix := uni.NewIndex(uni.WithHNSW(0, 0, 0))
+	metadata := map[string]any{"lang": "en"}
+	err := ix.Upsert("a", uni.SingleProviderEmbeddingFrom32([]float32{1, 0}), metadata)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	metadata["lang"] = "fr"
+
+	_, got, ok := ix.Get("a")
+	if !ok || got["lang"] != "en" {
+		t.Fatalf("we expected the metadata of the upsert, got %v", got)
+	}
+	got["lang"] = "de"
+
+	results, err := ix.Search(uni.SingleProviderEmbeddingFrom32([]float32{1, 0}), 1, nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if len(results) != 1 || results[0].Metadata["lang"] != "en" {
+		t.Fatalf("we expected the metadata of the index to be left untouched, got %+v", results)
+	}
+	results[0].Metadata["lang"] = "de"
+
+	_, got, _ = ix.Get("a")
+	if got["lang"] != "en" {
+		t.Fatalf("we expected the metadata of the index to be left untouched, got %v", got)
+	}
+}
+
+// The fields of the index file of uni, gob matches them by name
+type testIndexFile struct {
+	Version  int
+	Metric   uni.Metric
+	HNSW     *struct{ M, EfConstruction, EfSearch int }
+	Dim      int
+	Entry    int32
+	MaxLevel int
+	Nodes    []testIndexFileNode
+}
+
+type testIndexFileNode struct {
+	ID        string
+	Vector    []float32
+	Neighbors [][]int32
+}
+
+func TestLoadIndexInvalidGraph(t *testing.T) {
+	valid := func() *testIndexFile {
+		return &testIndexFile{
+			Version:  1,
+			Metric:   uni.MetricCosine,
+			HNSW:     &struct{ M, EfConstruction, EfSearch int }{16, 200, 50},
+			Dim:      2,
+			Entry:    0,
+			MaxLevel: 1,
+			Nodes: []testIndexFileNode{
+				{ID: "a", Vector: []float32{1, 0}, Neighbors: [][]int32{{1}, {1}}},
+				{ID: "b", Vector: []float32{0, 1}, Neighbors: [][]int32{{0}, {0}}},
+			},
+		}
+	}
+
+	load := func(file *testIndexFile) (*uni.Index, error) {
+		var buf strings.Builder
+		if err := gob.NewEncoder(&buf).Encode(file); err != nil {
+			t.Fatal("Error:", err)
+		}
+		return uni.LoadIndex(strings.NewReader(buf.String()))
+	}
+
+	ix, err := load(valid())
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if _, err = ix.Search(uni.SingleProviderEmbeddingFrom32([]float32{1, 0}), 2, nil); err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	missingLevel := valid()
+	missingLevel.Nodes[1].Neighbors = [][]int32{{0}}
+	if _, err = load(missingLevel); err == nil {
+		t.Fatalf("we expected an error for a neighbor linked at a level it does not have")
+	}
+
+	maxLevel := valid()
+	maxLevel.MaxLevel = 2
+	if _, err = load(maxLevel); err == nil {
+		t.Fatalf("we expected an error for a max level above the levels of the entry point")
+	}
+
+	noLevel := valid()
+	noLevel.Nodes[0].Neighbors = nil
+	if _, err = load(noLevel); err == nil {
+		t.Fatalf("we expected an error for an entry point without any level")
+	}
+
+	noDim := valid()
+	noDim.HNSW = nil
+	noDim.Dim = 0
+	noDim.Nodes = []testIndexFileNode{{ID: "a"}}
+	if _, err = load(noDim); err == nil {
+		t.Fatalf("we expected an error for entries without dimension")
+	}
+
+	emptyEntry := valid()
+	emptyEntry.Nodes = nil
+	emptyEntry.Entry = 5
+	if _, err = load(emptyEntry); err == nil {
+		t.Fatalf("we expected an error for an empty index with an entry point")
+	}
+
+	empty := valid()
+	empty.Nodes = nil
+	empty.Entry = -1
+	empty.MaxLevel = 0
+	ix, err = load(empty)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if err = ix.Upsert("a", uni.SingleProviderEmbeddingFrom32([]float32{1, 0}), nil); err != nil {
+		t.Fatal("Error:", err)
+	}
+}
+
+func TestEmbeddingCodecs(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vector := make([]float32, 256)
+	for i := range vector {
+		vector[i] = float32(rng.Float64()*2 - 1)
+	}
+	vector[0], vector[1], vector[2], vector[3] = 0, 1, -65504, 1e-7
+
+	emb, err := uni.EmbeddingFrom32(uni.WithOpenAI(), vector)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	for _, tc := range []struct {
+		enc       uni.VectorEncoding
+		tolerance func(f float32) float64
+	}{
+		{uni.EncodingFloat64, func(float32) float64 { return 0 }},
+		{uni.EncodingFloat32, func(float32) float64 { return 0 }},
+		// 11 bits of precision, and subnormals down to 2^-24
+		{uni.EncodingFloat16, func(f float32) float64 { return math.Max(math.Abs(float64(f))/2048, 1.0/(1<<24)) }},
+		// Half a step of the scale, which is 65504/127 because of vector[2]
+		{uni.EncodingInt8, func(float32) float64 { return 65504.0 / 127 / 2 * 1.0001 }},
+	} {
+		b, err := emb.MarshalBinaryAs(tc.enc)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.enc, err)
+		}
+		decoded := uni.NewEmbedding()
+		if err = decoded.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%s: %v", tc.enc, err)
+		}
+		got, err := decoded.GetByProvider32(uni.WithOpenAI())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.enc, err)
+		}
+		if len(got) != len(vector) {
+			t.Fatalf("%s: we expected dimension %d, got %d", tc.enc, len(vector), len(got))
+		}
+		for i := range vector {
+			if d := math.Abs(float64(got[i] - vector[i])); d > tc.tolerance(vector[i]) {
+				t.Fatalf("%s: value %d decoded as %g instead of %g", tc.enc, i, got[i], vector[i])
+			}
+		}
+	}
+
+	b, err := json.Marshal(emb)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	decoded := uni.NewEmbedding()
+	if err = json.Unmarshal(b, decoded); err != nil {
+		t.Fatal("Error:", err)
+	}
+	got, err := decoded.GetByProvider32(uni.WithOpenAI())
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	for i := range vector {
+		if got[i] != vector[i] {
+			t.Fatalf("json: value %d decoded as %g instead of %g", i, got[i], vector[i])
+		}
+	}
+}
+
+func TestEmbeddingDecodeInvalid(t *testing.T) {
+	// One vector of dimension 3 for the model of the openai provider, which has dimension 1536
+	wire := []byte("UEMB\x01\x01")
+	wire = binary.AppendUvarint(wire, uint64(len("openai")))
+	wire = append(wire, "openai"...)
+	wire = binary.AppendUvarint(wire, uint64(len(openai.Embedding_V3_1536)))
+	wire = append(wire, openai.Embedding_V3_1536...)
+	wire = append(wire, byte(uni.EncodingFloat32))
+	wire = binary.AppendUvarint(wire, 3)
+	wire = append(wire, make([]byte, 12)...)
+
+	err := uni.NewEmbedding().UnmarshalBinary(wire)
+	if !errors.Is(err, uni.ErrDimensionMismatch) {
+		t.Fatalf("we expected ErrDimensionMismatch for the dimension of the model, got %v", err)
+	}
+
+	// The dimension announced does not fit in the data
+	announced := append([]byte(nil), wire[:len(wire)-13]...)
+	announced = binary.AppendUvarint(announced, 1<<40)
+	announced = append(announced, make([]byte, 12)...)
+	err = uni.NewEmbedding().UnmarshalBinary(announced)
+	if !errors.Is(err, uni.ErrDimensionMismatch) {
+		t.Fatalf("we expected ErrDimensionMismatch for the dimension announced, got %v", err)
+	}
+
+	emb, err := uni.EmbeddingFrom32(uni.WithOpenAI(), []float32{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	valid, err := emb.MarshalBinaryAs(uni.EncodingInt8)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	for i := 0; i < len(valid); i++ {
+		if err := uni.NewEmbedding().UnmarshalBinary(valid[:i]); err == nil {
+			t.Fatalf("we expected an error for the encoding truncated to %d bytes", i)
+		}
+	}
+
+	// Random corruptions must be rejected or decoded, never panic
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		b := append([]byte(nil), valid...)
+		for j := rng.Intn(4); j >= 0; j-- {
+			b[rng.Intn(len(b))] = byte(rng.Intn(256))
+		}
+		if rng.Intn(4) == 0 {
+			b = append(b, make([]byte, rng.Intn(16))...)
+			rng.Read(b[len(valid):])
+		}
+		uni.NewEmbedding().UnmarshalBinary(b)
+		uni.NewSingleProviderEmbedding().UnmarshalBinary(b)
+	}
+}
+
+func FuzzEmbeddingUnmarshalBinary(f *testing.F) {
+	emb, err := uni.EmbeddingFrom32(uni.WithOpenAI(), []float32{1, -2, 3.5, 0})
+	if err != nil {
+		f.Fatal("Error:", err)
+	}
+	for _, enc := range []uni.VectorEncoding{uni.EncodingFloat64, uni.EncodingFloat32, uni.EncodingFloat16, uni.EncodingInt8} {
+		b, err := emb.MarshalBinaryAs(enc)
+		if err != nil {
+			f.Fatal("Error:", err)
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		uni.NewEmbedding().UnmarshalBinary(b)
+		uni.NewSingleProviderEmbedding().UnmarshalBinary(b)
+	})
+}
+
+type schemaTestNode struct {
+	Name     string            `json:"name"`
+	Children []*schemaTestNode `json:"children"`
+}
+
+type schemaTestBase struct {
+	ID int `json:"id"`
+}
+
+type schemaTestWeather struct {
+	schemaTestBase
+	City     string          `json:"city" description:"The city of the user"`
+	Unit     string          `json:"unit" enum:"celsius,fahrenheit"`
+	Days     []int           `json:"days" enum:"1,3,7"`
+	Note     *string         `json:"note"`
+	Wind     float64         `json:"wind,omitempty"`
+	At       time.Time       `json:"at"`
+	Tree     *schemaTestNode `json:"tree"`
+	Internal string          `json:"-"`
+}
+
+func TestGenerateSchema(t *testing.T) {
+	schema, err := openai.GenerateSchema(&schemaTestWeather{})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	if schema.Type != "object" || schema.AdditionalProperties == nil || *schema.AdditionalProperties {
+		t.Fatalf("we expected a strict object schema, got %+v", schema)
+	}
+	if got := strings.Join(schema.Required, ","); got != "id,city,unit,days,note,wind,at,tree" {
+		t.Fatalf("we expected every property to be required, got %s", got)
+	}
+	if schema.Properties["city"].Description != "The city of the user" {
+		t.Fatalf("we expected the description of city, got %+v", schema.Properties["city"])
+	}
+	if fmt.Sprint(schema.Properties["unit"].Enum) != "[celsius fahrenheit]" || fmt.Sprint(schema.Properties["days"].Items.Enum) != "[1 3 7]" {
+		t.Fatalf("we expected the enums of unit and days, got %+v and %+v", schema.Properties["unit"], schema.Properties["days"].Items)
+	}
+	for _, name := range []string{"note", "wind", "tree"} {
+		if p := schema.Properties[name]; len(p.AnyOf) != 2 || p.AnyOf[1].Type != "null" {
+			t.Fatalf("we expected %s to be nullable, got %+v", name, p)
+		}
+	}
+	if schema.Properties["at"].Format != "date-time" {
+		t.Fatalf("we expected at to be a date-time, got %+v", schema.Properties["at"])
+	}
+	if schema.Properties["tree"].AnyOf[0].Ref != "#/$defs/schemaTestNode" || schema.Defs["schemaTestNode"] == nil {
+		t.Fatalf("we expected the recursive node to be defined once and referenced, got %+v", schema.Properties["tree"])
+	}
+
+	// The root type is referenced as #
+	nodeSchema, err := openai.GenerateSchema(schemaTestNode{})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ref := nodeSchema.Properties["children"].Items.Ref; ref != "#" || len(nodeSchema.Defs) != 0 {
+		t.Fatalf("we expected the root type to be referenced as #, got %s", ref)
+	}
+
+	for _, v := range []interface{}{
+		"not a struct",
+		struct {
+			Tags map[string]string `json:"tags"`
+		}{},
+		struct {
+			Any interface{} `json:"any"`
+		}{},
+		struct {
+			Flag bool `json:"flag" enum:"true"`
+		}{},
+	} {
+		if _, err := openai.GenerateSchema(v); err == nil {
+			t.Fatalf("we expected an error for %T", v)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := openai.GenerateSchema(&schemaTestWeather{})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	valid := `{"id":1,"city":"Paris","unit":"celsius","days":[1,7],"note":null,"wind":12.5,"at":"2024-06-01T12:00:00Z",
+		"tree":{"name":"root","children":[{"name":"leaf","children":[]}]}}`
+	if err := schema.Validate([]byte(valid)); err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	for name, doc := range map[string]string{
+		"missing property":    `{"id":1,"unit":"celsius","days":[],"note":null,"wind":null,"at":"2024-06-01T12:00:00Z","tree":null}`,
+		"additional property": `{"id":1,"city":"Paris","unit":"celsius","days":[],"note":null,"wind":null,"at":"2024-06-01T12:00:00Z","tree":null,"extra":1}`,
+		"enum":                `{"id":1,"city":"Paris","unit":"kelvin","days":[],"note":null,"wind":null,"at":"2024-06-01T12:00:00Z","tree":null}`,
+		"item enum":           `{"id":1,"city":"Paris","unit":"celsius","days":[2],"note":null,"wind":null,"at":"2024-06-01T12:00:00Z","tree":null}`,
+		"integer":             `{"id":1.5,"city":"Paris","unit":"celsius","days":[],"note":null,"wind":null,"at":"2024-06-01T12:00:00Z","tree":null}`,
+		"not nullable":        `{"id":1,"city":null,"unit":"celsius","days":[],"note":null,"wind":null,"at":"2024-06-01T12:00:00Z","tree":null}`,
+		"date-time":           `{"id":1,"city":"Paris","unit":"celsius","days":[],"note":null,"wind":null,"at":"yesterday","tree":null}`,
+		"recursive":           `{"id":1,"city":"Paris","unit":"celsius","days":[],"note":null,"wind":null,"at":"2024-06-01T12:00:00Z","tree":{"name":"root","children":[{"name":1,"children":[]}]}}`,
+		"invalid JSON":        `{"id":`,
+	} {
+		if err := schema.Validate([]byte(doc)); err == nil {
+			t.Fatalf("%s: we expected the document to be rejected", name)
+		}
+	}
+}
+
+func TestRequestErrorMessage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		if strings.HasPrefix(r.URL.Path, "/json/") {
+			fmt.Fprint(w, `{"message":"not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path, message string
+	}{
+		{"/json/", `{"message":"not found"}`},
+		{"/empty/", "status code 404"},
+	} {
+		client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL+tc.path))
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+
+		_, err = client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+			Model:    openai.GPT4_8k,
+			Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hello!"}},
+		})
+		var reqErr *openai.RequestError
+		if !errors.As(err, &reqErr) {
+			t.Fatalf("we expected a RequestError, got %v", err)
+		}
+		if reqErr.Error() != tc.message {
+			t.Fatalf("we expected the message %q, got %q", tc.message, reqErr.Error())
+		}
+	}
+}
+
+func TestContextLengthExceededWithoutCorrection(t *testing.T) {
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"Your messages are too long.","type":"invalid_request_error","code":"context_length_exceeded"}}`)
+	}))
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL), openai.WithRetryPolicy(10*time.Millisecond, 3, 2))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	defer client.Close()
+
+	_, err = client.CreateChatCompletion(context.Background(), &openai.ChatCompletionRequest{
+		Model:     openai.GPT4_8k,
+		MaxTokens: 100,
+		Messages:  []openai.ChatCompletionMessage{{Role: "user", Content: "Hello!"}},
+	})
+	if !errors.Is(err, openai.ErrContextLengthExceeded) {
+		t.Fatalf("we expected ErrContextLengthExceeded, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("we expected the request not to be retried without a correction, it was sent %d times", calls.Load())
+	}
+}
+
+type schemaTestPtrMarshaler struct{ A int }
+
+func (m *schemaTestPtrMarshaler) MarshalJSON() ([]byte, error) { return []byte(`"custom"`), nil }
+
+type schemaTestTextID struct{ N int }
+
+func (id schemaTestTextID) MarshalText() ([]byte, error) { return []byte(fmt.Sprint("id-", id.N)), nil }
+
+type schemaTestInner struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type schemaTestTaggedName struct {
+	Name string `json:"Name"`
+}
+
+type schemaTestUntaggedName struct {
+	Name int
+}
+
+type schemaTestLeft struct{ X int }
+
+type schemaTestRight struct{ X string }
+
+type schemaTestShadowing struct {
+	schemaTestInner
+	schemaTestTaggedName
+	schemaTestUntaggedName
+	schemaTestLeft
+	schemaTestRight
+	ID   string           `json:"id"`
+	Data []byte           `json:"data"`
+	Text schemaTestTextID `json:"text"`
+}
+
+func TestGenerateSchemaEncodingRules(t *testing.T) {
+	if _, err := openai.GenerateSchema(struct {
+		M schemaTestPtrMarshaler `json:"m"`
+	}{}); err == nil {
+		t.Fatalf("we expected an error for a type with a MarshalJSON method on its pointer")
+	}
+
+	schema, err := openai.GenerateSchema(schemaTestShadowing{})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if got := strings.Join(schema.Required, ","); got != "name,Name,id,data,text" {
+		t.Fatalf("we expected the properties encoding/json writes, got %s", got)
+	}
+	for name, typ := range map[string]string{"id": "string", "Name": "string", "data": "string", "text": "string"} {
+		if schema.Properties[name].Type != typ {
+			t.Fatalf("we expected %s to be a %s, got %+v", name, typ, schema.Properties[name])
+		}
+	}
+
+	v := schemaTestShadowing{ID: "outer", Data: []byte("hello"), Text: schemaTestTextID{N: 1}}
+	v.schemaTestInner.ID = 1
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if err = schema.Validate(b); err != nil {
+		t.Fatalf("the schema does not match the encoding of %s: %v", b, err)
+	}
+}
+
+func TestChatterFallbackToCohere(t *testing.T) {
+	openaiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`)
+	}))
+	defer openaiSrv.Close()
+
+	var cohereCalls atomic.Int32
+	cohereSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cohereCalls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer cohere-test" {
+			t.Errorf("unexpected authorization header %q", r.Header.Get("Authorization"))
+		}
+
+		var creq wcohere.ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&creq); err != nil {
+			t.Errorf("could not decode the cohere request: %v", err)
+		}
+		if creq.Message == "Hello" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"message":"invalid request"}`)
+			return
+		}
+		if creq.Model != wcohere.CommandR || creq.Preamble != "Be brief.\n\nAnswer in english." || creq.Message != "" || creq.MaxTokens != 0 {
+			t.Errorf("unexpected cohere request %+v", creq)
+		}
+		if len(creq.ChatHistory) != 2 || creq.ChatHistory[0].Role != wcohere.ChatRoleUser || creq.ChatHistory[0].Message != "What is the weather in Paris?" ||
+			creq.ChatHistory[1].Role != wcohere.ChatRoleChatbot || len(creq.ChatHistory[1].ToolCalls) != 1 {
+			t.Errorf("unexpected chat history %+v", creq.ChatHistory)
+		}
+		if len(creq.ToolResults) != 1 || creq.ToolResults[0].Call.Name != "weather" || creq.ToolResults[0].Call.Parameters["city"] != "Paris" ||
+			creq.ToolResults[0].Outputs[0]["result"] != "sunny" {
+			t.Errorf("unexpected tool results %+v", creq.ToolResults)
+		}
+		if len(creq.Tools) != 1 || creq.Tools[0].ParameterDefinitions["city"].Type != "str" || !creq.Tools[0].ParameterDefinitions["city"].Required {
+			t.Errorf("unexpected tools %+v", creq.Tools)
+		}
+
+		fmt.Fprint(w, `{"text":"It is sunny in Paris.","generation_id":"gen","finish_reason":"COMPLETE","meta":{"billed_units":{"input_tokens":100,"output_tokens":10}}}`)
+	}))
+	defer cohereSrv.Close()
+
+	client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(openaiSrv.URL), openai.WithRetryPolicy(10*time.Millisecond, 3, 2))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	defer client.Close()
+
+	defaultClient, chatURL := openai.DefaultClient, wcohere.ChatURL
+	openai.DefaultClient, wcohere.ChatURL = client, cohereSrv.URL
+	defer func() {
+		openai.DefaultClient, wcohere.ChatURL = defaultClient, chatURL
+	}()
+
+	type weatherParams struct {
+		City string `json:"city"`
+	}
+	tool, err := uni.NewChatTool("weather", "Get the weather of a city", weatherParams{})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	// The negative max tokens of openai must not lower the budget estimate of cohere below the price of the input
+	var estimates []float64
+	tracker, err := usage.NewTracker(
+		usage.WithBudget(usage.Budget{Name: "cohere", Limit: 1e-12, Soft: true, Provider: "cohere"}),
+		usage.WithSoftLimitHandler(func(status usage.BudgetStatus, estimate float64) {
+			estimates = append(estimates, estimate)
+		}),
+	)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	req := &uni.ChatRequest{
+		Messages: []uni.ChatMessage{
+			{Role: uni.ChatRoleSystem, Content: "Be brief."},
+			{Role: uni.ChatRoleSystem, Content: "Answer in english."},
+			{Role: uni.ChatRoleUser, Content: "What is the weather in Paris?"},
+			{Role: uni.ChatRoleAssistant, ToolCalls: []uni.ChatToolCall{{ID: "call_1", Name: "weather", Arguments: `{"city":"Paris"}`}}},
+			{Role: uni.ChatRoleTool, ToolCallID: "call_1", Content: "sunny"},
+		},
+		Tools:     []uni.ChatTool{tool},
+		MaxTokens: -3,
+	}
+
+	chatter := uni.NewChatter(uni.WithOpenAIChat("", ""), uni.WithCohereChat("", "cohere-test"))
+	resp, err := chatter.Chat(usage.NewContext(context.Background(), tracker), req)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	if resp.Provider != uni.WithCohere() || resp.Message.Content != "It is sunny in Paris." || resp.FinishReason != uni.ChatFinishStop {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if resp.Usage.InputTokens != 100 || resp.Usage.OutputTokens != 10 || math.Abs(resp.Price-(100*0.00000015+10*0.0000006)) > 1e-12 {
+		t.Fatalf("unexpected usage %+v and price %f", resp.Usage, resp.Price)
+	}
+	if len(resp.Errors) != 1 || !errors.Is(resp.Errors[0], openai.ErrInvalidAPIKey) {
+		t.Fatalf("we expected the invalid api key error of openai, got %v", resp.Errors)
+	}
+	req.MaxTokens = 0
+	if _, err = chatter.Chat(usage.NewContext(context.Background(), tracker), req, uni.WithCohere()); err != nil {
+		t.Fatal("Error:", err)
+	}
+	if len(estimates) != 2 || estimates[0] <= 0 || estimates[0] != estimates[1] {
+		t.Fatalf("we expected the same positive budget estimate with and without negative max tokens, got %v", estimates)
+	}
+
+	// Client errors of cohere are not retried
+	_, err = chatter.Chat(context.Background(), &uni.ChatRequest{
+		Messages: []uni.ChatMessage{{Role: uni.ChatRoleUser, Content: "Hello"}},
+	}, uni.WithCohere())
+	if err == nil || !strings.Contains(err.Error(), "invalid request") || cohereCalls.Load() != 3 {
+		t.Fatalf("we expected the third cohere call to fail once, got %v after %d calls", err, cohereCalls.Load())
+	}
+}
+
+func TestLoadModels(t *testing.T) {
+	err := openai.LoadModels(strings.NewReader(`[
+		{"model":"test-registry-small","family":"test-registry","encoding":"o200k_base","context_length":8192,"supports_tools":true,
+		 "pricing":{"prompt":0.001,"completion":0.002,"fine_tuned_prompt":0.003,"fine_tuned_completion":0.004,"training":0.005},
+		 "next_context_length":"test-registry-large"},
+		{"model":"test-registry-large","family":"test-registry","encoding":"o200k_base","context_length":128000,
+		 "pricing":{"prompt":0.01,"completion":0.02}}
+	]`))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	info, err := openai.LookupModel("test-registry-small")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if info.Family != "test-registry" || info.ContextLength != 8192 || !info.SupportsTools || info.Pricing.Prompt != 0.001 {
+		t.Fatalf("unexpected model info %+v", info)
+	}
+
+	ok, next, err := openai.Model("test-registry-small").GetSimilarWithNextContextLength()
+	if err != nil || !ok || next != "test-registry-large" {
+		t.Fatalf("we expected the next context length test-registry-large, got %v %s %v", ok, next, err)
+	}
+	if length, err := next.GetContextLength(); err != nil || length != 128000 {
+		t.Fatalf("we expected a context length of 128000, got %d %v", length, err)
+	}
+
+	// A fine-tuned model inherits the definition of its base model, with the fine-tuned prices
+	ft, err := openai.LookupModel("ft:test-registry-small:my-org:custom:abc123")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ft.ContextLength != 8192 || ft.NextContextLength != "" || ft.Pricing.Prompt != 0.003 || ft.Pricing.Completion != 0.004 {
+		t.Fatalf("unexpected fine-tuned model info %+v", ft)
+	}
+
+	// The returned definitions are copies
+	info.Pricing.Prompt = 1
+	if info, _ = openai.LookupModel("test-registry-small"); info.Pricing.Prompt != 0.001 {
+		t.Fatalf("the registry was modified through a returned model info")
+	}
+
+	var found int
+	models := openai.RegisteredModels()
+	for i, m := range models {
+		if i > 0 && models[i-1].Model >= m.Model {
+			t.Fatalf("the registered models are not sorted: %s before %s", models[i-1].Model, m.Model)
+		}
+		if m.Family == "test-registry" {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatalf("we found %d of the 2 loaded models", found)
+	}
+
+	// Invalid definitions are rejected, without registering the valid ones loaded with them
+	for _, invalid := range []string{
+		`[{"model":"test-registry-partial"},{"model":""}]`,
+		`[{"model":"test-registry-partial"},{"model":"test-registry-encoding","encoding":"unknown_base"}]`,
+		`[{"model":"test-registry-partial"},{"model":"test-registry-negative","context_length":-1}]`,
+		`[{"model":"test-registry-partial"},{"model":"test-registry-self","next_context_length":"test-registry-self"}]`,
+		`{"model":"test-registry-partial"}`,
+	} {
+		if err := openai.LoadModels(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("we expected an error loading %s", invalid)
+		}
+	}
+	if _, err := openai.LookupModel("test-registry-partial"); !errors.Is(err, openai.ErrUnknownModel) {
+		t.Fatalf("we expected ErrUnknownModel for a model of a rejected file, got %v", err)
+	}
+	if _, err := openai.LookupModel("ft:test-registry-unknown:my-org::abc123"); !errors.Is(err, openai.ErrUnknownModel) {
+		t.Fatalf("we expected ErrUnknownModel for a fine-tuned model of an unknown base model, got %v", err)
+	}
+}
+
+func TestRunConversation(t *testing.T) {
+	type addArgs struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+	registry := openai.NewToolRegistry()
+	err := openai.RegisterTool(registry, "add", "Add two numbers", func(ctx context.Context, args addArgs) (int, error) {
+		return args.A + args.B, nil
+	})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	err = openai.RegisterTool(registry, "divide", "Divide two numbers", func(ctx context.Context, args addArgs) (int, error) {
+		return args.A / args.B, nil
+	})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	toolCalls := `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o-mini","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","tool_calls":[
+		{"id":"call_add","type":"function","function":{"name":"add","arguments":"{\"a\":2,\"b\":3}"}},
+		{"id":"call_divide","type":"function","function":{"name":"divide","arguments":"{\"a\":1,\"b\":0}"}}]}}],
+		"usage":{"prompt_tokens":1000,"completion_tokens":100,"total_tokens":1100}}`
+	answer := `{"id":"chatcmpl-2","object":"chat.completion","model":"gpt-4o-mini","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"2 + 3 = 5"}}],
+		"usage":{"prompt_tokens":1000,"completion_tokens":100,"total_tokens":1100}}`
+
+	var answerAfter atomic.Int64
+	answerAfter.Store(1)
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("could not decode the request: %v", err)
+		}
+		if len(req.Tools) != 2 || len(req.Messages) != 1+3*int(n-1) {
+			t.Errorf("call %d: unexpected request with %d tools and %d messages", n, len(req.Tools), len(req.Messages))
+		}
+
+		if n > answerAfter.Load() {
+			fmt.Fprint(w, answer)
+			return
+		}
+		fmt.Fprint(w, toolCalls)
+	}))
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL), openai.WithRetryPolicy(10*time.Millisecond, 3, 2))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	defer client.Close()
+
+	newRequest := func() *openai.RunConversationRequest {
+		return &openai.RunConversationRequest{
+			ChatCompletionRequest: &openai.ChatCompletionRequest{
+				Model:    openai.GPT4o_Mini_128k,
+				Messages: []openai.ChatCompletionMessage{{Role: openai.User, Content: "What is 2 + 3 and 1 / 0?"}},
+			},
+			Registry: registry,
+		}
+	}
+
+	var steps int
+	req := newRequest()
+	req.OnStep = func(step *openai.ConversationStep) {
+		steps++
+	}
+	resp, err := client.RunConversation(context.Background(), req)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	if len(resp.Steps) != 2 || steps != 2 || len(resp.Messages) != 5 || len(req.ChatCompletionRequest.Messages) != 1 {
+		t.Fatalf("unexpected conversation with %d steps, %d callbacks and %d messages", len(resp.Steps), steps, len(resp.Messages))
+	}
+	results := resp.Steps[0].ToolCalls
+	if len(results) != 2 || results[0].Output != "5" || results[0].Err != nil || results[1].Err == nil || !strings.HasPrefix(results[1].Output, "error: ") {
+		t.Fatalf("unexpected tool call results %+v", results)
+	}
+	if resp.Messages[2].ToolCallID != "call_add" || resp.Messages[3].ToolCallID != "call_divide" || resp.Messages[4].Content != "2 + 3 = 5" {
+		t.Fatalf("unexpected messages %+v", resp.Messages)
+	}
+	if expected := 2 * (1000*0.00015 + 100*0.0006) / 1000; math.Abs(resp.Price-expected) > 1e-12 {
+		t.Fatalf("we expected a price of %f, got %f", expected, resp.Price)
+	}
+
+	// The budget is checked after each iteration calling tools
+	calls.Store(0)
+	answerAfter.Store(100)
+	req = newRequest()
+	req.MaxPrice = 0.0004
+	resp, err = client.RunConversation(context.Background(), req)
+	if !errors.Is(err, openai.ErrConversationBudgetExceeded) || !errors.Is(err, usage.ErrBudgetExceeded) {
+		t.Fatalf("we expected ErrConversationBudgetExceeded wrapping usage.ErrBudgetExceeded, got %v", err)
+	}
+	if len(resp.Steps) != 2 || calls.Load() != 2 {
+		t.Fatalf("we expected the conversation to stop after 2 steps, got %d steps and %d calls", len(resp.Steps), calls.Load())
+	}
+
+	calls.Store(0)
+	req = newRequest()
+	req.MaxIterations = 3
+	resp, err = client.RunConversation(context.Background(), req)
+	if !errors.Is(err, openai.ErrMaxIterations) || len(resp.Steps) != 3 || len(resp.Messages) != 10 {
+		t.Fatalf("we expected ErrMaxIterations after 3 steps, got %v with %d steps", err, len(resp.Steps))
+	}
+}
+
+func TestCountImageTokens(t *testing.T) {
+	for _, tc := range []struct {
+		model         openai.Model
+		width, height int
+		detail        openai.ImageDetail
+		expected      int
+	}{
+		// Examples of the openai documentation
+		{openai.GPT4o_128k, 1024, 1024, openai.ImageDetailHigh, 765},
+		{openai.GPT4o_128k, 2048, 4096, openai.ImageDetailHigh, 1105},
+		{openai.GPT4o_128k, 4096, 8192, openai.ImageDetailLow, 85},
+		{openai.GPT4o_128k, 512, 512, openai.ImageDetailAuto, 255},
+		{openai.GPT4o_128k, 600, 300, "", 425},
+		// The largest image when the size is unknown
+		{openai.GPT4o_128k, 0, 0, openai.ImageDetailHigh, 85 + 8*170},
+		{openai.GPT4o_Mini_128k, 1024, 1024, openai.ImageDetailHigh, 2833 + 4*5667},
+		{openai.GPT4o_Mini_128k, 1024, 1024, openai.ImageDetailLow, 2833},
+	} {
+		n, err := openai.CountImageTokens(tc.model, tc.width, tc.height, tc.detail)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if n != tc.expected {
+			t.Fatalf("%s %dx%d %s: we counted %d tokens instead of %d", tc.model, tc.width, tc.height, tc.detail, n, tc.expected)
+		}
+	}
+
+	if _, err := openai.CountImageTokens(openai.Embedding_V3_1536, 512, 512, openai.ImageDetailHigh); err == nil {
+		t.Fatalf("we expected an error for a model without image inputs")
+	}
+	if _, err := openai.CountImageTokens("unknown-vision-model", 512, 512, openai.ImageDetailHigh); !errors.Is(err, openai.ErrUnknownModel) {
+		t.Fatalf("we expected ErrUnknownModel, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 600, 300))); err != nil {
+		t.Fatal("Error:", err)
+	}
+	part, err := openai.NewImagePartFromBytes(buf.Bytes(), openai.ImageDetailHigh)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if part.ImageURL.Width != 600 || part.ImageURL.Height != 300 || !strings.HasPrefix(part.ImageURL.URL, "data:image/png;base64,") {
+		t.Fatalf("unexpected image part %+v", part.ImageURL)
+	}
+	if _, err := openai.NewImagePartFromBytes([]byte("not an image"), openai.ImageDetailHigh); err == nil {
+		t.Fatalf("we expected an error for data which is not an image")
+	}
+}
+
+func TestConversationCompaction(t *testing.T) {
+	err := openai.RegisterModel(openai.ModelInfo{
+		Model: "test-conversation", Family: "test-conversation", Encoding: "cl100k_base", ContextLength: 400,
+		Pricing: openai.ModelPricing{Prompt: 0.001, Completion: 0.002},
+	})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	var mu sync.Mutex
+	var sent [][]openai.ChatCompletionMessage
+	var summaries atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("could not decode the request: %v", err)
+		}
+
+		content := strings.TrimSpace(strings.Repeat("answer ", 40))
+		if s, _ := req.Messages[0].Content.(string); strings.HasPrefix(s, "Summarize") {
+			summaries.Add(1)
+			content = fmt.Sprint("summary ", summaries.Load())
+		} else {
+			mu.Lock()
+			sent = append(sent, req.Messages)
+			mu.Unlock()
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"model":   req.Model,
+			"choices": []any{map[string]any{"index": 0, "finish_reason": "stop", "message": map[string]any{"role": "assistant", "content": content}}},
+			"usage":   map[string]any{"prompt_tokens": 100, "completion_tokens": 10, "total_tokens": 110},
+		})
+	}))
+	defer srv.Close()
+
+	client, err := openai.NewClient(openai.WithAPIKey("test"), openai.WithBaseURL(srv.URL), openai.WithRetryPolicy(10*time.Millisecond, 3, 2))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	defer client.Close()
+
+	question := strings.TrimSpace(strings.Repeat("question ", 40))
+	run := func(cv *openai.Conversation, turns int) []openai.ChatCompletionMessage {
+		mu.Lock()
+		sent = nil
+		mu.Unlock()
+
+		cv.ReservedTokens = 100
+		cv.Add(openai.ChatCompletionMessage{Role: openai.System, Content: "You are a helpful assistant."})
+		for i := 0; i < turns; i++ {
+			_, err := client.ContinueConversation(context.Background(), cv, &openai.ChatCompletionRequest{
+				Messages: []openai.ChatCompletionMessage{{Role: openai.User, Content: question}},
+			})
+			if err != nil {
+				t.Fatal("Error:", err)
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(sent) != turns {
+			t.Fatalf("%s: we expected %d completions, the server got %d", cv.Strategy, turns, len(sent))
+		}
+		// The system prompt is pinned and the kept turns start with a user message
+		for _, messages := range sent {
+			if messages[0].Role != openai.System || messages[0].Content != "You are a helpful assistant." || messages[len(messages)-1].Role != openai.User {
+				t.Fatalf("%s: unexpected prompt %+v", cv.Strategy, messages)
+			}
+		}
+		return sent[len(sent)-1]
+	}
+
+	cv := openai.NewConversation("test-conversation", openai.MemoryDropOldest)
+	last := run(cv, 8)
+	if len(last) >= 2*8 || len(last)%2 != 0 || last[1].Role != openai.User {
+		t.Fatalf("drop oldest: we expected the oldest turns to be dropped, got %d messages", len(last))
+	}
+	if len(cv.Messages) != len(last)+1 || cv.Summary != "" || cv.Price != 8*(100*0.001+10*0.002)/1000 {
+		t.Fatalf("drop oldest: unexpected conversation with %d messages, summary %q and price %f", len(cv.Messages), cv.Summary, cv.Price)
+	}
+
+	cv = openai.NewConversation("test-conversation", openai.MemorySlidingWindow)
+	cv.WindowTurns = 2
+	last = run(cv, 4)
+	if len(last) != 4 || last[1].Role != openai.User || last[2].Role != openai.Assistant {
+		t.Fatalf("sliding window: we expected the system prompt and the last 2 turns, got %d messages", len(last))
+	}
+
+	cv = openai.NewConversation("test-conversation", openai.MemorySummarize)
+	cv.SummaryModel = "test-conversation"
+	cv.SummaryMaxTokens = 20
+	last = run(cv, 8)
+	if summaries.Load() == 0 || !strings.HasPrefix(cv.Summary, "summary ") {
+		t.Fatalf("summarize: we expected the oldest turns to be summarized, got %d summaries and %q", summaries.Load(), cv.Summary)
+	}
+	if s, _ := last[1].Content.(string); last[1].Role != openai.System || s != "Summary of the earlier conversation:\n"+cv.Summary || last[2].Role != openai.User {
+		t.Fatalf("summarize: we expected the summary after the system prompt, got %+v", last[:3])
+	}
+	if expected := float64(8+summaries.Load()) * (100*0.001 + 10*0.002) / 1000; math.Abs(cv.Price-expected) > 1e-12 {
+		t.Fatalf("summarize: we expected the summaries to be priced, got %f instead of %f", cv.Price, expected)
+	}
+
+	// A tool call is never separated from its result
+	cv = openai.NewConversation("test-conversation", openai.MemoryDropOldest)
+	cv.ReservedTokens = 100
+	cv.Add(
+		openai.ChatCompletionMessage{Role: openai.User, Content: question},
+		openai.ChatCompletionMessage{Role: openai.Assistant, ToolCalls: []*openai.ToolCall{{ID: "call_1", Type: "function", Function: &openai.Function{Name: "lookup", Arguments: `{}`}}}},
+		openai.ChatCompletionMessage{Role: openai.Tool, ToolCallID: "call_1", Content: question},
+		openai.ChatCompletionMessage{Role: openai.Assistant, Content: question},
+	)
+	mu.Lock()
+	sent = nil
+	mu.Unlock()
+	for i := 0; i < 4; i++ {
+		_, err = client.ContinueConversation(context.Background(), cv, &openai.ChatCompletionRequest{
+			Messages: []openai.ChatCompletionMessage{{Role: openai.User, Content: question}},
+		})
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+	}
+	for _, messages := range sent {
+		if messages[0].Role != openai.User {
+			t.Fatalf("tool calls: a kept turn starts with a %s message", messages[0].Role)
+		}
+	}
+
+	// The last turn must fit
+	cv = openai.NewConversation("test-conversation", openai.MemoryDropOldest)
+	_, err = client.ContinueConversation(context.Background(), cv, &openai.ChatCompletionRequest{
+		Messages: []openai.ChatCompletionMessage{{Role: openai.User, Content: strings.Repeat("question ", 400)}},
+	})
+	if err == nil || len(cv.Messages) != 0 {
+		t.Fatalf("we expected an error for a turn longer than the context window, got %v with %d messages", err, len(cv.Messages))
+	}
+}
+
+func TestPricesFile(t *testing.T) {
+	err := openai.RegisterModel(openai.ModelInfo{
+		Model: "test-pricing", Family: "test-pricing", Encoding: "o200k_base", ContextLength: 8192,
+		Pricing: openai.ModelPricing{Prompt: 0.004, Completion: 0.008, FineTunedPrompt: 0.01, FineTunedCompletion: 0.02},
+	})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "prices.json")
+	err = os.WriteFile(jsonPath, []byte(`[
+		{"model":"test-pricing","effective_from":"2024-06-01","pricing":{"prompt":0.002,"completion":0.004}},
+		{"model":"test-pricing","effective_from":"2024-01-01T12:00:00Z","pricing":{"prompt":0.003,"completion":0.006,"fine_tuned_prompt":0.005}}
+	]`), 0o644)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	yamlPath := filepath.Join(dir, "prices.yaml")
+	err = os.WriteFile(yamlPath, []byte(`
+- model: test-pricing
+  effective_from: 2025-01-01
+  pricing:
+    prompt: 0.001
+    completion: 0.002
+    per_image:
+      hd:
+        1024x1024: 0.08
+`), 0o644)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	if err = openai.LoadPricesFile(jsonPath); err != nil {
+		t.Fatal("Error:", err)
+	}
+	if err = openai.LoadPricesFile(yamlPath); err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	for _, tc := range []struct {
+		at     time.Time
+		prompt float64
+	}{
+		{time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), 0.004},
+		{time.Date(2024, 1, 1, 11, 59, 0, 0, time.UTC), 0.004},
+		{time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), 0.003},
+		{time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC), 0.003},
+		{time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), 0.002},
+		{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), 0.001},
+	} {
+		pricing, err := openai.GetPricingAt("test-pricing", tc.at)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if pricing.Prompt != tc.prompt {
+			t.Fatalf("%s: we got the prompt price %f instead of %f", tc.at, pricing.Prompt, tc.prompt)
+		}
+	}
+
+	pricing, err := openai.GetPricing("test-pricing")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if pricing.Completion != 0.002 || pricing.PerImage[openai.ImageQualityHD][openai.ImageSize1024x1024] != 0.08 {
+		t.Fatalf("unexpected current prices %+v", pricing)
+	}
+
+	// A fine-tuned model is billed the fine-tuned prices of its base model at the same time
+	pricing, err = openai.GetPricingAt("ft:test-pricing:my-org::abc123", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if pricing.Prompt != 0.005 || pricing.Completion != 0 {
+		t.Fatalf("unexpected fine-tuned prices %+v", pricing)
+	}
+
+	history := openai.PriceHistory("test-pricing")
+	if len(history) != 3 || !history[0].EffectiveFrom.Before(history[1].EffectiveFrom) || !history[1].EffectiveFrom.Before(history[2].EffectiveFrom) {
+		t.Fatalf("we expected 3 sorted prices, got %+v", history)
+	}
+
+	// Prices with the same effective date replace the previous ones
+	err = openai.LoadPrices(strings.NewReader(`[{"model":"test-pricing","effective_from":"2024-06-01","pricing":{"prompt":0.0025}}]`))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if pricing, _ = openai.GetPricingAt("test-pricing", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)); pricing.Prompt != 0.0025 || len(openai.PriceHistory("test-pricing")) != 3 {
+		t.Fatalf("we expected the prices of 2024-06-01 to be replaced, got %+v", pricing)
+	}
+
+	// Either all the prices are added or none
+	for _, invalid := range []string{
+		`[{"model":"test-pricing","effective_from":"2026-01-01","pricing":{"prompt":1}},{"model":"test-pricing-unknown","effective_from":"2026-01-01","pricing":{"prompt":1}}]`,
+		`[{"model":"test-pricing","effective_from":"2026-01-01","pricing":{"prompt":1}},{"model":"test-pricing","pricing":{"prompt":1}}]`,
+		`[{"model":"test-pricing","effective_from":"01/01/2026","pricing":{"prompt":1}}]`,
+	} {
+		if err = openai.LoadPrices(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("we expected an error loading %s", invalid)
+		}
+	}
+	if history = openai.PriceHistory("test-pricing"); len(history) != 3 {
+		t.Fatalf("we expected the invalid prices not to be added, got %+v", history)
+	}
+
+	txtPath := filepath.Join(dir, "prices.txt")
+	if err = os.WriteFile(txtPath, []byte(`[]`), 0o644); err != nil {
+		t.Fatal("Error:", err)
+	}
+	if err = openai.LoadPricesFile(txtPath); err == nil {
+		t.Fatalf("we expected an error for a prices file which is neither JSON nor YAML")
+	}
+
+	// The default prices change over time too
+	before, err := openai.GetPricingAt(openai.GPT4o_Mini_128k, time.Date(2024, 9, 30, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	after, err := openai.GetPricingAt(openai.GPT4o_Mini_128k, time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if before.CachedPrompt != 0 || after.CachedPrompt != 0.000075 {
+		t.Fatalf("we expected prompt caching prices from 2024-10-01, got %f and %f", before.CachedPrompt, after.CachedPrompt)
+	}
+}
+
+// testEmbeddingProvider embeds each text as its length, and fails for the text "fail"
+type testEmbeddingProvider struct {
+	name      string
+	batchSize int
+	batches   atomic.Int64
+}
+
+func (*testEmbeddingProvider) EmbedderOption() {}
+
+func (p *testEmbeddingProvider) Name() string { return p.name }
+
+func (p *testEmbeddingProvider) MaxBatchSize() int { return p.batchSize }
+
+func (p *testEmbeddingProvider) Dimensions() int { return 2 }
+
+func (p *testEmbeddingProvider) Price(inputTokens int) (float64, error) {
+	return float64(inputTokens) * 0.000001, nil
+}
+
+func (p *testEmbeddingProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	p.batches.Add(1)
+	if len(texts) > p.batchSize {
+		return nil, fmt.Errorf("we got a batch of %d texts for a maximum of %d", len(texts), p.batchSize)
+	}
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		if text == "fail" {
+			return nil, fmt.Errorf("we cannot embed %q", text)
+		}
+		vectors[i] = []float64{float64(len(text)), 1}
+	}
+	return vectors, nil
+}
+
+type testEmbeddingProvider32 struct {
+	testEmbeddingProvider
+}
+
+func (p *testEmbeddingProvider32) EmbeddingModel() string { return "test-model-32" }
+
+func (p *testEmbeddingProvider32) Embed32(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors, err := p.Embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	vectors32 := make([][]float32, len(vectors))
+	for i, v := range vectors {
+		vectors32[i] = []float32{float32(v[0]), float32(v[1])}
+	}
+	return vectors32, nil
+}
+
+func TestEmbeddingProviderRegistry(t *testing.T) {
+	p64 := &testEmbeddingProvider{name: "test-provider-64", batchSize: 3}
+	p32 := &testEmbeddingProvider32{testEmbeddingProvider{name: "test-provider-32", batchSize: 2}}
+	for _, p := range []uni.EmbeddingProvider{p64, p32} {
+		if err := uni.RegisterEmbeddingProvider(p); err != nil {
+			t.Fatal("Error:", err)
+		}
+	}
+
+	names := strings.Join(uni.RegisteredEmbeddingProviders(), ",")
+	if !strings.Contains(names, "cohere,openai,test-provider-32,test-provider-64") {
+		t.Fatalf("unexpected registered providers %s", names)
+	}
+	if p, err := uni.LookupEmbeddingProvider("test-provider-32"); err != nil || p != uni.EmbeddingProvider(p32) {
+		t.Fatalf("we expected the registered provider, got %v %v", p, err)
+	}
+
+	texts := []string{"a", "bb", "fail", "dddd", "eeeee"}
+	embedder := uni.NewEmbedder(uni.WithProvider("test-provider-64"), p32)
+	embeddings, err := embedder.BatchEmbed(context.Background(), texts)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if p64.batches.Load() != 2 || p32.batches.Load() != 3 {
+		t.Fatalf("we expected batches of at most 3 and 2 texts, got %d and %d batches", p64.batches.Load(), p32.batches.Load())
+	}
+
+	for i, emb := range embeddings {
+		// The batches with the text "fail" failed
+		failed64, failed32 := i < 3, i == 2 || i == 3
+		if err := emb.ByProviderError(uni.WithProvider("test-provider-64")); (err != nil) != failed64 {
+			t.Fatalf("text %d: unexpected error %v", i, err)
+		}
+		if err := emb.ByProviderError(uni.WithProvider("test-provider-32")); (err != nil) != failed32 {
+			t.Fatalf("text %d: unexpected error %v", i, err)
+		}
+
+		if !failed64 {
+			v, err := emb.GetByProvider(uni.WithProvider("test-provider-64"))
+			if err != nil || len(v) != 2 || v[0] != float64(len(texts[i])) {
+				t.Fatalf("text %d: unexpected vector %v %v", i, v, err)
+			}
+		}
+		if !failed32 {
+			v, err := emb.GetByProvider32(uni.WithProvider("test-provider-32"))
+			if err != nil || len(v) != 2 || v[0] != float32(len(texts[i])) {
+				t.Fatalf("text %d: unexpected vector %v %v", i, v, err)
+			}
+			if model := emb.Model(uni.WithProvider("test-provider-32")); model != "test-model-32" {
+				t.Fatalf("text %d: we expected the model of the provider, got %q", i, model)
+			}
+		}
+	}
+
+	// opts restricts the providers used
+	single, err := embedder.Embed(context.Background(), "zz", uni.WithProvider("test-provider-32"))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if _, err = single.GetByProvider(uni.WithProvider("test-provider-64")); err == nil {
+		t.Fatalf("we expected no vector for the provider not selected")
+	}
+
+	spEmbedder := uni.NewSingleProviderEmbedder(uni.WithProvider("test-provider-64"))
+	spEmbedding, err := spEmbedder.Embed(context.Background(), "abc")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if v := spEmbedding.Get(); len(v) != 2 || v[0] != 3 || spEmbedding.Provider() != "test-provider-64" {
+		t.Fatalf("unexpected single provider embedding %v from %s", v, spEmbedding.Provider())
+	}
+
+	// Unknown and invalid providers
+	if _, err = uni.NewEmbedder(uni.WithProvider("test-provider-unknown")).BatchEmbed(context.Background(), texts); !errors.Is(err, uni.ErrUnknownProvider) {
+		t.Fatalf("we expected ErrUnknownProvider, got %v", err)
+	}
+	if _, err = embedder.BatchEmbed(context.Background(), texts, uni.WithProvider("test-provider-unknown")); !errors.Is(err, uni.ErrUnknownProvider) {
+		t.Fatalf("we expected ErrUnknownProvider, got %v", err)
+	}
+	if _, err = uni.EmbeddingFrom(uni.WithProvider("test-provider-unknown"), []float64{1}); !errors.Is(err, uni.ErrUnknownProvider) {
+		t.Fatalf("we expected ErrUnknownProvider, got %v", err)
+	}
+	if _, err = uni.LookupEmbeddingProvider("test-provider-unknown"); !errors.Is(err, uni.ErrUnknownProvider) {
+		t.Fatalf("we expected ErrUnknownProvider, got %v", err)
+	}
+	if err = uni.RegisterEmbeddingProvider(&testEmbeddingProvider{name: "test-provider-empty"}); err == nil {
+		t.Fatalf("we expected an error for a provider without a batch size")
+	}
+	if err = uni.RegisterEmbeddingProvider(&testEmbeddingProvider{batchSize: 1}); err == nil {
+		t.Fatalf("we expected an error for a provider without a name")
+	}
+}
+
+func TestTopK(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	vectors := randomVectors(rng, 200, 8)
+	candidates := make([]*uni.SingleProviderEmbedding, len(vectors))
+	for i, v := range vectors {
+		// Nil candidates are skipped
+		if i%50 == 10 {
+			continue
+		}
+		candidates[i] = uni.SingleProviderEmbeddingFrom32(v)
+	}
+	query := uni.SingleProviderEmbeddingFrom32(randomVectors(rng, 1, 8)[0])
+
+	for _, metric := range []uni.Metric{uni.MetricCosine, uni.MetricDotProduct, uni.MetricEuclidean, uni.MetricManhattan} {
+		var expected []uni.Match
+		for i, c := range candidates {
+			if c == nil {
+				continue
+			}
+			score, err := query.Compare(metric, c)
+			if err != nil {
+				t.Fatal("Error:", err)
+			}
+			expected = append(expected, uni.Match{Index: i, Score: score})
+		}
+		sort.SliceStable(expected, func(i, j int) bool { return metric.Closer(expected[i].Score, expected[j].Score) })
+
+		for _, k := range []int{1, 5, 196, 300} {
+			matches, err := uni.TopK(metric, query, candidates, k)
+			if err != nil {
+				t.Fatal("Error:", err)
+			}
+			want := expected[:min(k, len(expected))]
+			if fmt.Sprint(matches) != fmt.Sprint(want) {
+				t.Fatalf("%s k=%d: we got %v instead of %v", metric, k, matches, want)
+			}
+		}
+	}
+
+	if matches, err := uni.TopK(uni.MetricCosine, query, candidates, 0); err != nil || matches != nil {
+		t.Fatalf("we expected no match for k=0, got %v %v", matches, err)
+	}
+	if _, err := uni.TopK(uni.MetricCosine, query, []*uni.SingleProviderEmbedding{uni.SingleProviderEmbeddingFrom32([]float32{1})}, 1); err == nil {
+		t.Fatalf("we expected an error for a candidate of another dimension")
+	}
+	if _, err := uni.TopK("unknown", query, candidates, 1); err == nil {
+		t.Fatalf("we expected an error for an unknown metric")
+	}
+
+	// Ties are ordered by index
+	same := []*uni.SingleProviderEmbedding{
+		uni.SingleProviderEmbeddingFrom32([]float32{0, 1}),
+		uni.SingleProviderEmbeddingFrom32([]float32{1, 0}),
+		uni.SingleProviderEmbeddingFrom32([]float32{0, 1}),
+		uni.SingleProviderEmbeddingFrom32([]float32{1, 0}),
+		uni.SingleProviderEmbeddingFrom32([]float32{1, 0}),
+	}
+	matches, err := uni.TopK(uni.MetricEuclidean, uni.SingleProviderEmbeddingFrom32([]float32{1, 0}), same, 2)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if len(matches) != 2 || matches[0].Index != 1 || matches[1].Index != 3 || matches[0].Score != 0 {
+		t.Fatalf("we expected the first two exact matches, got %v", matches)
+	}
+}
+
+func TestWeightedCompare(t *testing.T) {
+	newEmbedding := func(openaiVector []float32, cohereVector []float64) *uni.Embedding {
+		emb := uni.NewEmbedding()
+		if openaiVector != nil {
+			if err := emb.SetByProvider32(uni.WithOpenAI(), openaiVector); err != nil {
+				t.Fatal("Error:", err)
+			}
+		}
+		if cohereVector != nil {
+			if err := emb.SetByProvider(uni.WithCohere(), cohereVector); err != nil {
+				t.Fatal("Error:", err)
+			}
+		}
+		return emb
+	}
+
+	query := newEmbedding([]float32{1, 0}, []float64{1, 0})
+	other := newEmbedding([]float32{1, 0}, []float64{0, 1})
+
+	for _, tc := range []struct {
+		weights  map[uni.WithProviderOption]float64
+		expected float64
+	}{
+		{nil, 0.5},
+		{map[uni.WithProviderOption]float64{uni.WithOpenAI(): 3, uni.WithCohere(): 1}, 0.75},
+		{map[uni.WithProviderOption]float64{uni.WithOpenAI(): 0, uni.WithCohere(): 1}, 0},
+		{map[uni.WithProviderOption]float64{uni.WithOpenAI(): 2}, 1},
+	} {
+		score, err := query.WeightedCompare(uni.MetricCosine, other, tc.weights)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if math.Abs(score-tc.expected) > 1e-9 {
+			t.Fatalf("%v: we got a score of %f instead of %f", tc.weights, score, tc.expected)
+		}
+	}
+
+	// Without weights only the providers in common are compared
+	if score, err := query.WeightedCompare(uni.MetricEuclidean, newEmbedding(nil, []float64{0, 1}), nil); err != nil || math.Abs(score-math.Sqrt2) > 1e-9 {
+		t.Fatalf("we expected the Euclidean distance of cohere only, got %f %v", score, err)
+	}
+
+	for _, weights := range []map[uni.WithProviderOption]float64{
+		{uni.WithOpenAI(): -1, uni.WithCohere(): 2},
+		{uni.WithOpenAI(): 0},
+		// The other embedding has no vector of openai
+		{uni.WithOpenAI(): 1, uni.WithCohere(): 1},
+	} {
+		if _, err := query.WeightedCompare(uni.MetricCosine, newEmbedding(nil, []float64{0, 1}), weights); err == nil {
+			t.Fatalf("%v: we expected an error", weights)
+		}
+	}
+	if _, err := newEmbedding([]float32{1, 0}, nil).WeightedCompare(uni.MetricCosine, newEmbedding(nil, []float64{1, 0}), nil); err == nil {
+		t.Fatalf("we expected an error for embeddings without provider in common")
+	}
+
+	// The candidates are ranked by their weighted score
+	candidates := []*uni.Embedding{
+		newEmbedding([]float32{0, 1}, []float64{0, 1}),
+		nil,
+		newEmbedding([]float32{1, 0}, []float64{0, 1}),
+		newEmbedding([]float32{1, 0}, []float64{1, 0}),
+		newEmbedding([]float32{0, 1}, []float64{1, 0}),
+	}
+	matches, err := uni.TopKEmbeddings(uni.MetricCosine, query, candidates, 3, map[uni.WithProviderOption]float64{uni.WithOpenAI(): 3, uni.WithCohere(): 1})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if fmt.Sprint(matches) != fmt.Sprint([]uni.Match{{Index: 3, Score: 1}, {Index: 2, Score: 0.75}, {Index: 4, Score: 0.25}}) {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+}